@@ -0,0 +1,149 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"backend/apierror"
+	"backend/auth"
+	"backend/billing"
+	"backend/mailer"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const defaultDeletionGrace = 14 * 24 * time.Hour
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeletionGracePeriod is how long a requested deletion can still be
+// cancelled, from ACCOUNT_DELETION_GRACE (a Go duration, default 336h).
+func DeletionGracePeriod() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultDeletionGrace
+}
+
+// RequestDeletion schedules the current user's account for deletion after
+// the grace period and signs out their other sessions. Accounts with a
+// password must confirm it. Guest accounts are deleted straight away.
+func RequestDeletion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DeleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body", err.Error())
+			return
+		}
+
+		var user model.User
+		if err := db.First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+			return
+		}
+		if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeIncorrectPassword, "Incorrect password")
+			return
+		}
+
+		if user.IsGuest {
+			if err := db.Transaction(func(tx *gorm.DB) error { return model.DeleteUser(tx, user.ID) }); err != nil {
+				apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to delete account", err.Error())
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "account deleted"})
+			return
+		}
+
+		if user.DeletionScheduledAt != nil {
+			apierror.AbortWithDetails(c, http.StatusConflict, apierror.CodeDeletionPending, "Account deletion already requested", gin.H{
+				"deletion_scheduled_at": user.DeletionScheduledAt,
+			})
+			return
+		}
+
+		scheduled := time.Now().Add(DeletionGracePeriod())
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("deletion_scheduled_at", scheduled).Error; err != nil {
+				return err
+			}
+			return auth.RevokeUserSessions(tx, user.ID, c.GetString("sessionID"))
+		})
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to schedule deletion", err.Error())
+			return
+		}
+
+		auth.SendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nYour account and all of your games will be deleted on %s, "+
+				"and any subscription will be cancelled. "+
+				"Until then you can sign in and cancel the deletion from your account settings.\n",
+				user.Username, scheduled.UTC().Format(time.RFC1123)),
+		})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":               "account deletion scheduled",
+			"deletion_scheduled_at": scheduled,
+		})
+	}
+}
+
+// CancelDeletion keeps the current user's account after a deletion request.
+func CancelDeletion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result := db.Model(&model.User{}).
+			Where("id = ? AND deletion_scheduled_at IS NOT NULL", c.MustGet("userID").(uint)).
+			Update("deletion_scheduled_at", nil)
+		if result.Error != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to cancel deletion", result.Error.Error())
+			return
+		}
+		if result.RowsAffected == 0 {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeNoDeletionPending, "No account deletion is pending")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "account deletion cancelled"})
+	}
+}
+
+// StartDeletionReaper periodically erases the accounts whose deletion grace
+// period has ended, cancelling their subscriptions with payments first.
+func StartDeletionReaper(db *gorm.DB, payments billing.Provider) {
+	go func() {
+		tick := time.Tick(time.Hour)
+		for {
+			deleted, err := reapAccounts(db, payments, time.Now())
+			if err != nil {
+				log.Println("Account deletion failed:", err)
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d account(s) at their owners' request", deleted)
+			}
+			<-tick
+		}
+	}()
+}
+
+// reapAccounts anonymises the accounts scheduled for deletion before now,
+// skipping those whose owners cancelled in the meantime. An account is only
+// erased once its subscriptions are cancelled, so nobody keeps paying for a
+// deleted account.
+func reapAccounts(db *gorm.DB, payments billing.Provider, now time.Time) (int, error) {
+	return model.ReapUsers(db, func(tx *gorm.DB, userID uint) error {
+		if err := billing.CancelUserSubscriptions(context.Background(), tx, payments, userID); err != nil {
+			return err
+		}
+		return model.AnonymiseUser(tx, userID)
+	}, "deletion_scheduled_at < ?", now)
+}
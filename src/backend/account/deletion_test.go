@@ -0,0 +1,95 @@
+package account
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/billing"
+	"backend/dbtest"
+)
+
+// TestReapAccountsSkipsCancelledDeletions checks that an account whose
+// owner cancelled its deletion after it was listed for reaping is kept.
+func TestReapAccountsSkipsCancelledDeletions(t *testing.T) {
+	const cancelled = 2
+	db, fake := dbtest.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, `SELECT "id" FROM "users"`):
+			// listed while both deletions were still scheduled
+			return []string{"id"}, [][]driver.Value{{int64(1)}, {int64(cancelled)}}
+		case strings.Contains(query, "FOR UPDATE"):
+			id := args[len(args)-2]
+			if id == int64(cancelled) && strings.Contains(query, "deletion_scheduled_at <") {
+				return nil, nil
+			}
+			return []string{"id"}, [][]driver.Value{{id}}
+		case strings.HasPrefix(query, `SELECT "id","username" FROM "users"`):
+			return []string{"id", "username"}, [][]driver.Value{{args[0], "player"}}
+		}
+		return nil, nil
+	})
+
+	deleted, err := reapAccounts(db, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted = %d; want 1", deleted)
+	}
+	updates := fake.Executed(`UPDATE "users"`)
+	if len(updates) == 0 {
+		t.Fatal("no account was anonymised")
+	}
+	for _, s := range updates {
+		if id := s.Args[len(s.Args)-1]; id != int64(1) {
+			t.Errorf("updated user %v: %s", id, s.Query)
+		}
+	}
+}
+
+// TestReapAccountsCancelsSubscriptions checks that an account is erased only
+// after its recurring subscription is cancelled with the provider.
+func TestReapAccountsCancelsSubscriptions(t *testing.T) {
+	respond := func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, `SELECT "id" FROM "users"`):
+			return []string{"id"}, [][]driver.Value{{int64(1)}}
+		case strings.Contains(query, "FOR UPDATE"):
+			return []string{"id"}, [][]driver.Value{{args[len(args)-2]}}
+		case strings.HasPrefix(query, `SELECT "id","username" FROM "users"`):
+			return []string{"id", "username"}, [][]driver.Value{{args[0], "player"}}
+		case strings.HasPrefix(query, `SELECT * FROM "subscriptions"`):
+			if args[1] != billing.PlanMonthly {
+				t.Errorf("listed %v subscriptions; want the recurring ones", args[1])
+			}
+			return []string{"id", "user_id", "provider", "provider_id", "plan", "status"},
+				[][]driver.Value{{int64(5), args[0], "fake", "sub_1", billing.PlanMonthly, billing.StatusActive}}
+		}
+		return nil, nil
+	}
+
+	db, fake := dbtest.Open(t, respond)
+	payments := billing.NewFakeProvider("secret")
+	deleted, err := reapAccounts(db, payments, time.Now())
+	if err != nil || deleted != 1 {
+		t.Fatalf("reapAccounts = %d, %v; want 1 account deleted", deleted, err)
+	}
+	if cancelled := payments.Cancelled(); len(cancelled) != 1 || cancelled[0] != "sub_1" {
+		t.Errorf("cancelled = %v; want sub_1", cancelled)
+	}
+	if updates := fake.Executed(`UPDATE "subscriptions" SET "status"`); len(updates) != 1 || updates[0].Args[0] != billing.StatusCanceled {
+		t.Errorf("subscription updates = %v; want it marked canceled", updates)
+	}
+
+	// Without a provider to cancel with, the account is kept
+	db, fake = dbtest.Open(t, respond)
+	if _, err := reapAccounts(db, nil, time.Now()); !errors.Is(err, billing.ErrBillingUnavailable) {
+		t.Errorf("error = %v; want %v", err, billing.ErrBillingUnavailable)
+	}
+	if updates := fake.Executed(`UPDATE "users"`); len(updates) != 0 {
+		t.Errorf("account anonymised without cancelling its subscription: %v", updates)
+	}
+}
@@ -0,0 +1,222 @@
+// Package account implements requests about a user's own data: exporting
+// everything stored about them and deleting their account.
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"backend/apierror"
+	"backend/auth"
+	"backend/game_manager"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Profile is the personal data stored on model.User. Secrets such as the
+// password hash and TOTP secret are never exported.
+type Profile struct {
+	ID                  uint       `json:"id"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	EmailVerifiedAt     *time.Time `json:"email_verified_at"`
+	Role                string     `json:"role"`
+	SubscriptionLevel   int        `json:"subscription_level"`
+	IsGuest             bool       `json:"is_guest"`
+	TwoFactorEnabled    bool       `json:"two_factor_enabled"`
+	CreatedAt           time.Time  `json:"created_at"`
+	LastSeenAt          *time.Time `json:"last_seen_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+}
+
+// Stats summarises a user's games.
+type Stats struct {
+	Games             int            `json:"games"`
+	HighestLevel      int            `json:"highest_level"`
+	GamesByDifficulty map[string]int `json:"games_by_difficulty"`
+	RoomsCleared      int            `json:"rooms_cleared"`
+	ChestsOpened      int            `json:"chests_opened"`
+}
+
+type LinkedIdentityDTO struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AccessTokenDTO struct {
+	Name       string     `json:"name"`
+	Scopes     string     `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type SubscriptionDTO struct {
+	Plan               string     `json:"plan"`
+	Status             string     `json:"status"`
+	CreatedAt          time.Time  `json:"created_at"`
+	CurrentPeriodStart *time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+}
+
+func newProfile(u model.User) Profile {
+	return Profile{
+		ID:                  u.ID,
+		Username:            u.Username,
+		Email:               u.Email,
+		EmailVerifiedAt:     u.EmailVerifiedAt,
+		Role:                u.Role,
+		SubscriptionLevel:   u.SubscriptionLevel,
+		IsGuest:             u.IsGuest,
+		TwoFactorEnabled:    u.TOTPEnabledAt != nil,
+		CreatedAt:           u.CreatedAt,
+		LastSeenAt:          u.LastSeenAt,
+		DeletionScheduledAt: u.DeletionScheduledAt,
+	}
+}
+
+func gameStats(games []game_manager.UserGameData) Stats {
+	stats := Stats{Games: len(games), GamesByDifficulty: map[string]int{}}
+	for _, g := range games {
+		file := g.SaveFile
+		if file.Config.Level > stats.HighestLevel {
+			stats.HighestLevel = file.Config.Level
+		}
+		stats.GamesByDifficulty[file.Config.Difficulty]++
+		for _, room := range file.Floor.Rooms {
+			if room.Cleared {
+				stats.RoomsCleared++
+			}
+			if room.Chest != nil && room.Chest.Opened {
+				stats.ChestsOpened++
+			}
+		}
+	}
+	return stats
+}
+
+// buildExport writes the archive of everything stored about a user.
+func buildExport(db *gorm.DB, user model.User) ([]byte, error) {
+	games, err := game_manager.UserGames(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := auth.UserSessions(db, user.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []model.LinkedIdentity
+	if err := db.Where("user_id = ?", user.ID).Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	identityDTOs := make([]LinkedIdentityDTO, 0, len(identities))
+	for _, i := range identities {
+		identityDTOs = append(identityDTOs, LinkedIdentityDTO{Provider: i.Provider, Email: i.Email, CreatedAt: i.CreatedAt})
+	}
+
+	var tokens []model.PersonalAccessToken
+	if err := db.Where("user_id = ?", user.ID).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	tokenDTOs := make([]AccessTokenDTO, 0, len(tokens))
+	for _, t := range tokens {
+		tokenDTOs = append(tokenDTOs, AccessTokenDTO{
+			Name: t.Name, Scopes: t.Scopes, CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, LastUsedAt: t.LastUsedAt,
+		})
+	}
+
+	var subscriptions []model.Subscription
+	if err := db.Where("user_id = ?", user.ID).Find(&subscriptions).Error; err != nil {
+		return nil, err
+	}
+	subscriptionDTOs := make([]SubscriptionDTO, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		subscriptionDTOs = append(subscriptionDTOs, SubscriptionDTO{
+			Plan: s.Plan, Status: s.Status, CreatedAt: s.CreatedAt,
+			CurrentPeriodStart: s.CurrentPeriodStart, CurrentPeriodEnd: s.CurrentPeriodEnd,
+		})
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", newProfile(user)},
+		{"stats.json", gameStats(games)},
+		{"sessions.json", sessions},
+		{"linked_identities.json", identityDTOs},
+		{"access_tokens.json", tokenDTOs},
+		{"subscriptions.json", subscriptionDTOs},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	readme, err := zw.Create("README.txt")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(readme, "Personal data of %s, exported %s.\n\n"+
+		"profile.json            your account details\n"+
+		"stats.json              a summary of your games\n"+
+		"sessions.json           devices currently signed in\n"+
+		"linked_identities.json  login providers linked to your account\n"+
+		"access_tokens.json      your personal access tokens (without the tokens themselves)\n"+
+		"subscriptions.json      plans you bought\n"+
+		"games/                  one save file per game, with its snapshot history;\n"+
+		"                        each save_file can be imported again\n",
+		user.Username, time.Now().UTC().Format(time.RFC3339))
+
+	for _, f := range files {
+		if err := writeJSON(zw, f.name, f.data); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range games {
+		if err := writeJSON(zw, fmt.Sprintf("games/game-%d.json", g.SaveFile.Game.ID), g); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// ExportData downloads a zip archive of everything stored about the current
+// user: profile, games with their floors, stats, sessions and linked logins.
+func ExportData(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user model.User
+		if err := db.First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+			return
+		}
+
+		archive, err := buildExport(db, user)
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to export data", err.Error())
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"the-last-game-data-%d.zip\"", user.ID))
+		c.Data(http.StatusOK, "application/zip", archive)
+	}
+}
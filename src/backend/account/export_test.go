@@ -0,0 +1,37 @@
+package account
+
+import (
+	"testing"
+
+	"backend/game_manager"
+)
+
+func TestGameStats(t *testing.T) {
+	game := func(level int, difficulty string, rooms ...game_manager.SaveRoom) game_manager.UserGameData {
+		var data game_manager.UserGameData
+		data.SaveFile.Config.Level = level
+		data.SaveFile.Config.Difficulty = difficulty
+		data.SaveFile.Floor.Rooms = rooms
+		return data
+	}
+	games := []game_manager.UserGameData{
+		game(3, "easy",
+			game_manager.SaveRoom{Cleared: true, Chest: &game_manager.SaveChest{Opened: true}},
+			game_manager.SaveRoom{Cleared: true, Chest: &game_manager.SaveChest{}},
+			game_manager.SaveRoom{}),
+		game(7, "hard", game_manager.SaveRoom{Cleared: true}),
+		game(5, "easy"),
+	}
+
+	stats := gameStats(games)
+	if stats.Games != 3 || stats.HighestLevel != 7 || stats.RoomsCleared != 3 || stats.ChestsOpened != 1 {
+		t.Errorf("stats = %+v; want 3 games, highest level 7, 3 rooms cleared, 1 chest opened", stats)
+	}
+	if len(stats.GamesByDifficulty) != 2 || stats.GamesByDifficulty["easy"] != 2 || stats.GamesByDifficulty["hard"] != 1 {
+		t.Errorf("games by difficulty = %v; want easy: 2, hard: 1", stats.GamesByDifficulty)
+	}
+
+	if empty := gameStats(nil); empty.Games != 0 || empty.GamesByDifficulty == nil {
+		t.Errorf("stats of no games = %+v; want zero with an empty map", empty)
+	}
+}
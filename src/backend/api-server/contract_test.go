@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/auth"
+	"backend/billing"
+	"backend/entitlements"
+	"backend/game_manager"
+	"backend/model"
+	"backend/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestSpecCoversRoutes checks that every /api/v1 route is described by the
+// specification, and that the specification describes no route that does not
+// exist.
+func TestSpecCoversRoutes(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter()
+
+	served := map[string]bool{}
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, spec.BasePath()+"/") {
+			continue
+		}
+		key := route.Method + " " + openapi.TemplatePath(strings.TrimPrefix(route.Path, spec.BasePath()))
+		served[key] = true
+		if spec.Operation(route.Method, route.Path) == nil {
+			t.Errorf("%s %s is not in the specification", route.Method, route.Path)
+		}
+	}
+	for path, item := range spec.Paths {
+		for method := range *item {
+			if key := strings.ToUpper(method) + " " + path; !served[key] {
+				t.Errorf("the specification describes %s, which is not served", key)
+			}
+		}
+	}
+}
+
+// TestSpecMatchesTypes checks that the JSON of the types the handlers answer
+// with matches their schema, with every field set.
+func TestSpecMatchesTypes(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]interface{}{
+		"Game":                 model.Game{},
+		"Floor":                model.Floor{},
+		"Room":                 model.Room{},
+		"Enemy":                model.Enemy{},
+		"Chest":                model.Chest{},
+		"Weapon":               model.Weapon{},
+		"Player":               model.Player{},
+		"Preferences":          model.UserPreferences{},
+		"Profile":              auth.ProfileDTO{},
+		"Session":              auth.SessionDTO{},
+		"AccessToken":          auth.AccessTokenDTO{},
+		"Subscription":         billing.SubscriptionDTO{},
+		"CheckoutSession":      billing.CheckoutSession{},
+		"Entitlements":         entitlements.Entitlements{},
+		"EntitlementsResponse": entitlements.Response{},
+		"Snapshot":             game_manager.SnapshotDTO{Reason: game_manager.SnapshotSave},
+		"GenerationJob":        game_manager.GenerationJobDTO{Kind: "game", Status: model.GenerationSucceeded},
+		"SaveFile":             game_manager.SaveFile{},
+	}
+	for name, value := range types {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Errorf("%s: no such schema", name)
+			continue
+		}
+		data, err := json.Marshal(sample(value))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if errs := spec.ValidateJSON(schema, data, true); len(errs) > 0 {
+			t.Errorf("%s does not match its schema: %v", name, errs)
+		}
+	}
+}
+
+// sampleDepth bounds how far sample follows pointers and slices of structs,
+// since the models refer back to each other.
+const sampleDepth = 3
+
+// sample returns a copy of value with every field set but its strings, which
+// are kept so enumerations can be given a valid value. Past sampleDepth,
+// pointers and slices of structs are left nil.
+func sample(value interface{}) interface{} {
+	v := reflect.New(reflect.TypeOf(value)).Elem()
+	v.Set(reflect.ValueOf(value))
+	return fill(v, 0).Interface()
+}
+
+func nested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func fill(v reflect.Value, depth int) reflect.Value {
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)))
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i), depth)
+			}
+		}
+	case reflect.Ptr:
+		if depth < sampleDepth {
+			v.Set(reflect.New(v.Type().Elem()))
+			fill(v.Elem(), depth+1)
+		}
+	case reflect.Slice:
+		if (depth < sampleDepth || !nested(v.Type())) && v.Type().Elem().Kind() != reflect.Uint8 {
+			v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+			fill(v.Index(0), depth+1)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			v.Set(reflect.MakeMap(v.Type()))
+			v.SetMapIndex(reflect.ValueOf("key").Convert(v.Type().Key()), fill(reflect.New(v.Type().Elem()).Elem(), depth+1))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Bool:
+		v.SetBool(true)
+	}
+	return v
+}
+
+// TestResponsesMatchSpec sends requests that can be answered without a
+// database and checks the responses against the specification.
+func TestResponsesMatchSpec(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRouter(openapi.Options{Responses: func(c *gin.Context, status int, errs []apierror.FieldError) {
+		t.Errorf("%s %s answered %d against the specification: %v", c.Request.Method, c.Request.URL.Path, status, errs)
+	}})
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/api/v1/oidc/providers", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/oidc/unknown/login", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/login", `{"username": 1}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/register", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/profile", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/game/1", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, w.Code, tt.status)
+			continue
+		}
+		// Responses written before the validator runs, such as failed
+		// authentication and the validator's own, are checked here.
+		route := strings.Replace(tt.path, "/game/1", "/game/:id", 1)
+		route = strings.Replace(route, "/oidc/unknown/", "/oidc/:provider/", 1)
+		schema, ok := spec.Operation(tt.method, route).ResponseSchema(w.Code)
+		if !ok {
+			t.Errorf("%s %s: %d is not documented", tt.method, tt.path, w.Code)
+			continue
+		}
+		if errs := spec.ValidateJSON(schema, w.Body.Bytes(), true); len(errs) > 0 {
+			t.Errorf("%s %s: %s does not match the specification: %v", tt.method, tt.path, w.Body, errs)
+		}
+	}
+}
+
+// TestRequestValidation checks that requests not matching the specification
+// are rejected with the fields at fault.
+func TestRequestValidation(t *testing.T) {
+	r := newTestRouter()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(`{"username": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				Fields []apierror.FieldError `json:"fields"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != apierror.CodeInvalidRequest {
+		t.Errorf("code = %q, want %q", body.Error.Code, apierror.CodeInvalidRequest)
+	}
+	fields := map[string]bool{}
+	for _, f := range body.Error.Details.Fields {
+		fields[f.Field] = true
+	}
+	if !fields["username"] || !fields["password"] {
+		t.Errorf("fields = %+v, want username and password", body.Error.Details.Fields)
+	}
+}
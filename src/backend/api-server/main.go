@@ -1,12 +1,16 @@
 package main
 
 import (
+	"backend/account"
 	"backend/auth"
-	"backend/game_manager"
+	"backend/billing"
+	"backend/entitlements"
+	"backend/mailer"
 	"backend/middleware"
 	"backend/model"
+	"backend/openapi"
+	"backend/ratelimit"
 	"log"
-	"net/http"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -15,87 +19,88 @@ import (
 func main() {
 	r := gin.Default()
 
-	// Register authentication routes
-	r.POST("/register", auth.Register)
-	r.POST("/login", auth.Login)
-	r.POST("/refresh", auth.RefreshToken)
+	// Client addresses come from the connection unless a trusted proxy
+	// forwarded the request
+	if err := trustProxies(r); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match", "If-None-Match", "Idempotency-Key", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "Authorization", "ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Idempotent-Replayed", "X-Request-ID", "Deprecation", "Sunset", "Link"},
 		AllowCredentials: true,
 	}))
+	r.Use(middleware.RequestID())
 
 	// Initialize DB
 	model.ConnectDB()
 	model.MigrateDB() //Uncomment this line when you run main.go for the first time
 
-	// Public Routes (No authentication required)
-	public := r.Group("/api")
-	{
-		public.POST("/register", auth.Register)
-		public.POST("/login", auth.Login)
-		public.POST("/refreshToken", auth.RefreshToken)
+	// Load the access token signing keys and keep rotating them
+	if err := auth.InitKeys(model.DB); err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	auth.StartKeyRotation(model.DB)
+
+	// Grant the admin role to the users named in ADMIN_USERNAMES
+	if err := auth.BootstrapAdmins(model.DB); err != nil {
+		log.Fatal("Failed to grant admin roles:", err)
+	}
+
+	// Email delivery for password resets
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+	auth.Mail = mail
+
+	// Delete guest accounts nobody has used in a while
+	auth.StartGuestReaper(model.DB)
+
+	// Identity providers players can sign in with
+	if err := auth.LoadOIDCProviders(); err != nil {
+		log.Fatal("Failed to configure login providers:", err)
+	}
+
+	// Payment provider for subscriptions
+	payments, err := billing.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure billing:", err)
+	}
+
+	// Erase accounts whose deletion grace period has ended
+	account.StartDeletionReaper(model.DB, payments)
 
+	// Forget idempotency keys once retries are no longer expected
+	middleware.StartIdempotencyCleanup(model.DB)
+
+	// Daily AI generation quotas per subscription tier
+	if err := entitlements.ConfigureFromEnv(); err != nil {
+		log.Fatal("Failed to configure generation quotas:", err)
+	}
+
+	// Token buckets throttling each user and client address
+	limitStore, err := ratelimit.StoreFromEnv(model.DB)
+	if err != nil {
+		log.Fatal("Failed to configure rate limiting:", err)
 	}
+	limiter := ratelimit.NewLimiter(limitStore)
 
-	// Protected Routes (Require JWT)
-	protected := r.Group("/api/protected")
-	protected.Use(middleware.AuthenticateMiddleware()) // Protect with JWT Authentication, encypt //DELETE middleware.Auth... to access without logging in
-	{
-		// game stuff
-		protected.POST("/create_game", game_manager.CreateGame)
-		protected.POST("/create_floor", game_manager.CreateFloor)
-		protected.POST("/save_game", game_manager.SaveGame(model.DB))
-		//protected.POST("/subscribe", auth.Subscribe)
-		//protected.POST("/unsubscribe", game_manager.Unsubscribe)
-
-		// get models
-		protected.GET("/get_user/:userId", game_manager.GetUser)
-		protected.GET("/get_games", game_manager.GetGames)
-		protected.GET("/get_player/:playerId", game_manager.GetPlayer)
-
-		// Enemy routes
-		protected.GET("/get_enemy/:enemyId", game_manager.GetEnemy)
-		protected.PUT("/enemy/:id/health", game_manager.SetEnemyHealthHandler)
-		protected.PUT("/enemy/:id/weapon", game_manager.SetEnemyWeaponHandler)
-		protected.DELETE("/enemy/:id", game_manager.DeleteEnemyHandler)
-
-		// Room routes
-		protected.GET("/room/:id", game_manager.GetRoomHandler)
-		protected.PUT("/room/:id/cleared", game_manager.SetRoomClearedHandler)
-		protected.PUT("/room/:id/chest", game_manager.SetRoomChestHandler)
-		protected.DELETE("/room/:id", game_manager.DeleteRoomHandler)
-
-		// Chest routes
-		protected.GET("/chest/:id", game_manager.GetChestHandler)
-		protected.PUT("/chest/:id/weapon", game_manager.SetChestWeaponHandler)
-		protected.DELETE("/chest/:id/weapon", game_manager.RemoveChestWeaponHandler)
-		protected.DELETE("/chest/:id", game_manager.DeleteChestHandler)
-
-		// Weapon routes
-		protected.GET("/weapon/:id", game_manager.GetWeaponHandler)
-		protected.PUT("/weapon/:id/damage", game_manager.SetWeaponDamageHandler)
-		protected.DELETE("/weapon/:id", game_manager.DeleteWeaponHandler)
-
-		// Floor routes
-		protected.GET("/floor/:id", game_manager.GetFloorHandler)
-		protected.PUT("/floor/:id/player", game_manager.SetFloorPlayerInHandler)
-		protected.DELETE("/floor/:id", game_manager.DeleteFloorHandler)
-		protected.PUT("/floor/:id/story", game_manager.SetFloorStoryTextHandler)
-
-		// Game routes
-		protected.GET("/game/:id", game_manager.GetGameHandler)
-		protected.PUT("/game/:id/level", game_manager.SetGameLevelHandler)
-		protected.DELETE("/game/:id", game_manager.DeleteGameHandler)
+	// OpenAPI specification, checking requests always and responses while
+	// developing
+	spec, err := openapi.Load()
+	if err != nil {
+		log.Fatal("Failed to load the OpenAPI specification:", err)
+	}
+	var specOpts openapi.Options
+	if gin.Mode() != gin.ReleaseMode {
+		specOpts.Responses = openapi.LogResponseErrors
 	}
 
-	// Handle Not Found Routes
-	r.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
-	})
+	// API routes, versioned and legacy
+	setupRoutes(r, spec, specOpts, payments, limiter)
 
 	// Start the Server
 	port := ":8080"
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustProxies makes the engine believe the X-Forwarded-For and X-Real-IP
+// headers only from the proxies listed in TRUSTED_PROXIES, a comma separated
+// list of addresses or CIDR ranges. By default no proxy is trusted and the
+// client address is the one the connection comes from, so clients cannot
+// pick the address their rate limits and login throttles are keyed by.
+func trustProxies(r *gin.Engine) error {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return r.SetTrustedProxies(proxies)
+}
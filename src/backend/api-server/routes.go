@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"backend/account"
+	"backend/apierror"
+	"backend/auth"
+	"backend/billing"
+	"backend/entitlements"
+	"backend/game_manager"
+	"backend/middleware"
+	"backend/model"
+	"backend/openapi"
+	"backend/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// legacySunset is when the unversioned routes, deprecated in favour of
+// /api/v1, stop being served.
+var legacySunset = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+// setupRoutes registers the API under /api/v1, and again under its old
+// unversioned paths as deprecated aliases. Requests to /api/v1 are checked
+// against the OpenAPI specification, and so are responses when opts asks.
+func setupRoutes(r *gin.Engine, spec *openapi.Document, opts openapi.Options, payments billing.Provider, limiter *ratelimit.Limiter) {
+	// Public keys for verifying access tokens
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+
+	// The OpenAPI description of /api/v1
+	r.GET("/api/openapi.json", openapi.ServeSpec)
+
+	validate := openapi.Validator(spec, opts)
+	registerAPI(r.Group("/api/v1"), validate, payments, limiter, false)
+
+	// Deprecated aliases, answering with the legacy error shape. They go
+	// through validate too, which passes them on unchecked because the
+	// specification does not describe their paths.
+	legacy := r.Group("/api", middleware.Deprecated("/api", "/api/v1", legacySunset))
+	registerAPI(legacy, validate, payments, limiter, true)
+	deprecated := middleware.Deprecated("", "/api/v1", legacySunset)
+	limit := publicLimit(limiter)
+	r.POST("/register", deprecated, limit, auth.Register)
+	r.POST("/login", deprecated, limit, auth.Login)
+	r.POST("/refresh", middleware.Deprecated("/refresh", "/api/v1/refresh_token", legacySunset), limit, auth.RefreshToken)
+
+	// Handle Not Found Routes
+	r.NoRoute(func(c *gin.Context) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeRouteNotFound, "Route not found")
+	})
+}
+
+// publicLimit throttles the routes that need no authentication per client
+// address. Every path to them shares the same buckets.
+func publicLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return limiter.Limit("public", ratelimit.Rate{}, ratelimit.Rate{Burst: 30, Interval: 2 * time.Second})
+}
+
+// registerAPI registers every API route under api, validating requests once
+// they are authenticated and within their rate limit. The unversioned API
+// kept authenticated routes under /protected and named the refresh route
+// /refreshToken.
+func registerAPI(api *gin.RouterGroup, validate gin.HandlerFunc, payments billing.Provider, limiter *ratelimit.Limiter, legacy bool) {
+	// Payment provider events, which arrive in bursts from a few addresses
+	api.POST("/billing/webhook", validate, billing.Webhook(model.DB, payments))
+
+	// Public Routes (No authentication required)
+	public := api.Group("")
+	public.Use(publicLimit(limiter), validate)
+	{
+		public.POST("/register", auth.Register)
+		public.POST("/login", auth.Login)
+		public.POST("/guest", auth.CreateGuest)
+		public.POST("/login/2fa", auth.LoginTwoFactor)
+		if legacy {
+			public.POST("/refreshToken", middleware.Deprecated("/api/refreshToken", "/api/v1/refresh_token", legacySunset), auth.RefreshToken)
+		} else {
+			public.POST("/refresh_token", auth.RefreshToken)
+		}
+		public.POST("/logout", auth.Logout)
+		public.POST("/forgot_password", auth.ForgotPassword)
+		public.POST("/reset_password", auth.ResetPassword)
+		public.POST("/verify_email", auth.VerifyEmail)
+		public.GET("/oidc/providers", auth.ListOIDCProviders)
+		public.GET("/oidc/:provider/login", auth.OIDCLogin)
+		public.POST("/oidc/:provider/callback", auth.OIDCCallback)
+	}
+
+	// Protected Routes (Require a JWT or a personal access token)
+	read := middleware.RequireScope(auth.ScopeGameRead)
+	write := middleware.RequireScope(auth.ScopeGameWrite)
+	session := middleware.RequireSession()
+	idempotent := middleware.Idempotency(&middleware.DBIdempotencyStore{DB: model.DB})
+	generation := limiter.Limit("generation",
+		ratelimit.Rate{Burst: 5, Interval: 30 * time.Second},
+		ratelimit.Rate{Burst: 10, Interval: 15 * time.Second})
+	protected := api.Group("")
+	if legacy {
+		protected = api.Group("/protected", middleware.Deprecated("/api/protected", "/api/v1", legacySunset))
+	}
+	protected.Use(middleware.AuthenticateMiddleware())
+	protected.Use(limiter.Limit("api",
+		ratelimit.Rate{Burst: 120, Interval: 500 * time.Millisecond},
+		ratelimit.Rate{Burst: 240, Interval: 250 * time.Millisecond}))
+	protected.Use(validate)
+	{
+		// game stuff
+		protected.POST("/create_game", write, idempotent, generation, game_manager.CreateGame)
+		protected.POST("/create_floor", write, idempotent, generation, game_manager.CreateFloor)
+		protected.POST("/save_game", write, idempotent, game_manager.SaveGame(model.DB))
+		protected.GET("/generation_jobs/:id", read, game_manager.GetGenerationJob(model.DB))
+
+		// Subscriptions
+		protected.POST("/billing/checkout", session, billing.Checkout(model.DB, payments))
+		protected.GET("/billing/subscription", session, billing.GetSubscription(model.DB))
+		protected.GET("/entitlements", read, entitlements.GetEntitlements(model.DB))
+
+		// get models
+		protected.GET("/get_user/:userId", read, game_manager.GetUser)
+		protected.GET("/get_games", read, game_manager.GetGames)
+		protected.GET("/get_player/:playerId", read, game_manager.GetPlayer)
+
+		// Two-factor authentication
+		protected.POST("/2fa/enroll", session, auth.EnrollTwoFactor)
+		protected.POST("/2fa/verify", session, auth.VerifyTwoFactor)
+		protected.POST("/2fa/recovery_codes", session, auth.RegenerateRecoveryCodes)
+		protected.POST("/2fa/disable", session, auth.DisableTwoFactor)
+
+		// Session routes
+		protected.POST("/resend_verification", session, auth.ResendVerification)
+		protected.GET("/sessions", session, auth.ListSessions)
+		protected.DELETE("/sessions", session, auth.RevokeOtherSessions)
+		protected.DELETE("/sessions/:id", session, auth.RevokeSession)
+
+		// Profile
+		protected.GET("/profile", session, auth.GetProfile)
+		protected.PUT("/profile/password", session, auth.ChangePassword)
+		protected.PUT("/profile/email", session, auth.ChangeEmail)
+		protected.PUT("/profile/username", session, auth.ChangeUsername)
+		protected.PUT("/profile/preferences", session, auth.UpdatePreferences)
+
+		// Guest upgrade
+		protected.POST("/guest/upgrade", session, auth.UpgradeGuest)
+		protected.GET("/guest/upgrade/oidc/:provider", session, auth.UpgradeGuestOIDC)
+
+		// Personal data
+		protected.GET("/account/export", session, account.ExportData(model.DB))
+		protected.POST("/account/delete", session, account.RequestDeletion(model.DB))
+		protected.DELETE("/account/delete", session, account.CancelDeletion(model.DB))
+
+		// Personal access tokens
+		protected.GET("/tokens", session, auth.ListAccessTokens)
+		protected.POST("/tokens", session, auth.CreateAccessToken)
+		protected.DELETE("/tokens/:id", session, auth.RevokeAccessToken)
+
+		// Enemy routes
+		protected.GET("/get_enemy/:enemyId", read, game_manager.GetEnemy)
+
+		// Room routes
+		protected.GET("/room/:id", read, game_manager.GetRoomHandler)
+
+		// Chest routes
+		protected.GET("/chest/:id", read, game_manager.GetChestHandler)
+
+		// Weapon routes
+		protected.GET("/weapon/:id", read, game_manager.GetWeaponHandler)
+
+		// Floor routes
+		protected.GET("/floor/:id", read, game_manager.GetFloorHandler)
+
+		// Game routes
+		protected.GET("/game/:id", read, game_manager.GetGameHandler)
+		protected.PATCH("/game/:id", write, game_manager.PatchGame(model.DB))
+		protected.GET("/game/:id/snapshots", read, game_manager.ListSnapshots(model.DB))
+		protected.GET("/game/:id/snapshots/:snapshotId", read, game_manager.GetSnapshot(model.DB))
+		protected.POST("/game/:id/snapshots/:snapshotId/restore", write, game_manager.RestoreSnapshot(model.DB))
+		protected.GET("/game/:id/export", read, game_manager.ExportGame(model.DB))
+		protected.POST("/games/import", write, game_manager.ImportGame(model.DB))
+		protected.DELETE("/game/:id", write, game_manager.DeleteGame(model.DB))
+	}
+
+	// Admin Routes (raw entity mutations, for debugging and support)
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthenticateMiddleware(), middleware.RequireSession(), middleware.RequireRole(model.RoleAdmin), validate)
+	{
+		admin.PUT("/user/:id/role", auth.SetUserRole)
+
+		// Enemy routes
+		admin.PUT("/enemy/:id/health", game_manager.SetEnemyHealthHandler)
+		admin.PUT("/enemy/:id/weapon", game_manager.SetEnemyWeaponHandler)
+		admin.DELETE("/enemy/:id", game_manager.DeleteEnemyHandler)
+
+		// Room routes
+		admin.PUT("/room/:id/cleared", game_manager.SetRoomClearedHandler)
+		admin.PUT("/room/:id/chest", game_manager.SetRoomChestHandler)
+		admin.DELETE("/room/:id", game_manager.DeleteRoomHandler)
+
+		// Chest routes
+		admin.PUT("/chest/:id/weapon", game_manager.SetChestWeaponHandler)
+		admin.DELETE("/chest/:id/weapon", game_manager.RemoveChestWeaponHandler)
+		admin.DELETE("/chest/:id", game_manager.DeleteChestHandler)
+
+		// Weapon routes
+		admin.PUT("/weapon/:id/damage", game_manager.SetWeaponDamageHandler)
+		admin.DELETE("/weapon/:id", game_manager.DeleteWeaponHandler)
+
+		// Floor routes
+		admin.PUT("/floor/:id/player", game_manager.SetFloorPlayerInHandler)
+		admin.DELETE("/floor/:id", game_manager.DeleteFloorHandler)
+		admin.PUT("/floor/:id/story", game_manager.SetFloorStoryTextHandler)
+
+		// Game routes
+		admin.PUT("/game/:id/level", game_manager.SetGameLevelHandler)
+		admin.DELETE("/game/:id", game_manager.DeleteGameHandler)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/apierror"
+	"backend/middleware"
+	"backend/openapi"
+	"backend/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter() *gin.Engine {
+	return newRouter(openapi.Options{})
+}
+
+func newRouter(opts openapi.Options) *gin.Engine {
+	spec, err := openapi.Load()
+	if err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := trustProxies(r); err != nil {
+		panic(err)
+	}
+	r.Use(middleware.RequestID())
+	setupRoutes(r, spec, opts, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()))
+	return r
+}
+
+func TestVersionedErrorEnvelope(t *testing.T) {
+	r := newTestRouter()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+	req.Header.Set(middleware.RequestIDHeader, "abc-123")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	var body apierror.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != apierror.CodeUnauthorized || body.Error.RequestID != "abc-123" || body.Error.Message == "" {
+		t.Errorf("unexpected envelope: %+v", body.Error)
+	}
+	if w.Header().Get("Deprecation") != "" {
+		t.Error("versioned routes should not be deprecated")
+	}
+}
+
+func TestLegacyAliases(t *testing.T) {
+	r := newTestRouter()
+	cases := map[string]string{
+		"/api/protected/profile": "</api/v1/profile>; rel=\"successor-version\"",
+		"/api/admin/user/1/role": "</api/v1/admin/user/1/role>; rel=\"successor-version\"",
+	}
+	for path, link := range cases {
+		method := http.MethodGet
+		if path == "/api/admin/user/1/role" {
+			method = http.MethodPut
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status = %d, want 401", path, w.Code)
+		}
+		if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") == "" {
+			t.Errorf("%s: missing deprecation headers: %v", path, w.Header())
+		}
+		if got := w.Header().Get("Link"); got != link {
+			t.Errorf("%s: Link = %q, want %q", path, got, link)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := body["error"].(string); !ok {
+			t.Errorf("%s: legacy routes should keep the flat error shape, got %v", path, body)
+		}
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	r := newTestRouter()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/nope", nil))
+
+	var body apierror.Response
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusNotFound || body.Error.Code != apierror.CodeRouteNotFound {
+		t.Errorf("got %d %+v", w.Code, body)
+	}
+	if body.Error.RequestID == "" || w.Header().Get(middleware.RequestIDHeader) != body.Error.RequestID {
+		t.Errorf("request ID should be generated and echoed, got %q", body.Error.RequestID)
+	}
+}
+
+// publicRequests sends the public limiter's burst plus one request, each
+// claiming a different forwarded address, and returns the last status.
+func publicRequests(r *gin.Engine, method, path string) int {
+	var status int
+	for i := 0; i <= 30; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		r.ServeHTTP(w, req)
+		status = w.Code
+	}
+	return status
+}
+
+func TestSpoofedForwardedForSharesBucket(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	if status := publicRequests(newTestRouter(), http.MethodGet, "/api/v1/oidc/providers"); status != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429 once the connection's bucket is empty", status)
+	}
+}
+
+func TestTrustedProxyForwardedFor(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	t.Setenv("TRUSTED_PROXIES", "192.0.2.1")
+	if status := publicRequests(newTestRouter(), http.MethodGet, "/api/v1/oidc/providers"); status != http.StatusOK {
+		t.Errorf("status = %d, want 200 with a bucket per forwarded address", status)
+	}
+}
+
+func TestLegacyRootRoutesRateLimited(t *testing.T) {
+	for _, path := range []string{"/register", "/login", "/refresh"} {
+		if status := publicRequests(newTestRouter(), http.MethodPost, path); status != http.StatusTooManyRequests {
+			t.Errorf("POST %s: status = %d, want 429 past the public burst", path, status)
+		}
+	}
+}
+
+func TestLegacyRootRoutesDeprecated(t *testing.T) {
+	r := newTestRouter()
+	cases := map[string]string{
+		"/register": "</api/v1/register>; rel=\"successor-version\"",
+		"/login":    "</api/v1/login>; rel=\"successor-version\"",
+		"/refresh":  "</api/v1/refresh_token>; rel=\"successor-version\"",
+	}
+	for path, link := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+
+		if got := w.Header().Values("Deprecation"); len(got) != 1 || got[0] != "true" {
+			t.Errorf("%s: Deprecation = %q, want a single true", path, got)
+		}
+		if got := w.Header().Values("Link"); len(got) != 1 || got[0] != link {
+			t.Errorf("%s: Link = %q, want %q", path, got, link)
+		}
+	}
+}
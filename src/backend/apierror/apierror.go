@@ -0,0 +1,186 @@
+// Package apierror writes API errors in a single envelope:
+//
+//	{"error": {"code": "game_not_found", "message": "Game not found",
+//	           "details": ..., "request_id": "..."}}
+//
+// Codes are stable and meant for programs; messages are for people and may
+// change. Requests on the deprecated, unversioned routes get the legacy
+// {"error": message, ...} shape instead.
+package apierror
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys set by middleware.
+const (
+	// RequestIDKey holds the ID of the request, echoed in X-Request-ID.
+	RequestIDKey = "requestID"
+	// LegacyKey is set on requests to deprecated routes.
+	LegacyKey = "legacyAPI"
+)
+
+// Error codes. Each failure a client may want to handle has its own code.
+const (
+	// Generic
+	CodeInvalidRequest = "invalid_request"
+	CodeInvalidID      = "invalid_id"
+	CodeInternal       = "internal_error"
+	CodeRouteNotFound  = "route_not_found"
+	CodeRateLimited    = "rate_limited"
+	CodeBodyTooLarge   = "body_too_large"
+
+	// Authentication
+	CodeUnauthorized          = "unauthorized"
+	CodeInvalidToken          = "invalid_token"
+	CodeSessionRevoked        = "session_revoked"
+	CodeInvalidCredentials    = "invalid_credentials"
+	CodeLoginThrottled        = "login_throttled"
+	CodeInvalidRefreshToken   = "invalid_refresh_token"
+	CodeRefreshTokenReused    = "refresh_token_reused"
+	CodeInvalidResetToken     = "invalid_reset_token"
+	CodeInvalidVerification   = "invalid_verification_token"
+	CodeInvalidChallenge      = "invalid_challenge"
+	CodeInvalidTwoFactorCode  = "invalid_two_factor_code"
+	CodeTwoFactorEnabled      = "two_factor_already_enabled"
+	CodeTwoFactorNotEnabled   = "two_factor_not_enabled"
+	CodeEnrollmentNotStarted  = "enrollment_not_started"
+	CodeUnknownProvider       = "unknown_provider"
+	CodeProviderUnavailable   = "provider_unavailable"
+	CodeProviderLoginFailed   = "provider_login_failed"
+	CodeInvalidLoginState     = "invalid_login_state"
+	CodeProviderEmailMissing  = "provider_email_missing"
+	CodeAccountExists         = "account_exists"
+	CodeIncorrectPassword     = "incorrect_password"
+	CodeVerificationThrottled = "verification_throttled"
+
+	// Authorization
+	CodeForbidden          = "forbidden"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeInsufficientRole   = "insufficient_role"
+	CodeSessionRequired    = "session_required"
+	CodeNotOwner           = "not_owner"
+	CodeEntitlementMissing = "entitlement_required"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeQuotaExceeded      = "quota_exceeded"
+
+	// Accounts
+	CodeUserNotFound         = "user_not_found"
+	CodeUsernameTaken        = "username_taken"
+	CodeEmailTaken           = "email_taken"
+	CodeInvalidUsername      = "invalid_username"
+	CodeInvalidEmail         = "invalid_email"
+	CodeWeakPassword         = "weak_password"
+	CodeEmailAlreadyVerified = "email_already_verified"
+	CodeSameEmail            = "same_email"
+	CodeNotGuest             = "not_guest"
+	CodeGuestNotAllowed      = "guest_not_allowed"
+	CodeUnknownRole          = "unknown_role"
+	CodeUnknownScope         = "unknown_scope"
+	CodeSessionNotFound      = "session_not_found"
+	CodeAccessTokenNotFound  = "access_token_not_found"
+	CodeDeletionPending      = "deletion_already_requested"
+	CodeNoDeletionPending    = "no_deletion_pending"
+
+	// Games
+	CodeGameNotFound     = "game_not_found"
+	CodePlayerNotFound   = "player_not_found"
+	CodeFloorNotFound    = "floor_not_found"
+	CodeRoomNotFound     = "room_not_found"
+	CodeEnemyNotFound    = "enemy_not_found"
+	CodeChestNotFound    = "chest_not_found"
+	CodeWeaponNotFound   = "weapon_not_found"
+	CodeSnapshotNotFound = "snapshot_not_found"
+	CodeSnapshotCorrupt  = "snapshot_corrupt"
+	CodeVersionConflict  = "version_conflict"
+	CodeVersionRequired  = "version_required"
+	CodeInvalidOperation = "invalid_operation"
+	CodeTargetNotFound   = "target_not_found"
+	CodeInvalidSaveFile  = "invalid_save_file"
+	CodeGenerationFailed = "generation_failed"
+
+	// Generation jobs
+	CodeGenerationJobNotFound = "generation_job_not_found"
+
+	// Billing
+	CodeBillingUnavailable = "billing_unavailable"
+	CodePlanOwned          = "plan_already_owned"
+	CodeCheckoutFailed     = "checkout_failed"
+	CodeInvalidSignature   = "invalid_signature"
+
+	// Idempotency
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeIdempotencyInFlight  = "idempotency_key_in_use"
+)
+
+// Error is the body of an error response.
+type Error struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Response is the envelope around an Error.
+type Response struct {
+	Error Error `json:"error"`
+}
+
+// Abort ends the request with an error.
+func Abort(c *gin.Context, status int, code, message string) {
+	AbortWithDetails(c, status, code, message, nil)
+}
+
+// AbortWithDetails ends the request with an error carrying details, such as
+// the fields that failed validation or the current state of a resource.
+func AbortWithDetails(c *gin.Context, status int, code, message string, details interface{}) {
+	if c.GetBool(LegacyKey) {
+		c.AbortWithStatusJSON(status, legacyBody(message, details))
+		return
+	}
+	c.AbortWithStatusJSON(status, Response{Error{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(RequestIDKey),
+	}})
+}
+
+// legacyBody builds the error body of the unversioned API, which put map
+// details next to the message and anything else under "details".
+func legacyBody(message string, details interface{}) gin.H {
+	body := gin.H{}
+	switch d := details.(type) {
+	case nil:
+	case gin.H:
+		for k, v := range d {
+			body[k] = v
+		}
+	default:
+		body["details"] = d
+	}
+	body["error"] = message
+	return body
+}
+
+// Legacy reports whether the request came through a deprecated route, whose
+// responses keep their old field names.
+func Legacy(c *gin.Context) bool {
+	return c.GetBool(LegacyKey)
+}
+
+// FieldError describes why one field of a request is invalid. Field is the
+// path of the field, such as "game.Floor.Rooms[0].X", or the name of a path
+// or query parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// AbortWithFields rejects a request with 400, listing the invalid fields in
+// the details.
+func AbortWithFields(c *gin.Context, fields []FieldError) {
+	AbortWithDetails(c, http.StatusBadRequest, CodeInvalidRequest, "Request validation failed", gin.H{"fields": fields})
+}
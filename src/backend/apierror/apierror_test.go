@@ -0,0 +1,48 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLegacyBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		details interface{}
+		want    string
+	}{
+		{nil, `{"error":"Game not found"}`},
+		{"boom", `{"details":"boom","error":"Game not found"}`},
+		{gin.H{"game": 1}, `{"error":"Game not found","game":1}`},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(LegacyKey, true)
+		AbortWithDetails(c, http.StatusNotFound, CodeGameNotFound, "Game not found", tc.details)
+		if w.Body.String() != tc.want {
+			t.Errorf("details %v: got %s, want %s", tc.details, w.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(RequestIDKey, "req-1")
+	Abort(c, http.StatusNotFound, CodeGameNotFound, "Game not found")
+
+	var body Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := Error{Code: CodeGameNotFound, Message: "Game not found", RequestID: "req-1"}
+	if body.Error != want || !c.IsAborted() {
+		t.Errorf("got %+v", body.Error)
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PATPrefix starts every personal access token, so they are easy to tell
+// apart from JWTs and to spot in leaked logs or code.
+const PATPrefix = "tlg_pat_"
+
+// Scopes a personal access token can grant. game:write implies game:read.
+const (
+	ScopeGameRead  = "game:read"
+	ScopeGameWrite = "game:write"
+)
+
+const (
+	defaultPATLifetime = 30 * 24 * time.Hour
+	maxPATLifetime     = 365 * 24 * time.Hour
+
+	// patTouchInterval limits how often LastUsedAt is written.
+	patTouchInterval = time.Minute
+)
+
+var knownScopes = map[string]bool{ScopeGameRead: true, ScopeGameWrite: true}
+
+var errInvalidPAT = errors.New("invalid personal access token")
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=64"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+type AccessTokenDTO struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newAccessTokenDTO(t model.PersonalAccessToken) AccessTokenDTO {
+	return AccessTokenDTO{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     strings.Fields(t.Scopes),
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+// HasScope reports whether the granted scopes include scope.
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope || (s == ScopeGameWrite && scope == ScopeGameRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthenticatePAT returns the user and scopes of a valid personal access
+// token.
+func AuthenticatePAT(db *gorm.DB, token string) (uint, []string, error) {
+	var pat model.PersonalAccessToken
+	err := db.Where("token_hash = ?", hashToken(token)).First(&pat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil, errInvalidPAT
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	now := time.Now()
+	if now.After(pat.ExpiresAt) {
+		return 0, nil, errInvalidPAT
+	}
+
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > patTouchInterval {
+		db.Model(&pat).Update("last_used_at", now)
+	}
+	return pat.UserID, strings.Fields(pat.Scopes), nil
+}
+
+// RevokeUserAccessTokens deletes every personal access token of a user, so
+// that a leaked token stops working once the password is changed.
+func RevokeUserAccessTokens(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&model.PersonalAccessToken{}).Error
+}
+
+// ListAccessTokens returns the current user's personal access tokens.
+func ListAccessTokens(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var tokens []model.PersonalAccessToken
+	if err := model.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to fetch access tokens")
+		return
+	}
+
+	dtos := make([]AccessTokenDTO, 0, len(tokens))
+	for _, t := range tokens {
+		dtos = append(dtos, newAccessTokenDTO(t))
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": dtos})
+}
+
+// CreateAccessToken creates a personal access token. The token itself is only
+// ever returned here.
+func CreateAccessToken(c *gin.Context) {
+	var req CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !knownScopes[scope] {
+			apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeUnknownScope, "Unknown scope: "+scope, gin.H{"scope": scope})
+			return
+		}
+	}
+	lifetime := defaultPATLifetime
+	if req.ExpiresInDays != 0 {
+		lifetime = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+	if lifetime <= 0 || lifetime > maxPATLifetime {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "expires_in_days must be between 1 and 365")
+		return
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create access token")
+		return
+	}
+	token := PATPrefix + secret
+
+	pat := model.PersonalAccessToken{
+		UserID:    c.MustGet("userID").(uint),
+		Name:      strings.TrimSpace(req.Name),
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(req.Scopes, " "),
+		ExpiresAt: time.Now().Add(lifetime),
+	}
+	if err := model.DB.Create(&pat).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create access token")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Access token created. Copy it now, it will not be shown again.",
+		"token":        token,
+		"access_token": newAccessTokenDTO(pat),
+	})
+}
+
+// RevokeAccessToken deletes one of the current user's personal access tokens.
+func RevokeAccessToken(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	result := model.DB.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), userID).
+		Delete(&model.PersonalAccessToken{})
+	if result.Error != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to revoke access token")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeAccessTokenNotFound, "Access token not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Access token revoked"})
+}
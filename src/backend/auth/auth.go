@@ -1,13 +1,14 @@
 package auth
 
 import (
+	"backend/apierror"
 	"backend/model"
 	"errors"
 	"fmt"
 
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -42,14 +43,17 @@ func hashString(str string) (string, error) {
 }
 
 
-// GenerateTokens creates an access token (short-lived) and a refresh token (long-lived)
-func GenerateTokens(userID uint) (*TokenPair, error) {
-	tokenPair := &TokenPair{}
-	accessSecret := os.Getenv("ACCESS_TOKEN_SECRET")
-	refreshSecret := os.Getenv("REFRESH_TOKEN_SECRET")
-
-	if accessSecret == "" || refreshSecret == "" {
-		return nil, errors.New("access or refresh secret is missing in environment variables")
+// newAccessToken signs a short-lived access token for the user's session.
+// The user's current role is read on every call, so a role change takes
+// effect at the next refresh.
+func newAccessToken(userID uint, sessionID string) (string, error) {
+	var user model.User
+	if err := model.DB.Select("id", "role").First(&user, userID).Error; err != nil {
+		return "", err
+	}
+	role := user.Role
+	if !model.ValidRole(role) {
+		role = model.RolePlayer
 	}
 
 	// Stringify user ID for sub
@@ -57,36 +61,40 @@ func GenerateTokens(userID uint) (*TokenPair, error) {
 	now := time.Now()
 
 	// 🔹 Access Token (valid for 15 minutes)
-	accessClaims := jwt.MapClaims{
+	return Keys.Sign(jwt.MapClaims{
 		"sub": sub,
+		"sid": sessionID,
+		"role": role,
+		"aud": Audience(),
 		"iat": now.Unix(),
 		"nbf": now.Unix(),
 		"exp": now.Add(15 * time.Minute).Unix(),
-	}
+	})
+}
 
-	accessTokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessToken, err := accessTokenObj.SignedString([]byte(accessSecret))
+// GenerateTokens starts a new session for the user and creates its access
+// token (short-lived) and first refresh token (long-lived, single use).
+func GenerateTokens(userID uint, client ClientInfo) (*TokenPair, error) {
+	familyID, err := startSession(model.DB, userID, client)
 	if err != nil {
 		return nil, err
 	}
-	tokenPair.AccessToken = accessToken
+	if err := markSeen(model.DB, userID); err != nil {
+		log.Println("Failed to update last seen time:", err)
+	}
 
-	// 🔹 Refresh Token (valid for 7 days)
-	refreshClaims := jwt.MapClaims{
-		"sub": sub,
-		"iat": now.Unix(),
-		"nbf": now.Unix(),
-		"exp": now.Add(7 * 24 * time.Hour).Unix(),
+	accessToken, err := newAccessToken(userID, familyID)
+	if err != nil {
+		return nil, err
 	}
 
-	refreshTokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshToken, err := refreshTokenObj.SignedString([]byte(refreshSecret))
+	// 🔹 Refresh Token (valid for 7 days, stored hashed)
+	refreshToken, err := issueRefreshToken(model.DB, userID, familyID)
 	if err != nil {
 		return nil, err
 	}
-	tokenPair.RefreshToken = refreshToken
 
-	return tokenPair, nil
+	return &TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
 
 
@@ -95,32 +103,43 @@ func Register(c *gin.Context) {
 	var req RegisterAccountRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidEmail, "Invalid email address")
 		return
 	}
+	req.Email = email
 
+	if err := validateUsername(req.Username); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidUsername, err.Error())
+		return
+	}
 
 	hashedPassword, err := hashString(req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error hashing password")
 		return
 	}
 
 	var existingUser model.User
 
-	usernameExists := model.DB.Where("username = ?", req.Username).First(&existingUser).Error == nil
+	usernameExists := model.DB.Where("LOWER(username) = LOWER(?)", req.Username).First(&existingUser).Error == nil
 	emailExists := model.DB.Where("email = ?", req.Email).First(&existingUser).Error == nil
 
 
 
 	// Return specific errors if username already exists
 	if usernameExists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create user. Username is already in use."})
+		apierror.Abort(c, http.StatusConflict, apierror.CodeUsernameTaken, "Failed to create user. Username is already in use.")
 		return
 	}
 
 	if emailExists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Failed to create user. Email is already in use."})
+		apierror.Abort(c, http.StatusConflict, apierror.CodeEmailTaken, "Failed to create user. Email is already in use.")
 		return
 	}
 
@@ -133,14 +152,19 @@ func Register(c *gin.Context) {
 	// Save user to database
 	if err := model.DB.Create(&user).Error; err != nil {
 		log.Println("Database error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create unique user."})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create unique user.")
 		return
 	}
 
-	token, err := GenerateTokens(user.ID)
+	if err := sendVerificationEmail(model.DB, user, user.Email); err != nil {
+		log.Println("Failed to send verification email:", err)
+	}
+
+	token, err := GenerateTokens(user.ID, Client(c))
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
+		return
 	}
 
 	// Send response with JWT token
@@ -149,6 +173,7 @@ func Register(c *gin.Context) {
 		"access_token":  token.AccessToken,
 		"refresh_token": token.RefreshToken,
 		"user_id": user.ID,
+		"email_verified": false,
 	})
 }
 
@@ -157,26 +182,67 @@ func Login(c *gin.Context) {
 
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
 		return
 	}
 
-	var user model.User
+	// 🔹 Refuse attempts while the account or client is backing off
+	accountKey, ipKey := accountThrottleKey(req.Username), ipThrottleKey(c.ClientIP())
+	now := time.Now()
+	wait, err := loginWait(model.DB, now, accountKey, ipKey)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to log in")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeLoginThrottled, "Too many failed login attempts, try again later")
+		return
+	}
 
+	var user model.User
+	var valid bool
 	if err := model.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username"})
+		compareDummyPassword(req.Password)
+	} else {
+		valid = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) == nil
+	}
+
+	if !valid {
+		lockedUntil, err := recordLoginFailure(model.DB, now, accountKey, ipKey)
+		if err != nil {
+			log.Println("Failed to record login failure:", err)
+		}
+		if lockedUntil != nil && user.ID != 0 {
+			OnAccountLocked(user, *lockedUntil)
+		}
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid username or password")
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+	// 🔹 With 2FA on, the password only earns a challenge for LoginTwoFactor
+	if user.TOTPEnabledAt != nil {
+		challenge, err := newTwoFactorChallenge(user.ID)
+		if err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
 		return
 	}
 
+	if err := clearLoginFailures(model.DB, accountKey); err != nil {
+		log.Println("Failed to clear login failures:", err)
+	}
+
 
-	token, err := GenerateTokens(user.ID)
+	token, err := GenerateTokens(user.ID, Client(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
 		return
 	}
 
@@ -184,51 +250,59 @@ func Login(c *gin.Context) {
 		"message":       "Login successful",
 		"access_token":  token.AccessToken,
 		"refresh_token": token.RefreshToken,
-		"user_ID": user.ID,
+		userIDField(c): user.ID,
 
 	})
 }
 
-// RefreshToken handles the renewal of access tokens using a valid refresh token.
+// userIDField names the user ID in login responses, which the unversioned
+// API spelled "user_ID".
+func userIDField(c *gin.Context) string {
+	if apierror.Legacy(c) {
+		return "user_ID"
+	}
+	return "user_id"
+}
+
+// RefreshToken handles the renewal of access tokens using a valid refresh
+// token. The refresh token is single use: a new one is returned every time.
 func RefreshToken(c *gin.Context) {
 	var req RefreshInfo
 
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request")
 		return
 	}
 
-	// 🔹 Parse the refresh token
-	token, err := jwt.Parse(req.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, http.ErrAbortHandler
-		   }
-		   return []byte(os.Getenv("REFRESH_TOKEN_SECRET")), nil
-		  })
-
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
-			return
-		   }
+	// 🔹 Consume the refresh token and get the next one in its family
+	userID, sessionID, refreshToken, err := rotateRefreshToken(model.DB, req.RefreshToken)
+	if errors.Is(err, errRefreshTokenReused) {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeRefreshTokenReused, "Refresh token was already used; session revoked")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidRefreshToken, "Invalid refresh token")
+		return
+	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID := claims["sub"].(float64)
+	if err := touchSession(model.DB, sessionID, Client(c)); err != nil {
+		log.Println("Failed to update session:", err)
+	}
+	if err := markSeen(model.DB, userID); err != nil {
+		log.Println("Failed to update last seen time:", err)
+	}
 
-		newTokens, err := GenerateTokens(uint(userID))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate new tokens"})
-			return
+	accessToken, err := newAccessToken(userID, sessionID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate new tokens")
+		return
 	}
 
 	// Send new tokens to frontend
 	c.JSON(http.StatusOK, gin.H{
-		"access_token": newTokens.AccessToken,
-		"refresh_token": req.RefreshToken,
-		"user_ID": userID,
+		"access_token": accessToken,
+		"refresh_token": refreshToken,
+		userIDField(c): userID,
 	})
-	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
-	}
 }
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultGuestInactivity = 7 * 24 * time.Hour
+
+	// guestEmailDomain is a reserved domain (RFC 2606), so placeholder
+	// addresses can never receive mail.
+	guestEmailDomain = "guest.invalid"
+)
+
+type UpgradeGuestRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+var (
+	errNotGuest      = errors.New("account is not a guest")
+	errUsernameTaken = errors.New("username is already in use")
+	errEmailTaken    = errors.New("email is already in use")
+)
+
+// GuestInactivityTTL is how long a guest account survives without being
+// used, from GUEST_INACTIVITY_TTL (a Go duration, default 168h).
+func GuestInactivityTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("GUEST_INACTIVITY_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultGuestInactivity
+}
+
+// markSeen records that the user was just active.
+func markSeen(db *gorm.DB, userID uint) error {
+	return db.Model(&model.User{}).Where("id = ?", userID).Update("last_seen_at", time.Now()).Error
+}
+
+// CreateGuest creates an anonymous account and signs it in, so new players
+// can try the game before registering.
+func CreateGuest(c *gin.Context) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create guest")
+		return
+	}
+	name := "guest-" + hex.EncodeToString(buf)
+	now := time.Now()
+
+	user := model.User{
+		Username:   name,
+		Email:      name + "@" + guestEmailDomain,
+		IsGuest:    true,
+		LastSeenAt: &now,
+	}
+	if err := model.DB.Create(&user).Error; err != nil {
+		log.Println("Database error:", err)
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create guest")
+		return
+	}
+
+	token, err := GenerateTokens(user.ID, Client(c))
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Guest session created",
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		"user_id":       user.ID,
+		"guest":         true,
+		"expires_after": GuestInactivityTTL().String(),
+	})
+}
+
+// UpgradeGuest turns the current guest account into a full account with a
+// username, email and password. The account keeps its ID and all its games.
+func UpgradeGuest(c *gin.Context) {
+	var req UpgradeGuestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidEmail, "Invalid email address")
+		return
+	}
+	if err := validateUsername(req.Username); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidUsername, err.Error())
+		return
+	}
+	hashedPassword, err := hashString(req.Password)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error hashing password")
+		return
+	}
+
+	var user model.User
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+			return err
+		}
+		if !user.IsGuest {
+			return errNotGuest
+		}
+
+		taken, err := usernameTaken(tx, req.Username, user.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errUsernameTaken
+		}
+		var count int64
+		if err := tx.Model(&model.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errEmailTaken
+		}
+
+		user.Username = req.Username
+		user.Email = email
+		user.Password = hashedPassword
+		user.IsGuest = false
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"username": user.Username,
+			"email":    user.Email,
+			"password": user.Password,
+			"is_guest": false,
+		}).Error
+	})
+	switch {
+	case errors.Is(err, errNotGuest):
+		apierror.Abort(c, http.StatusConflict, apierror.CodeNotGuest, "Only guest accounts can be upgraded")
+		return
+	case errors.Is(err, errUsernameTaken):
+		apierror.Abort(c, http.StatusConflict, apierror.CodeUsernameTaken, "Failed to upgrade account. Username is already in use.")
+		return
+	case errors.Is(err, errEmailTaken):
+		apierror.Abort(c, http.StatusConflict, apierror.CodeEmailTaken, "Failed to upgrade account. Email is already in use.")
+		return
+	case err != nil:
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to upgrade account")
+		return
+	}
+
+	if err := sendVerificationEmail(model.DB, user, user.Email); err != nil {
+		log.Println("Failed to send verification email:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Account upgraded successfully",
+		"user_id":        user.ID,
+		"email_verified": false,
+	})
+}
+
+// UpgradeGuestOIDC starts an OpenID Connect login that attaches the
+// provider identity to the current guest account instead of signing in.
+func UpgradeGuestOIDC(c *gin.Context) {
+	var user model.User
+	if err := model.DB.Select("id", "is_guest").First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+	if !user.IsGuest {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeNotGuest, "Only guest accounts can be upgraded")
+		return
+	}
+	startOIDCLogin(c, user.ID)
+}
+
+// upgradeGuestWithIdentity attaches a provider identity to a guest account,
+// taking the provider's email address.
+func upgradeGuestWithIdentity(db *gorm.DB, guestID uint, provider string, identity *OIDCIdentity) (model.User, error) {
+	var user model.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, guestID).Error; err != nil {
+			return err
+		}
+		if !user.IsGuest {
+			return errNotGuest
+		}
+
+		var count int64
+		if err := tx.Model(&model.LinkedIdentity{}).
+			Where("provider = ? AND subject = ?", provider, identity.Subject).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errOIDCAccountExists
+		}
+
+		email, err := normalizeEmail(identity.Email)
+		if err != nil {
+			return errOIDCEmailRequired
+		}
+		if err := tx.Model(&model.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errOIDCAccountExists
+		}
+
+		username, err := availableUsername(tx, identity.PreferredUsername, email)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{
+			"username": username,
+			"email":    email,
+			"is_guest": false,
+		}
+		if identity.EmailVerified {
+			updates["email_verified_at"] = time.Now()
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&user, guestID).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&model.LinkedIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    email,
+		}).Error
+	})
+	return user, err
+}
+
+// StartGuestReaper periodically deletes guest accounts that have been
+// inactive for longer than GuestInactivityTTL, with all their games.
+func StartGuestReaper(db *gorm.DB) {
+	go func() {
+		tick := time.Tick(time.Hour)
+		for {
+			deleted, err := reapGuests(db, time.Now().Add(-GuestInactivityTTL()))
+			if err != nil {
+				log.Println("Guest cleanup failed:", err)
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d inactive guest account(s)", deleted)
+			}
+			<-tick
+		}
+	}()
+}
+
+// reapGuests deletes guests last seen before cutoff, skipping those who
+// came back in the meantime.
+func reapGuests(db *gorm.DB, cutoff time.Time) (int, error) {
+	return model.ReapUsers(db, model.DeleteUser, "is_guest AND COALESCE(last_seen_at, created_at) < ?", cutoff)
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/dbtest"
+	"backend/model"
+)
+
+// guestUser is a row of the users table of a fake database.
+type guestUser struct {
+	username   string
+	email      string
+	guest      bool
+	lastSeenAt time.Time
+	games      []int64
+}
+
+// guestStore is a fake database holding users, their games and the
+// identities linked to them.
+type guestStore struct {
+	mu         sync.Mutex
+	users      map[int64]*guestUser
+	identities map[string]int64 // user IDs by provider and subject
+	listed     []int64          // what listing the guests to reap returns
+}
+
+// useGuestDB points model.DB at a fake database where user 7 is a guest with
+// games 70 and 71, and user 8, alice, has a full account.
+func useGuestDB(t *testing.T) (*dbtest.DB, *guestStore) {
+	s := &guestStore{
+		users: map[int64]*guestUser{
+			7: {username: "guest-0123456789abcdef", email: "guest-0123456789abcdef@guest.invalid", guest: true, lastSeenAt: time.Now(), games: []int64{70, 71}},
+			8: {username: "alice", email: "alice@example.com", lastSeenAt: time.Now()},
+		},
+		identities: map[string]int64{"github|taken": 8},
+	}
+	userColumns := []string{"id", "username", "email", "is_guest"}
+	row := func(id int64, u *guestUser) []driver.Value {
+		return []driver.Value{id, u.username, u.email, u.guest}
+	}
+	count := func(match func(id int64, u *guestUser) bool) ([]string, [][]driver.Value) {
+		var n int64
+		for id, u := range s.users {
+			if match(id, u) {
+				n++
+			}
+		}
+		return []string{"count"}, [][]driver.Value{{n}}
+	}
+
+	fake := useFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, `SELECT "id" FROM "users"`):
+			var rows [][]driver.Value
+			for _, id := range s.listed {
+				rows = append(rows, []driver.Value{id})
+			}
+			return []string{"id"}, rows
+		case strings.HasPrefix(query, `SELECT * FROM "users"`) && strings.Contains(query, "COALESCE(last_seen_at, created_at) < $1"):
+			cutoff := args[0].(time.Time)
+			if u, ok := s.users[args[1].(int64)]; ok && u.guest && u.lastSeenAt.Before(cutoff) {
+				return userColumns, [][]driver.Value{row(args[1].(int64), u)}
+			}
+		case (strings.HasPrefix(query, `SELECT * FROM "users"`) || strings.HasPrefix(query, `SELECT "id","username" FROM "users"`)) &&
+			strings.Contains(query, `"users"."id" = $1`):
+			if u, ok := s.users[args[0].(int64)]; ok {
+				return userColumns, [][]driver.Value{row(args[0].(int64), u)}
+			}
+		case strings.HasPrefix(query, `SELECT count(*) FROM "users"`) && strings.Contains(query, "LOWER(username) = LOWER($1) AND id <> $2"):
+			return count(func(id int64, u *guestUser) bool {
+				return strings.EqualFold(u.username, args[0].(string)) && id != args[1]
+			})
+		case strings.HasPrefix(query, `SELECT count(*) FROM "users"`) && strings.Contains(query, "email = $1 AND id <> $2"):
+			return count(func(id int64, u *guestUser) bool { return u.email == args[0] && id != args[1] })
+		case strings.HasPrefix(query, `SELECT count(*) FROM "users"`) && strings.Contains(query, "username = $1"):
+			return count(func(id int64, u *guestUser) bool { return u.username == args[0] })
+		case strings.HasPrefix(query, `SELECT count(*) FROM "linked_identities"`):
+			var n int64
+			if _, ok := s.identities[args[0].(string)+"|"+args[1].(string)]; ok {
+				n = 1
+			}
+			return []string{"count"}, [][]driver.Value{{n}}
+		case strings.HasPrefix(query, `INSERT INTO "linked_identities"`):
+			identity := dbtest.Inserted(query, args)
+			s.identities[identity["provider"].(string)+"|"+identity["subject"].(string)] = identity["user_id"].(int64)
+			return []string{"id"}, [][]driver.Value{{int64(len(s.identities))}}
+		case strings.HasPrefix(query, `SELECT "id" FROM "games"`):
+			var rows [][]driver.Value
+			if u, ok := s.users[args[0].(int64)]; ok {
+				for _, id := range u.games {
+					rows = append(rows, []driver.Value{id})
+				}
+			}
+			return []string{"id"}, rows
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, `UPDATE "users"`) && strings.Contains(query, `"id" = $`):
+			u, ok := s.users[args[len(args)-1].(int64)]
+			if !ok {
+				return 0
+			}
+			for column, value := range dbtest.Assigned(query, args) {
+				switch column {
+				case "username":
+					u.username = value.(string)
+				case "email":
+					u.email = value.(string)
+				case "is_guest":
+					u.guest = value.(bool)
+				}
+			}
+		case strings.HasPrefix(query, `DELETE FROM "users"`):
+			delete(s.users, args[0].(int64))
+		}
+		return 1
+	})
+	return fake, s
+}
+
+func (s *guestStore) user(id int64) *guestUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[id]
+}
+
+func TestUpgradeGuestKeepsAccount(t *testing.T) {
+	fake, store := useGuestDB(t)
+	useMemoryMailer(t)
+
+	w := serveAs(7, UpgradeGuest, `{"username": "bob", "email": "Bob@Example.com", "password": "secret password"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		UserID uint `json:"user_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.UserID != 7 {
+		t.Errorf("user_id = %d; want the guest's ID 7", body.UserID)
+	}
+
+	user := store.user(7)
+	if user == nil || user.guest || user.username != "bob" || user.email != "Bob@example.com" {
+		t.Fatalf("user 7 = %+v; want bob, no longer a guest", user)
+	}
+	if len(user.games) != 2 {
+		t.Errorf("games = %v; want the guest's games", user.games)
+	}
+	if touched := append(fake.Executed(`"games"`), fake.Executed(`DELETE FROM "users"`)...); len(touched) > 0 {
+		t.Errorf("upgrade touched games or deleted users: %v", touched)
+	}
+}
+
+func TestUpgradeGuestRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID uint
+		body   string
+		code   string
+	}{
+		{"full account", 8, `{"username": "bob", "email": "bob@example.com", "password": "secret"}`, apierror.CodeNotGuest},
+		{"taken username", 7, `{"username": "ALICE", "email": "bob@example.com", "password": "secret"}`, apierror.CodeUsernameTaken},
+		{"taken email", 7, `{"username": "bob", "email": "alice@EXAMPLE.com", "password": "secret"}`, apierror.CodeEmailTaken},
+	}
+	for _, tt := range tests {
+		fake, store := useGuestDB(t)
+		w := serveAs(tt.userID, UpgradeGuest, tt.body)
+		var body apierror.Response
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusConflict || body.Error.Code != tt.code {
+			t.Errorf("%s: status = %d, code = %s; want 409 %s", tt.name, w.Code, body.Error.Code, tt.code)
+		}
+		if updates := fake.Executed(`UPDATE "users"`); len(updates) != 0 {
+			t.Errorf("%s: users updated: %v", tt.name, updates)
+		}
+		if !store.user(7).guest {
+			t.Errorf("%s: the guest was upgraded", tt.name)
+		}
+	}
+}
+
+func TestUpgradeGuestWithIdentity(t *testing.T) {
+	_, store := useGuestDB(t)
+
+	user, err := upgradeGuestWithIdentity(model.DB, 7, "github", &OIDCIdentity{
+		Subject:           "1234",
+		Email:             "bob@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "bob",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 7 {
+		t.Errorf("user ID = %d; want the guest's ID 7", user.ID)
+	}
+	if u := store.user(7); u.guest || u.username != "bob" || len(u.games) != 2 {
+		t.Errorf("user 7 = %+v; want bob with the guest's games", u)
+	}
+	if store.identities["github|1234"] != 7 {
+		t.Errorf("identities = %v; want github|1234 linked to user 7", store.identities)
+	}
+
+	tests := []struct {
+		name     string
+		guestID  uint
+		identity OIDCIdentity
+		err      error
+	}{
+		{"full account", 8, OIDCIdentity{Subject: "5678", Email: "carol@example.com"}, errNotGuest},
+		{"linked identity", 9, OIDCIdentity{Subject: "taken", Email: "carol@example.com"}, errOIDCAccountExists},
+		{"taken email", 9, OIDCIdentity{Subject: "5678", Email: "alice@example.com"}, errOIDCAccountExists},
+		{"no email", 9, OIDCIdentity{Subject: "5678"}, errOIDCEmailRequired},
+	}
+	for _, tt := range tests {
+		store.mu.Lock()
+		store.users[9] = &guestUser{username: "guest-9", email: "guest-9@guest.invalid", guest: true}
+		store.mu.Unlock()
+
+		identity := tt.identity
+		if _, err := upgradeGuestWithIdentity(model.DB, tt.guestID, "github", &identity); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v; want %v", tt.name, err, tt.err)
+		}
+		if !store.user(9).guest {
+			t.Errorf("%s: the guest was upgraded", tt.name)
+		}
+	}
+}
+
+func TestReapGuestsSkipsReturningGuests(t *testing.T) {
+	_, store := useGuestDB(t)
+	cutoff := time.Now().Add(-GuestInactivityTTL())
+
+	store.mu.Lock()
+	store.users[7].lastSeenAt = cutoff.Add(-time.Hour)
+	store.users[8].lastSeenAt = cutoff.Add(-time.Hour)
+	// Both guests were inactive when listed, but guest 9 came back since
+	store.users[9] = &guestUser{username: "guest-9", guest: true, lastSeenAt: time.Now()}
+	store.listed = []int64{7, 9}
+	store.mu.Unlock()
+
+	deleted, err := reapGuests(model.DB, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted = %d; want 1", deleted)
+	}
+	if store.user(7) != nil {
+		t.Error("the inactive guest was kept")
+	}
+	if store.user(9) == nil {
+		t.Error("the guest who came back was deleted")
+	}
+	if store.user(8) == nil {
+		t.Error("an inactive full account was deleted")
+	}
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultKeyRotation = 30 * 24 * time.Hour
+	defaultIssuer      = "the-last-game"
+	defaultAudience    = "the-last-game-api"
+
+	// keyRotationLock is the Postgres advisory lock taken while rotating, so
+	// that several API servers sharing a database create only one new key.
+	keyRotationLock = 0x4c617374
+)
+
+// signingKey is a loaded model.SigningKey.
+type signingKey struct {
+	kid       string
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// KeySet holds the keys that verify access tokens. The newest key is also the
+// one new tokens are signed with.
+type KeySet struct {
+	mu      sync.RWMutex
+	keys    map[string]signingKey
+	current signingKey
+}
+
+// Keys is the key set used by the API server. It is loaded by InitKeys.
+var Keys = &KeySet{}
+
+// KeyRotationInterval is how long a key signs new tokens before a new one
+// replaces it, from JWT_KEY_ROTATION (a Go duration, default 720h). A retired
+// key keeps verifying tokens for one more interval.
+func KeyRotationInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("JWT_KEY_ROTATION")); err == nil && d > 0 {
+		return d
+	}
+	return defaultKeyRotation
+}
+
+// Issuer is the "iss" claim of every token this server signs.
+func Issuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return defaultIssuer
+}
+
+// Audience is the "aud" claim of access tokens.
+func Audience() string {
+	if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+		return aud
+	}
+	return defaultAudience
+}
+
+// InitKeys loads the signing keys from the database, creating the first one
+// if needed.
+func InitKeys(db *gorm.DB) error {
+	return Keys.Rotate(db, KeyRotationInterval())
+}
+
+// StartKeyRotation checks the keys periodically, replacing the signing key
+// once it is older than the rotation interval and picking up keys created by
+// other servers.
+func StartKeyRotation(db *gorm.DB) {
+	interval := KeyRotationInterval()
+	check := interval / 24
+	if check > time.Hour {
+		check = time.Hour
+	}
+	if check < time.Minute {
+		check = time.Minute
+	}
+
+	go func() {
+		for range time.Tick(check) {
+			if err := Keys.Rotate(db, interval); err != nil {
+				log.Println("Signing key rotation failed:", err)
+			}
+		}
+	}()
+}
+
+// Rotate creates a new signing key when the newest one is older than
+// interval, drops expired keys and reloads the set.
+func (ks *KeySet) Rotate(db *gorm.DB, interval time.Duration) error {
+	var stored []model.SigningKey
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", keyRotationLock).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Unscoped().Where("expires_at < ?", now).Delete(&model.SigningKey{}).Error; err != nil {
+			return err
+		}
+
+		var newest model.SigningKey
+		err := tx.Order("created_at DESC").First(&newest).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) || now.Sub(newest.CreatedAt) >= interval {
+			key, err := newSigningKey(now.Add(2 * interval))
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&key).Error; err != nil {
+				return err
+			}
+			log.Printf("Created signing key %s", key.KID)
+		}
+
+		return tx.Order("created_at DESC").Find(&stored).Error
+	})
+	if err != nil {
+		return err
+	}
+	return ks.load(stored)
+}
+
+func newSigningKey(expiresAt time.Time) (model.SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return model.SigningKey{}, err
+	}
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return model.SigningKey{}, err
+	}
+	return model.SigningKey{
+		KID:        hex.EncodeToString(kid),
+		PrivateKey: private.Seed(),
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// load replaces the set with the given keys, newest first.
+func (ks *KeySet) load(stored []model.SigningKey) error {
+	if len(stored) == 0 {
+		return errors.New("no signing keys available")
+	}
+
+	keys := make(map[string]signingKey, len(stored))
+	for _, k := range stored {
+		if len(k.PrivateKey) != ed25519.SeedSize {
+			return fmt.Errorf("signing key %s is malformed", k.KID)
+		}
+		private := ed25519.NewKeyFromSeed(k.PrivateKey)
+		keys[k.KID] = signingKey{
+			kid:       k.KID,
+			private:   private,
+			public:    private.Public().(ed25519.PublicKey),
+			createdAt: k.CreatedAt,
+			expiresAt: k.ExpiresAt,
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.current = keys[stored[0].KID]
+	return nil
+}
+
+// Sign signs the claims with the current key, naming it in the "kid" header.
+// The issuer claim is always set.
+func (ks *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	ks.mu.RLock()
+	key := ks.current
+	ks.mu.RUnlock()
+	if key.private == nil {
+		return "", errors.New("signing keys are not loaded")
+	}
+
+	claims["iss"] = Issuer()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token signed by one of the keys in the set and checks its
+// issuer and audience.
+func (ks *KeySet) Parse(tokenString, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		ks.mu.RLock()
+		key, ok := ks.keys[kid]
+		ks.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key.public, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(Issuer()),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseAccessToken verifies an access token issued by GenerateTokens or
+// RefreshToken.
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	return Keys.Parse(tokenString, Audience())
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// PublicKeys returns every key that currently verifies tokens.
+func (ks *KeySet) PublicKeys() []JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]signingKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.After(keys[j].createdAt) })
+
+	jwks := make([]JWK, 0, len(keys))
+	for _, k := range keys {
+		jwks = append(jwks, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k.public),
+			Kid: k.kid,
+			Alg: jwt.SigningMethodEdDSA.Alg(),
+			Use: "sig",
+		})
+	}
+	return jwks
+}
+
+// JWKS publishes the verification keys so other services can check access
+// tokens without sharing a secret.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": Keys.PublicKeys()})
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/dbtest"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testSigningKey returns a stored key created at the given time.
+func testSigningKey(t *testing.T, createdAt time.Time) model.SigningKey {
+	t.Helper()
+	key, err := newSigningKey(createdAt.Add(2 * defaultKeyRotation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.CreatedAt = createdAt
+	return key
+}
+
+// testKeySet returns a key set holding keys, newest first.
+func testKeySet(t *testing.T, keys ...model.SigningKey) *KeySet {
+	t.Helper()
+	ks := &KeySet{}
+	if err := ks.load(keys); err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func accessClaims(audience string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub": "7",
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}
+}
+
+func TestKeySetSignsWithNewestKey(t *testing.T) {
+	old := testSigningKey(t, time.Now().Add(-40*24*time.Hour))
+	current := testSigningKey(t, time.Now())
+	ks := testKeySet(t, current, old)
+
+	signed, err := ks.Sign(accessClaims(Audience()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != current.KID {
+		t.Errorf("kid = %v; want the newest key %s", kid, current.KID)
+	}
+	if alg := token.Header["alg"]; alg != "EdDSA" {
+		t.Errorf("alg = %v; want EdDSA", alg)
+	}
+
+	claims, err := ks.Parse(signed, Audience())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != Issuer() || claims["sub"] != "7" {
+		t.Errorf("claims = %v; want issuer %s and subject 7", claims, Issuer())
+	}
+}
+
+func TestKeySetVerifiesRetiredKeys(t *testing.T) {
+	first := testSigningKey(t, time.Now().Add(-40*24*time.Hour))
+	ks := testKeySet(t, first)
+	signed, err := ks.Sign(accessClaims(Audience()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After a rotation the retired key still verifies its tokens
+	second := testSigningKey(t, time.Now())
+	if err := ks.load([]model.SigningKey{second, first}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Parse(signed, Audience()); err != nil {
+		t.Errorf("token signed with the retired key: %v", err)
+	}
+
+	// Once the key expires and is dropped, they are rejected
+	if err := ks.load([]model.SigningKey{second}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Parse(signed, Audience()); err == nil {
+		t.Error("token signed with a dropped key was accepted")
+	}
+}
+
+func TestKeySetRejects(t *testing.T) {
+	key := testSigningKey(t, time.Now())
+	ks := testKeySet(t, key)
+	other := testKeySet(t, testSigningKey(t, time.Now()))
+
+	sign := func(ks *KeySet, claims jwt.MapClaims) string {
+		signed, err := ks.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	t.Setenv("JWT_ISSUER", "someone-else")
+	wrongIssuer := sign(ks, accessClaims(Audience()))
+	t.Setenv("JWT_ISSUER", "")
+
+	// A token naming our key but signed by another one
+	forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, accessClaims(Audience()))
+	forged.Header["kid"] = key.KID
+	_, otherPrivate, _ := ed25519.GenerateKey(nil)
+	forgedToken, err := forged.SignedString(otherPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noExpiry := accessClaims(Audience())
+	delete(noExpiry, "exp")
+	expired := accessClaims(Audience())
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(Audience())).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"wrong issuer":   wrongIssuer,
+		"wrong audience": sign(ks, accessClaims(twoFactorAudience())),
+		"unknown kid":    sign(other, accessClaims(Audience())),
+		"forged":         forgedToken,
+		"no expiry":      sign(ks, noExpiry),
+		"expired":        sign(ks, expired),
+		"HMAC":           hmac,
+	}
+	for name, token := range tests {
+		if _, err := ks.Parse(token, Audience()); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+
+	if _, err := (&KeySet{}).Sign(accessClaims(Audience())); err == nil {
+		t.Error("signing without keys succeeded")
+	}
+}
+
+func TestKeySetRotate(t *testing.T) {
+	var stored []model.SigningKey
+	fake := useFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		columns := []string{"id", "created_at", "k_id", "private_key", "expires_at"}
+		switch {
+		case strings.HasPrefix(query, `INSERT INTO "signing_keys"`):
+			row := dbtest.Inserted(query, args)
+			stored = append([]model.SigningKey{{
+				KID:        row["k_id"].(string),
+				PrivateKey: row["private_key"].([]byte),
+				ExpiresAt:  row["expires_at"].(time.Time),
+			}}, stored...)
+			stored[0].CreatedAt = time.Now()
+			return []string{"id"}, [][]driver.Value{{int64(len(stored))}}
+		case strings.HasPrefix(query, `SELECT * FROM "signing_keys"`):
+			var rows [][]driver.Value
+			for i, k := range stored {
+				rows = append(rows, []driver.Value{int64(i + 1), k.CreatedAt, k.KID, k.PrivateKey, k.ExpiresAt})
+			}
+			return columns, rows
+		}
+		return nil, nil
+	})
+
+	ks := &KeySet{}
+	if err := ks.Rotate(model.DB, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || len(ks.PublicKeys()) != 1 {
+		t.Fatalf("stored %d keys, loaded %d; want the first key", len(stored), len(ks.PublicKeys()))
+	}
+	if len(fake.Executed("pg_advisory_xact_lock")) != 1 {
+		t.Error("rotation did not take the advisory lock")
+	}
+	first := stored[0].KID
+
+	// A fresh key is kept
+	if err := ks.Rotate(model.DB, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("stored %d keys after rotating a fresh key; want 1", len(stored))
+	}
+
+	// An old one is replaced but still verifies
+	stored[0].CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := ks.Rotate(model.DB, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0].KID == first {
+		t.Fatalf("stored keys = %d, newest %s; want a new key", len(stored), stored[0].KID)
+	}
+	if got := ks.PublicKeys(); len(got) != 2 || got[0].Kid != stored[0].KID || got[1].Kid != first {
+		t.Errorf("public keys = %v; want the new key then %s", got, first)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	old := testSigningKey(t, time.Now().Add(-40*24*time.Hour))
+	current := testSigningKey(t, time.Now())
+	previous := Keys
+	Keys = testKeySet(t, current, old)
+	t.Cleanup(func() { Keys = previous })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/.well-known/jwks.json", JWKS)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if cache := w.Header().Get("Cache-Control"); !strings.Contains(cache, "max-age") {
+		t.Errorf("Cache-Control = %q; want the keys to be cacheable", cache)
+	}
+
+	var body struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Keys) != 2 || body.Keys[0].Kid != current.KID || body.Keys[1].Kid != old.KID {
+		t.Fatalf("keys = %v; want %s then %s", body.Keys, current.KID, old.KID)
+	}
+
+	// The published key verifies tokens signed by the set
+	signed, err := Keys.Sign(accessClaims(Audience()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := body.Keys[0]
+	if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" || jwk.Use != "sig" {
+		t.Errorf("key = %+v; want an Ed25519 signing key", jwk)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return ed25519.PublicKey(x), nil },
+		jwt.WithValidMethods([]string{"EdDSA"}))
+	if err != nil {
+		t.Errorf("token does not verify with the published key: %v", err)
+	}
+}
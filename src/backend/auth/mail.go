@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"backend/mailer"
+)
+
+// Mail delivers the emails sent by the auth package. The API server replaces
+// it with mailer.FromEnv at startup.
+var Mail mailer.Mailer = &mailer.MemoryMailer{}
+
+// AppURL is the base URL of the frontend, used in links sent by email and
+// payment redirects.
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:5173"
+}
+
+// SendMail delivers a message in the background so that response times do
+// not reveal whether an account exists.
+func SendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := Mail.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
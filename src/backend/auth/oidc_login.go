@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+var (
+	errInvalidOIDCState  = errors.New("invalid or expired login state")
+	errOIDCEmailRequired = errors.New("provider did not share a valid email address")
+	errOIDCAccountExists = errors.New("an account with this email already exists")
+)
+
+// ListOIDCProviders returns the names of the providers players can sign in
+// with.
+func ListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": OIDCProviderNames()})
+}
+
+// OIDCLogin starts signing in with a provider. The frontend sends the player
+// to the returned authorization URL; the provider redirects back with a code
+// and state for OIDCCallback.
+func OIDCLogin(c *gin.Context) {
+	startOIDCLogin(c, 0)
+}
+
+// startOIDCLogin records a pending login and responds with the provider's
+// authorization URL. A non-zero guestID makes the login upgrade that guest.
+func startOIDCLogin(c *gin.Context, guestID uint) {
+	provider, ok := oidcProvider(c.Param("provider"))
+	if !ok {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUnknownProvider, "Unknown login provider")
+		return
+	}
+
+	state, err1 := newOpaqueToken()
+	nonce, err2 := newOpaqueToken()
+	verifier, err3 := newOpaqueToken()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start login")
+		return
+	}
+
+	authURL, err := provider.AuthorizationURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println("OIDC discovery failed:", err)
+		apierror.Abort(c, http.StatusBadGateway, apierror.CodeProviderUnavailable, "Login provider is unavailable")
+		return
+	}
+
+	if err := model.DB.Create(&model.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		GuestUserID:  guestID,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start login")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// OIDCCallback finishes signing in with a provider and issues the same tokens
+// as Login.
+func OIDCCallback(c *gin.Context) {
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	provider, ok := oidcProvider(c.Param("provider"))
+	if !ok {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUnknownProvider, "Unknown login provider")
+		return
+	}
+
+	login, err := consumeOIDCState(model.DB, provider.Name, req.State)
+	if errors.Is(err, errInvalidOIDCState) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidLoginState, "Invalid or expired login state")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to complete login")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+	identity, err := provider.Exchange(ctx, req.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", provider.Name, err)
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeProviderLoginFailed, "Login with provider failed")
+		return
+	}
+
+	var user model.User
+	var created bool
+	if login.GuestUserID != 0 {
+		user, err = upgradeGuestWithIdentity(model.DB, login.GuestUserID, provider.Name, identity)
+	} else {
+		user, created, err = oidcUser(model.DB, provider.Name, identity)
+	}
+	switch {
+	case errors.Is(err, errNotGuest):
+		apierror.Abort(c, http.StatusConflict, apierror.CodeNotGuest, "Only guest accounts can be upgraded")
+		return
+	case errors.Is(err, errOIDCEmailRequired):
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeProviderEmailMissing, "The provider did not share a valid email address")
+		return
+	case errors.Is(err, errOIDCAccountExists):
+		apierror.Abort(c, http.StatusConflict, apierror.CodeAccountExists, "An account with this email already exists. Sign in with your password to link it.")
+		return
+	case err != nil:
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to complete login")
+		return
+	}
+	if created && !user.EmailVerified() {
+		if err := sendVerificationEmail(model.DB, user, user.Email); err != nil {
+			log.Println("Failed to send verification email:", err)
+		}
+	}
+
+	if user.TOTPEnabledAt != nil {
+		challenge, err := newTwoFactorChallenge(user.ID)
+		if err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		return
+	}
+
+	token, err := GenerateTokens(user.ID, Client(c))
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Login successful",
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		userIDField(c):  user.ID,
+		"new_account":   created,
+	})
+}
+
+// consumeOIDCState looks up and deletes the pending login for a state value,
+// so each state can be used once.
+func consumeOIDCState(db *gorm.DB, provider, state string) (model.OIDCLoginState, error) {
+	var login model.OIDCLoginState
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ?", hashToken(state)).
+			First(&login).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidOIDCState
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&login).Error; err != nil {
+			return err
+		}
+		if login.Provider != provider || time.Now().After(login.ExpiresAt) {
+			return errInvalidOIDCState
+		}
+		return nil
+	})
+	return login, err
+}
+
+// oidcUser finds the user for a provider identity. Identities seen before
+// map to their linked user; otherwise the identity is linked to the account
+// with the same email when both sides have verified it, or a new account is
+// created.
+func oidcUser(db *gorm.DB, provider string, identity *OIDCIdentity) (model.User, bool, error) {
+	var user model.User
+	var created bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var link model.LinkedIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, identity.Subject).First(&link).Error
+		if err == nil {
+			return tx.First(&user, link.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		email, err := normalizeEmail(identity.Email)
+		if err != nil {
+			return errOIDCEmailRequired
+		}
+
+		err = tx.Where("email = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			// Linking to an address the provider or the account never proved
+			// would let whoever registered it first take over the other.
+			if !identity.EmailVerified || !user.EmailVerified() {
+				return errOIDCAccountExists
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			username, err := availableUsername(tx, identity.PreferredUsername, email)
+			if err != nil {
+				return err
+			}
+			user = model.User{Username: username, Email: email}
+			if identity.EmailVerified {
+				now := time.Now()
+				user.EmailVerifiedAt = &now
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			created = true
+		default:
+			return err
+		}
+
+		return tx.Create(&model.LinkedIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    email,
+		}).Error
+	})
+	return user, created, err
+}
+
+// availableUsername derives an unused username from the provider's preferred
+// username or the local part of the email address.
+func availableUsername(db *gorm.DB, preferred, email string) (string, error) {
+	base := sanitizeUsername(preferred)
+	if base == "" {
+		base = sanitizeUsername(email[:strings.LastIndex(email, "@")])
+	}
+	if base == "" {
+		base = "player"
+	}
+
+	candidate := base
+	for i := 0; i < 10; i++ {
+		var count int64
+		if err := db.Model(&model.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%04d", base, n.Int64())
+	}
+	return "", errors.New("could not find an available username")
+}
+
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '_' || r == '-' || r == '.' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+		if b.Len() >= 20 {
+			break
+		}
+	}
+	return b.String()
+}
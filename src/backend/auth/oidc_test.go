@@ -0,0 +1,212 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockOIDC is a minimal OpenID Connect provider. Authorize stands in for the
+// player signing in at the provider and returns the code it would redirect
+// back with.
+type mockOIDC struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+
+	// Claims overrides claims of the next ID tokens.
+	Claims jwt.MapClaims
+}
+
+type mockAuthorization struct {
+	clientID, redirectURI, challenge, nonce string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockOIDC{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock-key",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOIDC) Authorize(t *testing.T, authorizationURL string) string {
+	u, err := url.Parse(authorizationURL)
+	require.NoError(t, err)
+	q := u.Query()
+	require.Equal(t, m.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(t, "code", q.Get("response_type"))
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+	require.NotEmpty(t, q.Get("state"))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "code-" + q.Get("state")
+	m.codes[code] = mockAuthorization{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	return code
+}
+
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	authz, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != authz.clientID ||
+		r.PostForm.Get("client_secret") != "secret" ||
+		r.PostForm.Get("redirect_uri") != authz.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != authz.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":                m.URL,
+		"aud":                authz.clientID,
+		"sub":                "mock-user-1",
+		"email":              "player@example.com",
+		"email_verified":     true,
+		"preferred_username": "player",
+		"nonce":              authz.nonce,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range m.Claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock-key"
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func newTestProvider(m *mockOIDC) *auth.OIDCProvider {
+	return &auth.OIDCProvider{
+		Name:         "mock",
+		Issuer:       m.URL,
+		ClientID:     "the-last-game",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:5173/login/mock/callback",
+		HTTPClient:   m.Client(),
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	m := newMockOIDC(t)
+	p := newTestProvider(m)
+	ctx := context.Background()
+
+	authURL, err := p.AuthorizationURL(ctx, "state-1", "nonce-1", "verifier-with-enough-entropy-0000000000000")
+	require.NoError(t, err)
+	code := m.Authorize(t, authURL)
+
+	identity, err := p.Exchange(ctx, code, "verifier-with-enough-entropy-0000000000000", "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "mock-user-1", identity.Subject)
+	assert.Equal(t, "player@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "player", identity.PreferredUsername)
+}
+
+func TestOIDCRejectsWrongCodeVerifier(t *testing.T) {
+	m := newMockOIDC(t)
+	p := newTestProvider(m)
+	ctx := context.Background()
+
+	authURL, err := p.AuthorizationURL(ctx, "state-1", "nonce-1", "the-real-verifier")
+	require.NoError(t, err)
+	code := m.Authorize(t, authURL)
+
+	_, err = p.Exchange(ctx, code, "a-different-verifier", "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	cases := map[string]struct {
+		claims jwt.MapClaims
+		nonce  string
+	}{
+		"nonce mismatch":  {nonce: "another-nonce"},
+		"wrong audience":  {claims: jwt.MapClaims{"aud": "someone-else"}, nonce: "nonce-1"},
+		"wrong issuer":    {claims: jwt.MapClaims{"iss": "https://evil.example"}, nonce: "nonce-1"},
+		"expired":         {claims: jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, nonce: "nonce-1"},
+		"missing subject": {claims: jwt.MapClaims{"sub": ""}, nonce: "nonce-1"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := newMockOIDC(t)
+			m.Claims = tc.claims
+			p := newTestProvider(m)
+			ctx := context.Background()
+
+			authURL, err := p.AuthorizationURL(ctx, "state-1", "nonce-1", "verifier")
+			require.NoError(t, err)
+			code := m.Authorize(t, authURL)
+
+			_, err = p.Exchange(ctx, code, "verifier", tc.nonce)
+			assert.Error(t, err)
+		})
+	}
+}
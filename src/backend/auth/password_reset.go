@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"backend/apierror"
+	"backend/mailer"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const passwordResetTTL = time.Hour
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// ForgotPassword emails a password reset link to the account with the given
+// address. It answers the same way whether or not the account exists.
+func ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+
+	// Addresses are stored normalized
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidEmail, "Invalid email address")
+		return
+	}
+
+	var user model.User
+	err = model.DB.Where("email = ?", email).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start password reset")
+		return
+	}
+
+	if err == nil {
+		token, err := newOpaqueToken()
+		if err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start password reset")
+			return
+		}
+
+		err = model.DB.Transaction(func(tx *gorm.DB) error {
+			// Only the newest link works
+			if err := tx.Model(&model.PasswordResetToken{}).
+				Where("user_id = ? AND used_at IS NULL", user.ID).
+				Update("used_at", time.Now()).Error; err != nil {
+				return err
+			}
+			return tx.Create(&model.PasswordResetToken{
+				UserID:    user.ID,
+				TokenHash: hashToken(token),
+				ExpiresAt: time.Now().Add(passwordResetTTL),
+			}).Error
+		})
+		if err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start password reset")
+			return
+		}
+
+		link := AppURL() + "/reset-password?token=" + url.QueryEscape(token)
+		SendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+				"Use this link within %d minutes to choose a new one:\n\n%s\n\n"+
+				"If it wasn't you, you can ignore this email.\n",
+				user.Username, int(passwordResetTTL.Minutes()), link),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account uses that email, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword, signs
+// the account out everywhere and revokes its personal access tokens.
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+
+	hashedPassword, err := hashString(req.Password)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error hashing password")
+		return
+	}
+
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		var reset model.PasswordResetToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(req.Token)).
+			First(&reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+			return errInvalidResetToken
+		}
+
+		now := time.Now()
+		if err := tx.Model(&reset).Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.User{}).Where("id = ?", reset.UserID).
+			Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		if err := RevokeUserAccessTokens(tx, reset.UserID); err != nil {
+			return err
+		}
+		return RevokeUserSessions(tx, reset.UserID, "")
+	})
+	if errors.Is(err, errInvalidResetToken) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidResetToken, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to reset password")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/dbtest"
+	"backend/mailer"
+)
+
+// resetTokens is the password_reset_tokens table of a fake database.
+type resetTokens struct {
+	mu     sync.Mutex
+	byHash map[string]*resetToken
+}
+
+type resetToken struct {
+	id        int64
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+// useResetDB points model.DB at a fake database holding user 7, bob, whose
+// address is Bob@example.com and who is signed in on two devices.
+func useResetDB(t *testing.T) (*dbtest.DB, *resetTokens) {
+	tokens := &resetTokens{byHash: map[string]*resetToken{}}
+	fake := useFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		tokens.mu.Lock()
+		defer tokens.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "users" WHERE email = $1`):
+			if args[0] == "Bob@example.com" {
+				return []string{"id", "username", "email"}, [][]driver.Value{{int64(7), "bob", "Bob@example.com"}}
+			}
+		case strings.HasPrefix(query, `INSERT INTO "password_reset_tokens"`):
+			row := dbtest.Inserted(query, args)
+			id := int64(len(tokens.byHash) + 1)
+			tokens.byHash[row["token_hash"].(string)] = &resetToken{
+				id:        id,
+				userID:    row["user_id"].(int64),
+				expiresAt: row["expires_at"].(time.Time),
+			}
+			return []string{"id"}, [][]driver.Value{{id}}
+		case strings.HasPrefix(query, `SELECT * FROM "password_reset_tokens" WHERE token_hash = $1`):
+			if tok, ok := tokens.byHash[args[0].(string)]; ok {
+				var usedAt driver.Value
+				if tok.used {
+					usedAt = time.Now()
+				}
+				return []string{"id", "user_id", "token_hash", "expires_at", "used_at"},
+					[][]driver.Value{{tok.id, tok.userID, args[0], tok.expiresAt, usedAt}}
+			}
+		case strings.HasPrefix(query, `SELECT "family_id" FROM "sessions"`):
+			return []string{"family_id"}, [][]driver.Value{{"phone"}, {"laptop"}}
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		if !strings.HasPrefix(query, `UPDATE "password_reset_tokens" SET "used_at"`) {
+			return 1
+		}
+		tokens.mu.Lock()
+		defer tokens.mu.Unlock()
+		var n int64
+		for _, tok := range tokens.byHash {
+			if strings.Contains(query, "user_id = $3") && tok.userID == args[2] && !tok.used ||
+				strings.Contains(query, `"id" = $3`) && tok.id == args[2] {
+				tok.used = true
+				n++
+			}
+		}
+		return n
+	})
+	return fake, tokens
+}
+
+// useMemoryMailer collects the emails sent by the auth package until the
+// test ends.
+func useMemoryMailer(t *testing.T) *mailer.MemoryMailer {
+	m := &mailer.MemoryMailer{}
+	previous := Mail
+	Mail = m
+	t.Cleanup(func() { Mail = previous })
+	return m
+}
+
+var resetLink = regexp.MustCompile(`/reset-password\?token=(\S+)`)
+
+// requestReset asks for a reset link for email and returns the token it
+// carries.
+func requestReset(t *testing.T, m *mailer.MemoryMailer, email string) string {
+	t.Helper()
+	sent := len(m.Messages())
+	if w := serveAs(0, ForgotPassword, `{"email": "`+email+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("ForgotPassword: status = %d: %s", w.Code, w.Body)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if messages := m.Messages(); len(messages) > sent {
+			msg := messages[sent]
+			if msg.To != "Bob@example.com" {
+				t.Errorf("reset link sent to %q; want Bob@example.com", msg.To)
+			}
+			match := resetLink.FindStringSubmatch(msg.Body)
+			if match == nil {
+				t.Fatalf("no reset link in %q", msg.Body)
+			}
+			token, err := url.QueryUnescape(match[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}
+	}
+	t.Fatal("no reset link was sent")
+	return ""
+}
+
+func resetPassword(token string) (int, string) {
+	w := serveAs(0, ResetPassword, `{"token": "`+token+`", "password": "new password"}`)
+	if w.Code == http.StatusOK {
+		return w.Code, ""
+	}
+	var body apierror.Response
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body.Error.Code
+}
+
+func TestForgotPasswordNormalizesEmail(t *testing.T) {
+	useResetDB(t)
+	m := useMemoryMailer(t)
+
+	// The domain's case and surrounding spaces do not matter
+	requestReset(t, m, " Bob@EXAMPLE.com ")
+
+	if w := serveAs(0, ForgotPassword, `{"email": "not an address"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid address: status = %d; want 400", w.Code)
+	}
+}
+
+func TestResetTokenIsSingleUse(t *testing.T) {
+	fake, _ := useResetDB(t)
+	m := useMemoryMailer(t)
+
+	token := requestReset(t, m, "Bob@example.com")
+	if status, code := resetPassword(token); status != http.StatusOK {
+		t.Fatalf("first reset: status = %d, code = %s; want 200", status, code)
+	}
+	if updated := fake.Executed(`UPDATE "users" SET "password"`); len(updated) != 1 {
+		t.Errorf("password updated %d times; want once", len(updated))
+	}
+
+	// Signed out everywhere, with every access token revoked
+	revoked := fake.Executed(`UPDATE "sessions" SET "revoked_at"`)
+	if len(revoked) != 2 {
+		t.Errorf("revoked %d sessions; want both", len(revoked))
+	}
+	if tokens := fake.Executed(`DELETE FROM "personal_access_tokens"`); len(tokens) != 1 || tokens[0].Args[0] != int64(7) {
+		t.Errorf("access tokens revoked: %v; want those of user 7", tokens)
+	}
+
+	if status, code := resetPassword(token); status != http.StatusBadRequest || code != apierror.CodeInvalidResetToken {
+		t.Errorf("second reset: status = %d, code = %s; want 400 %s", status, code, apierror.CodeInvalidResetToken)
+	}
+}
+
+func TestResetTokenExpires(t *testing.T) {
+	_, tokens := useResetDB(t)
+	m := useMemoryMailer(t)
+
+	token := requestReset(t, m, "Bob@example.com")
+	tokens.mu.Lock()
+	tok := tokens.byHash[hashToken(token)]
+	if got := time.Until(tok.expiresAt); got < passwordResetTTL-time.Minute || got > passwordResetTTL {
+		t.Errorf("token expires in %s; want %s", got, passwordResetTTL)
+	}
+	tok.expiresAt = time.Now().Add(-time.Second)
+	tokens.mu.Unlock()
+
+	if status, code := resetPassword(token); status != http.StatusBadRequest || code != apierror.CodeInvalidResetToken {
+		t.Errorf("expired token: status = %d, code = %s; want 400 %s", status, code, apierror.CodeInvalidResetToken)
+	}
+}
+
+func TestNewResetTokenReplacesOlder(t *testing.T) {
+	useResetDB(t)
+	m := useMemoryMailer(t)
+
+	first := requestReset(t, m, "Bob@example.com")
+	second := requestReset(t, m, "Bob@example.com")
+	if status, _ := resetPassword(first); status != http.StatusBadRequest {
+		t.Errorf("older token: status = %d; want 400", status)
+	}
+	if status, code := resetPassword(second); status != http.StatusOK {
+		t.Errorf("newest token: status = %d, code = %s; want 200", status, code)
+	}
+	if status, _ := resetPassword("unknown"); status != http.StatusBadRequest {
+		t.Errorf("unknown token: status = %d; want 400", status)
+	}
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apierror"
+	"backend/mailer"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 24
+	minPasswordLength = 8
+)
+
+// reservedUsernames cannot be registered, compared case-insensitively.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "moderator": true, "mod": true,
+	"root": true, "system": true, "support": true, "staff": true,
+	"api": true, "null": true, "undefined": true, "me": true,
+	"guest": true, "deleted": true, "the-last-game": true,
+}
+
+// reservedUsernamePrefixes are used for generated accounts.
+var reservedUsernamePrefixes = []string{"guest-", "deleted-"}
+
+var (
+	errInvalidUsername  = errors.New("usernames are 3 to 24 letters, digits, '.', '-' or '_'")
+	errReservedUsername = errors.New("this username is reserved")
+)
+
+type ProfileDTO struct {
+	ID                  uint                  `json:"id"`
+	Username            string                `json:"username"`
+	Email               string                `json:"email"`
+	EmailVerified       bool                  `json:"email_verified"`
+	Role                string                `json:"role"`
+	SubscriptionLevel   int                   `json:"subscription_level"`
+	IsGuest             bool                  `json:"is_guest"`
+	TwoFactorEnabled    bool                  `json:"two_factor_enabled"`
+	HasPassword         bool                  `json:"has_password"`
+	Preferences         model.UserPreferences `json:"preferences"`
+	CreatedAt           time.Time             `json:"created_at"`
+	DeletionScheduledAt *time.Time            `json:"deletion_scheduled_at"`
+}
+
+// NewProfile is the view of a user that is safe to send to clients: no
+// password hash, secrets or internal billing references.
+func NewProfile(u model.User) ProfileDTO {
+	return ProfileDTO{
+		ID:                  u.ID,
+		Username:            u.Username,
+		Email:               u.Email,
+		EmailVerified:       u.EmailVerified(),
+		Role:                u.Role,
+		SubscriptionLevel:   u.SubscriptionLevel,
+		IsGuest:             u.IsGuest,
+		TwoFactorEnabled:    u.TOTPEnabledAt != nil,
+		HasPassword:         u.Password != "",
+		Preferences:         u.Preferences,
+		CreatedAt:           u.CreatedAt,
+		DeletionScheduledAt: u.DeletionScheduledAt,
+	}
+}
+
+// validateUsername checks the username's length, characters and that it is
+// not reserved.
+func validateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return errInvalidUsername
+	}
+	for _, r := range username {
+		if !(r == '_' || r == '-' || r == '.' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return errInvalidUsername
+		}
+	}
+	lower := strings.ToLower(username)
+	if reservedUsernames[lower] {
+		return errReservedUsername
+	}
+	for _, prefix := range reservedUsernamePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return errReservedUsername
+		}
+	}
+	return nil
+}
+
+// usernameTaken reports whether another user has the username, ignoring case.
+func usernameTaken(db *gorm.DB, username string, except uint) (bool, error) {
+	var count int64
+	err := db.Model(&model.User{}).
+		Where("LOWER(username) = LOWER(?) AND id <> ?", username, except).
+		Count(&count).Error
+	return count > 0, err
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password"`
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type UpdatePreferencesRequest struct {
+	ColorScheme       string `json:"color_scheme" binding:"omitempty,oneof=light dark system"`
+	Language          string `json:"language" binding:"omitempty,max=16"`
+	ReduceMotion      bool   `json:"reduce_motion"`
+	ShowDamageNumbers bool   `json:"show_damage_numbers"`
+}
+
+// currentUser loads the authenticated user, writing an error response if it
+// cannot.
+func currentUser(c *gin.Context) (model.User, bool) {
+	var user model.User
+	if err := model.DB.First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return user, false
+	}
+	return user, true
+}
+
+// checkPassword confirms the user's current password. Accounts without one
+// (signed up through a login provider) have nothing to confirm.
+func checkPassword(c *gin.Context, user model.User, password string) bool {
+	if user.Password == "" {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeIncorrectPassword, "Current password is incorrect")
+		return false
+	}
+	return true
+}
+
+// GetProfile returns the current user's profile.
+func GetProfile(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, NewProfile(user))
+}
+
+// ChangePassword sets a new password after checking the current one, signs
+// out every other session and revokes the personal access tokens.
+func ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeWeakPassword, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok || !checkPassword(c, user, req.CurrentPassword) {
+		return
+	}
+
+	hashedPassword, err := hashString(req.NewPassword)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Error hashing password")
+		return
+	}
+	err = model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		if err := RevokeUserAccessTokens(tx, user.ID); err != nil {
+			return err
+		}
+		return RevokeUserSessions(tx, user.ID, c.GetString("sessionID"))
+	})
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to change password")
+		return
+	}
+
+	if !user.IsGuest {
+		SendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Your password was changed",
+			Body: fmt.Sprintf("Hi %s,\n\nThe password for your account was just changed, your other devices "+
+				"were signed out and your access tokens were revoked.\n\nIf this wasn't you, reset your password right away.\n", user.Username),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed; other sessions were signed out and access tokens revoked"})
+}
+
+// ChangeEmail sends a verification link to a new address. The address on
+// the account changes only once that link is followed.
+func ChangeEmail(c *gin.Context) {
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidEmail, "Invalid email address")
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok || !checkPassword(c, user, req.Password) {
+		return
+	}
+	if user.IsGuest {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeGuestNotAllowed, "Upgrade the guest account to set an email address")
+		return
+	}
+	if email == user.Email {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeSameEmail, "That is already your email address")
+		return
+	}
+
+	var count int64
+	if err := model.DB.Model(&model.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to change email")
+		return
+	}
+	if count > 0 {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeEmailTaken, "Email is already in use")
+		return
+	}
+
+	wait, err := resendAllowedIn(model.DB, user.ID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to change email")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeVerificationThrottled, "Too many verification emails requested, try again later")
+		return
+	}
+
+	if err := sendVerificationEmail(model.DB, user, email); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to send verification email")
+		return
+	}
+	SendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address of your account to %s. "+
+			"It will change once the new address is confirmed.\n\nIf this wasn't you, change your password.\n",
+			user.Username, email),
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent to the new address"})
+}
+
+// ChangeUsername renames the current user.
+func ChangeUsername(c *gin.Context) {
+	var req ChangeUsernameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	if err := validateUsername(req.Username); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidUsername, err.Error())
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.IsGuest {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeGuestNotAllowed, "Upgrade the guest account to choose a username")
+		return
+	}
+
+	taken, err := usernameTaken(model.DB, req.Username, user.ID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to change username")
+		return
+	}
+	if taken {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeUsernameTaken, "Username is already in use")
+		return
+	}
+
+	if err := model.DB.Model(&user).Update("username", req.Username).Error; err != nil {
+		log.Println("Database error:", err)
+		apierror.Abort(c, http.StatusConflict, apierror.CodeUsernameTaken, "Username is already in use")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Username changed", "username": req.Username})
+}
+
+// UpdatePreferences replaces the current user's display preferences.
+func UpdatePreferences(c *gin.Context) {
+	var req UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+
+	prefs := model.UserPreferences{
+		ColorScheme:       req.ColorScheme,
+		Language:          req.Language,
+		ReduceMotion:      req.ReduceMotion,
+		ShowDamageNumbers: req.ShowDamageNumbers,
+	}
+	if err := model.DB.Model(&model.User{}).Where("id = ?", c.MustGet("userID").(uint)).
+		Update("preferences", prefs).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save preferences")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Preferences saved", "preferences": prefs})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/dbtest"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateUsername(t *testing.T) {
+	for _, name := range []string{"bob", "Player_One", "first.last", "a-b-c", "abcdefghijklmnopqrstuvwx"} {
+		if err := validateUsername(name); err != nil {
+			t.Errorf("validateUsername(%q) = %v; want nil", name, err)
+		}
+	}
+
+	invalid := map[string]error{
+		"ab":                        errInvalidUsername,
+		"abcdefghijklmnopqrstuvwxy": errInvalidUsername,
+		"has space":                 errInvalidUsername,
+		"émile":                     errInvalidUsername,
+		"Admin":                     errReservedUsername,
+		"ROOT":                      errReservedUsername,
+		"guest-1234":                errReservedUsername,
+		"Deleted-7":                 errReservedUsername,
+	}
+	for name, want := range invalid {
+		if err := validateUsername(name); err != want {
+			t.Errorf("validateUsername(%q) = %v; want %v", name, err, want)
+		}
+	}
+}
+
+// useFakeDB points model.DB at a fake database answering queries with
+// respond until the test ends.
+func useFakeDB(t *testing.T, respond dbtest.Responder) *dbtest.DB {
+	t.Helper()
+	db, fake := dbtest.Open(t, respond)
+	previous := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = previous })
+	return fake
+}
+
+// serveAs runs handler for a request with body, as the user signed in to
+// session 'current'.
+func serveAs(userID uint, handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Set("sessionID", "current")
+	}, handler)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestChangePasswordRevokesAccessTokens(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old password"), bcrypt.MinCost)
+	fake := useFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "users"`):
+			return []string{"id", "username", "password"}, [][]driver.Value{{int64(7), "bob", string(hash)}}
+		case strings.HasPrefix(query, `SELECT "family_id" FROM "sessions"`):
+			return []string{"family_id"}, [][]driver.Value{{"other"}}
+		}
+		return nil, nil
+	})
+
+	w := serveAs(7, ChangePassword, `{"current_password": "old password", "new_password": "new password"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	revoked := fake.Executed(`DELETE FROM "personal_access_tokens"`)
+	if len(revoked) != 1 || revoked[0].Args[0] != int64(7) {
+		t.Errorf("access tokens revoked: %v; want those of user 7", revoked)
+	}
+	if len(fake.Executed(`UPDATE "sessions" SET "revoked_at"`)) == 0 {
+		t.Error("other sessions were not signed out")
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// newOpaqueToken returns a random URL-safe token with 256 bits of entropy.
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is how opaque tokens are stored and looked up. The tokens are
+// random, so a fast unsalted hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newFamilyID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// issueRefreshToken stores a new refresh token in the given family and
+// returns its plaintext value.
+func issueRefreshToken(db *gorm.DB, userID uint, familyID string) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	record := model.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// revokeFamily ends a login: its session is marked revoked, which rejects
+// its access tokens, and every outstanding refresh token is invalidated.
+func revokeFamily(db *gorm.DB, familyID string) error {
+	now := time.Now()
+	if err := db.Model(&model.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return db.Model(&model.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// rotateRefreshToken consumes a refresh token and returns the user it
+// belongs to along with the next token of the same family. Presenting a
+// token that was already used revokes the whole family, since it means the
+// token was copied.
+func rotateRefreshToken(db *gorm.DB, token string) (uint, string, string, error) {
+	var record model.RefreshToken
+	var next string
+	var reused bool
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(token)).
+			First(&record).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if record.UsedAt != nil {
+			// commit the revocation, then report the reuse
+			reused = true
+			return revokeFamily(tx, record.FamilyID)
+		}
+		if record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+			return errInvalidRefreshToken
+		}
+
+		now := time.Now()
+		if err := tx.Model(&record).Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		next, err = issueRefreshToken(tx, record.UserID, record.FamilyID)
+		return err
+	})
+	if err != nil {
+		return 0, "", "", err
+	}
+	if reused {
+		log.Printf("Refresh token reuse for user %d, revoked family %s", record.UserID, record.FamilyID)
+		return 0, "", "", errRefreshTokenReused
+	}
+	return record.UserID, record.FamilyID, next, nil
+}
+
+// Logout revokes the session the given refresh token belongs to.
+func Logout(c *gin.Context) {
+	var req RefreshInfo
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request")
+		return
+	}
+
+	var record model.RefreshToken
+	err := model.DB.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&record).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to log out")
+		return
+	}
+	if err == nil {
+		if err := revokeFamily(model.DB, record.FamilyID); err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to log out")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/dbtest"
+	"backend/model"
+)
+
+// refreshTokens is the refresh_tokens table of a fake database, along with
+// the sessions that were revoked.
+type refreshTokens struct {
+	mu       sync.Mutex
+	byHash   map[string]*refreshToken
+	sessions map[string]bool // revoked sessions by family
+}
+
+type refreshToken struct {
+	id        int64
+	userID    int64
+	familyID  string
+	expiresAt time.Time
+	used      bool
+	revoked   bool
+}
+
+func (r *refreshTokens) get(token string) *refreshToken {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byHash[hashToken(token)]
+}
+
+// useRefreshDB points model.DB at a fake database keeping refresh tokens.
+func useRefreshDB(t *testing.T) (*dbtest.DB, *refreshTokens) {
+	tokens := &refreshTokens{byHash: map[string]*refreshToken{}, sessions: map[string]bool{}}
+	fake := useFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		tokens.mu.Lock()
+		defer tokens.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, `INSERT INTO "refresh_tokens"`):
+			row := dbtest.Inserted(query, args)
+			id := int64(len(tokens.byHash) + 1)
+			tokens.byHash[row["token_hash"].(string)] = &refreshToken{
+				id:        id,
+				userID:    row["user_id"].(int64),
+				familyID:  row["family_id"].(string),
+				expiresAt: row["expires_at"].(time.Time),
+			}
+			return []string{"id"}, [][]driver.Value{{id}}
+		case strings.HasPrefix(query, `SELECT * FROM "refresh_tokens" WHERE token_hash = $1`):
+			tok, ok := tokens.byHash[args[0].(string)]
+			if !ok {
+				return nil, nil
+			}
+			var usedAt, revokedAt driver.Value
+			if tok.used {
+				usedAt = time.Now()
+			}
+			if tok.revoked {
+				revokedAt = time.Now()
+			}
+			return []string{"id", "user_id", "family_id", "token_hash", "expires_at", "used_at", "revoked_at"},
+				[][]driver.Value{{tok.id, tok.userID, tok.familyID, args[0], tok.expiresAt, usedAt, revokedAt}}
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		tokens.mu.Lock()
+		defer tokens.mu.Unlock()
+		var n int64
+		switch {
+		case strings.HasPrefix(query, `UPDATE "refresh_tokens" SET "used_at"`):
+			for _, tok := range tokens.byHash {
+				if tok.id == args[2] {
+					tok.used = true
+					n++
+				}
+			}
+		case strings.HasPrefix(query, `UPDATE "refresh_tokens" SET "revoked_at"`):
+			for _, tok := range tokens.byHash {
+				if tok.familyID == args[2] && !tok.revoked {
+					tok.revoked = true
+					n++
+				}
+			}
+		case strings.HasPrefix(query, `UPDATE "sessions" SET "revoked_at"`):
+			tokens.sessions[args[2].(string)] = true
+			n = 1
+		}
+		return n
+	})
+	return fake, tokens
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	_, tokens := useRefreshDB(t)
+
+	first, err := issueRefreshToken(model.DB, 7, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, familyID, second, err := rotateRefreshToken(model.DB, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 7 || familyID != "phone" || second == "" || second == first {
+		t.Fatalf("rotateRefreshToken = %d, %q, %q; want user 7, family phone and a new token", userID, familyID, second)
+	}
+	if next := tokens.get(second); next == nil || next.familyID != "phone" {
+		t.Fatalf("next token = %+v; want one in the same family", next)
+	}
+
+	// The next token rotates in turn
+	_, _, third, err := rotateRefreshToken(model.DB, second)
+	if err != nil {
+		t.Fatalf("rotating the next token: %v", err)
+	}
+
+	// Presenting a rotated token again is reuse: the whole family goes
+	if _, _, _, err := rotateRefreshToken(model.DB, first); !errors.Is(err, errRefreshTokenReused) {
+		t.Fatalf("reusing a rotated token: error = %v; want %v", err, errRefreshTokenReused)
+	}
+	if !tokens.sessions["phone"] {
+		t.Error("the session was not revoked after reuse")
+	}
+	if _, _, _, err := rotateRefreshToken(model.DB, third); !errors.Is(err, errInvalidRefreshToken) {
+		t.Errorf("newest token after reuse: error = %v; want %v", err, errInvalidRefreshToken)
+	}
+}
+
+func TestRotateRefreshTokenRejects(t *testing.T) {
+	_, tokens := useRefreshDB(t)
+
+	expired, err := issueRefreshToken(model.DB, 7, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.get(expired).expiresAt = time.Now().Add(-time.Second)
+
+	for name, token := range map[string]string{"unknown": "unknown", "expired": expired} {
+		if _, _, _, err := rotateRefreshToken(model.DB, token); !errors.Is(err, errInvalidRefreshToken) {
+			t.Errorf("%s token: error = %v; want %v", name, err, errInvalidRefreshToken)
+		}
+	}
+	if tokens.sessions["phone"] {
+		t.Error("an expired token revoked its session")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	fake, tokens := useRefreshDB(t)
+
+	phone, err := issueRefreshToken(model.DB, 7, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := issueRefreshToken(model.DB, 7, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serveAs(7, Logout, `{"refresh_token": "`+phone+`"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if !tokens.sessions["phone"] || tokens.sessions["laptop"] {
+		t.Errorf("revoked sessions = %v; want only phone", tokens.sessions)
+	}
+	if _, _, _, err := rotateRefreshToken(model.DB, phone); !errors.Is(err, errInvalidRefreshToken) {
+		t.Errorf("refreshing after logout: error = %v; want %v", err, errInvalidRefreshToken)
+	}
+	if _, _, _, err := rotateRefreshToken(model.DB, laptop); err != nil {
+		t.Errorf("refreshing the other session: %v", err)
+	}
+
+	// An unknown token signs nothing out
+	revoked := len(fake.Executed(`SET "revoked_at"`))
+	if w := serveAs(7, Logout, `{"refresh_token": "unknown"}`); w.Code != http.StatusOK {
+		t.Errorf("unknown token: status = %d; want 200", w.Code)
+	}
+	if len(fake.Executed(`SET "revoked_at"`)) != revoked {
+		t.Error("logging out with an unknown token revoked something")
+	}
+}
+
+func TestRefreshTokenReportsReuse(t *testing.T) {
+	useRefreshDB(t)
+
+	token, err := issueRefreshToken(model.DB, 7, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := rotateRefreshToken(model.DB, token); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveAs(0, RefreshToken, `{"refresh_token": "`+token+`"}`)
+	var body apierror.Response
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusUnauthorized || body.Error.Code != apierror.CodeRefreshTokenReused {
+		t.Errorf("status = %d, code = %s; want 401 %s", w.Code, body.Error.Code, apierror.CodeRefreshTokenReused)
+	}
+}
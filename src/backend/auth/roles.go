@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// BootstrapAdmins gives the admin role to the users named in ADMIN_USERNAMES,
+// a comma separated list, so a fresh deployment has someone who can assign
+// roles.
+func BootstrapAdmins(db *gorm.DB) error {
+	var usernames []string
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			usernames = append(usernames, name)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	result := db.Model(&model.User{}).
+		Where("username IN ? AND role <> ?", usernames, model.RoleAdmin).
+		Update("role", model.RoleAdmin)
+	if result.RowsAffected > 0 {
+		log.Printf("Granted the admin role to %d user(s)", result.RowsAffected)
+	}
+	return result.Error
+}
+
+// SetUserRole changes the role of the user in the URL.
+func SetUserRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	if !model.ValidRole(req.Role) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeUnknownRole, "Unknown role")
+		return
+	}
+
+	result := model.DB.Model(&model.User{}).Where("id = ?", c.Param("id")).Update("role", req.Role)
+	if result.Error != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to update role")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "role": req.Role})
+}
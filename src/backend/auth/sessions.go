@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ClientInfo describes the device a session was started or last used from.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type SessionDTO struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// Client returns the user agent and IP address of the request.
+func Client(c *gin.Context) ClientInfo {
+	return ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
+// startSession records a new login and returns its ID.
+func startSession(db *gorm.DB, userID uint, client ClientInfo) (string, error) {
+	familyID, err := newFamilyID()
+	if err != nil {
+		return "", err
+	}
+	session := model.Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		LastUsedAt: time.Now(),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return "", err
+	}
+	return familyID, nil
+}
+
+// touchSession records that a session was just used from the given client.
+func touchSession(db *gorm.DB, familyID string, client ClientInfo) error {
+	return db.Model(&model.Session{}).
+		Where("family_id = ?", familyID).
+		Updates(map[string]interface{}{
+			"last_used_at": time.Now(),
+			"user_agent":   client.UserAgent,
+			"ip":           client.IP,
+		}).Error
+}
+
+// SessionActive reports whether the session with the given ID exists for
+// the user and has not been revoked.
+func SessionActive(db *gorm.DB, userID uint, sessionID string) (bool, error) {
+	var count int64
+	err := db.Model(&model.Session{}).
+		Where("family_id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// RevokeUserSessions revokes every session of a user except the one given,
+// which may be empty to revoke them all.
+func RevokeUserSessions(db *gorm.DB, userID uint, except string) error {
+	var familyIDs []string
+	if err := db.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND family_id <> ?", userID, except).
+		Pluck("family_id", &familyIDs).Error; err != nil {
+		return err
+	}
+	for _, familyID := range familyIDs {
+		if err := revokeFamily(db, familyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessions returns the active sessions of the current user.
+func ListSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	current := c.GetString("sessionID")
+
+	dtos, err := UserSessions(model.DB, userID, current)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to fetch sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": dtos})
+}
+
+// UserSessions returns the active sessions of a user, most recently used
+// first, marking the one with ID current.
+func UserSessions(db *gorm.DB, userID uint, current string) ([]SessionDTO, error) {
+	var sessions []model.Session
+	if err := db.
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	dtos := make([]SessionDTO, 0, len(sessions))
+	for _, s := range sessions {
+		dtos = append(dtos, SessionDTO{
+			ID:         s.FamilyID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			Current:    s.FamilyID == current,
+		})
+	}
+	return dtos, nil
+}
+
+// RevokeSession signs out one of the current user's sessions.
+func RevokeSession(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var session model.Session
+	err := model.DB.Where("family_id = ? AND user_id = ?", c.Param("id"), userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeSessionNotFound, "Session not found")
+		return
+	}
+	if err == nil {
+		err = revokeFamily(model.DB, session.FamilyID)
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to revoke session")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs out every session of the current user except
+// the one making the request.
+func RevokeOtherSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	if err := RevokeUserSessions(model.DB, userID, c.GetString("sessionID")); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to revoke sessions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked"})
+}
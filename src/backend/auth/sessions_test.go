@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useSessionsDB points model.DB at a fake database where user 7 is signed in
+// on phone, laptop and tablet and user 8 on desktop. It returns the sessions
+// revoked so far.
+func useSessionsDB(t *testing.T) func() []string {
+	owners := map[string]int64{"phone": 7, "laptop": 7, "tablet": 7, "desktop": 8}
+	var mu sync.Mutex
+	revoked := map[string]bool{}
+
+	fake := useFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "sessions"`) && strings.Contains(query, "family_id = $1 AND user_id = $2"):
+			if owner, ok := owners[args[0].(string)]; ok && owner == args[1] {
+				return []string{"id", "user_id", "family_id"}, [][]driver.Value{{int64(1), owner, args[0]}}
+			}
+		case strings.HasPrefix(query, `SELECT "family_id" FROM "sessions"`):
+			var rows [][]driver.Value
+			for family, owner := range owners {
+				if owner == args[0] && !revoked[family] && family != args[1] {
+					rows = append(rows, []driver.Value{family})
+				}
+			}
+			return []string{"family_id"}, rows
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		if strings.HasPrefix(query, `UPDATE "sessions" SET "revoked_at"`) {
+			mu.Lock()
+			defer mu.Unlock()
+			revoked[args[2].(string)] = true
+		}
+		return 1
+	})
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var families []string
+		for family := range revoked {
+			families = append(families, family)
+		}
+		sort.Strings(families)
+		return families
+	}
+}
+
+// revokeSession signs out session id as user 7, signed in on phone.
+func revokeSession(id string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/sessions/:id", func(c *gin.Context) {
+		c.Set("userID", uint(7))
+		c.Set("sessionID", "phone")
+	}, RevokeSession)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/sessions/"+id, nil))
+	return w
+}
+
+func TestRevokeSession(t *testing.T) {
+	revoked := useSessionsDB(t)
+
+	if w := revokeSession("laptop"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := revoked(); len(got) != 1 || got[0] != "laptop" {
+		t.Errorf("revoked = %v; want only laptop", got)
+	}
+
+	// Sessions of other users are not found
+	for _, id := range []string{"desktop", "unknown"} {
+		w := revokeSession(id)
+		var body apierror.Response
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusNotFound || body.Error.Code != apierror.CodeSessionNotFound {
+			t.Errorf("%s: status = %d, code = %s; want 404 %s", id, w.Code, body.Error.Code, apierror.CodeSessionNotFound)
+		}
+	}
+	if got := revoked(); len(got) != 1 {
+		t.Errorf("revoked = %v; want only laptop", got)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	revoked := useSessionsDB(t)
+
+	// Signed in on the phone, which stays signed in
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		c.Set("userID", uint(7))
+		c.Set("sessionID", "phone")
+	}, RevokeOtherSessions)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	if got := strings.Join(revoked(), ","); got != "laptop,tablet" {
+		t.Errorf("revoked = %s; want laptop,tablet", got)
+	}
+}
+
+func TestRevokeUserSessionsRevokesAll(t *testing.T) {
+	revoked := useSessionsDB(t)
+
+	if err := RevokeUserSessions(model.DB, 7, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(revoked(), ","); got != "laptop,phone,tablet" {
+		t.Errorf("revoked = %s; want every session of user 7", got)
+	}
+}
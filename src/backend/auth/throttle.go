@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/mailer"
+	"backend/model"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Failures older than throttleWindow are forgotten.
+	throttleWindow = time.Hour
+
+	// The first few failures are free; each one after that doubles the wait
+	// before the next attempt, starting at throttleBaseDelay.
+	accountFreeFailures = 3
+	ipFreeFailures      = 10
+	throttleBaseDelay   = time.Second
+	throttleMaxDelay    = 5 * time.Minute
+
+	// An account is locked for accountLockDuration after accountLockThreshold
+	// failures within the window.
+	accountLockThreshold = 10
+	accountLockDuration  = 15 * time.Minute
+)
+
+// OnAccountLocked is called when an account gets locked after too many failed
+// logins. By default it emails the account owner.
+var OnAccountLocked = func(user model.User, until time.Time) {
+	SendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your account has been temporarily locked",
+		Body: fmt.Sprintf("Hi %s,\n\nThere were too many failed attempts to sign in to your account, "+
+			"so it is locked until %s.\n\nIf this wasn't you, consider resetting your password.\n",
+			user.Username, until.UTC().Format(time.RFC1123)),
+	})
+}
+
+func accountThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBackoff is how long to wait after the given number of failures.
+func loginBackoff(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	delay := throttleBaseDelay
+	for i := free + 1; i < failures && delay < throttleMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > throttleMaxDelay {
+		delay = throttleMaxDelay
+	}
+	return delay
+}
+
+// freeFailures is how many failures the key allows before backing off.
+func freeFailures(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return ipFreeFailures
+	}
+	return accountFreeFailures
+}
+
+// loginWait returns how long the client must wait before another login
+// attempt for any of the keys is allowed.
+func loginWait(db *gorm.DB, now time.Time, keys ...string) (time.Duration, error) {
+	var throttles []model.LoginThrottle
+	if err := db.Where("key IN ? AND last_failure_at > ?", keys, now.Add(-throttleWindow)).
+		Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, t := range throttles {
+		until := t.LastFailureAt.Add(loginBackoff(t.Failures, freeFailures(t.Key)))
+		if t.LockedUntil != nil && t.LockedUntil.After(until) {
+			until = *t.LockedUntil
+		}
+		if d := until.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against each key. It returns the
+// time the account is locked until if this failure locked it.
+func recordLoginFailure(db *gorm.DB, now time.Time, accountKey string, otherKeys ...string) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range append([]string{accountKey}, otherKeys...) {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&model.LoginThrottle{Key: key}).Error; err != nil {
+				return err
+			}
+
+			var t model.LoginThrottle
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("key = ?", key).First(&t).Error; err != nil {
+				return err
+			}
+
+			if now.Sub(t.LastFailureAt) > throttleWindow {
+				t.Failures = 0
+			}
+			t.Failures++
+			t.LastFailureAt = now
+			if key == accountKey && t.Failures >= accountLockThreshold &&
+				(t.LockedUntil == nil || t.LockedUntil.Before(now)) {
+				until := now.Add(accountLockDuration)
+				t.LockedUntil = &until
+				t.Failures = 0
+				lockedUntil = &until
+			}
+			if err := tx.Save(&t).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return lockedUntil, err
+}
+
+// clearLoginFailures forgets the failures counted against a key.
+func clearLoginFailures(db *gorm.DB, key string) error {
+	return db.Unscoped().Where("key = ?", key).Delete(&model.LoginThrottle{}).Error
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyPassword spends as long as checking a real password, so that
+// logins for unknown usernames take as long as those with a wrong password.
+func compareDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+		if err != nil {
+			log.Println("Failed to create dummy password hash:", err)
+		}
+		dummyHash = hash
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{accountFreeFailures, 0},
+		{accountFreeFailures + 1, throttleBaseDelay},
+		{accountFreeFailures + 2, 2 * throttleBaseDelay},
+		{accountFreeFailures + 4, 8 * throttleBaseDelay},
+		{accountFreeFailures + 100, throttleMaxDelay},
+	}
+	for _, tc := range cases {
+		if got := loginBackoff(tc.failures, accountFreeFailures); got != tc.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tc.failures, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors for SHA-1.
+func TestHOTPMatchesRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		step := totpStep(time.Unix(unix, 0))
+		if got := hotp(key, uint64(step), 8); got != want {
+			t.Errorf("TOTP at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code := hotp([]byte("12345678901234567890"), uint64(totpStep(now)), totpDigits)
+
+	step, ok := validateTOTP(secret, code, now, 0)
+	if !ok || step != totpStep(now) {
+		t.Fatalf("current code rejected")
+	}
+	if _, ok := validateTOTP(secret, code, now.Add(totpPeriod), 0); !ok {
+		t.Errorf("code from the previous period rejected")
+	}
+	if _, ok := validateTOTP(secret, code, now.Add(3*totpPeriod), 0); ok {
+		t.Errorf("stale code accepted")
+	}
+	if _, ok := validateTOTP(secret, code, now, step); ok {
+		t.Errorf("replayed code accepted")
+	}
+	if _, ok := validateTOTP(secret, "000000", now, 0); ok && code != "000000" {
+		t.Errorf("wrong code accepted")
+	}
+}
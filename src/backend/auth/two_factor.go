@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+var errInvalidChallenge = errors.New("invalid two-factor challenge")
+
+// twoFactorAudience is the audience of challenge tokens, so they can never be
+// used as access tokens.
+func twoFactorAudience() string {
+	return Audience() + ":2fa"
+}
+
+// newTwoFactorChallenge signs the token returned by Login when the password
+// was right but a TOTP code is still needed.
+func newTwoFactorChallenge(userID uint) (string, error) {
+	now := time.Now()
+	return Keys.Sign(jwt.MapClaims{
+		"sub": fmt.Sprintf("%d", userID),
+		"aud": twoFactorAudience(),
+		"iat": now.Unix(),
+		"exp": now.Add(twoFactorChallengeTTL).Unix(),
+	})
+}
+
+// parseTwoFactorChallenge returns the user a challenge token was issued to.
+func parseTwoFactorChallenge(token string) (uint, error) {
+	claims, err := Keys.Parse(token, twoFactorAudience())
+	if err != nil {
+		return 0, errInvalidChallenge
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.ParseUint(sub, 10, 64)
+	if err != nil {
+		return 0, errInvalidChallenge
+	}
+	return uint(userID), nil
+}
+
+// normalizeRecoveryCode drops the separators users may or may not type.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new
+// ones in plaintext, formatted as "xxxxx-xxxxx".
+func newRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]model.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		records[i] = model.RecoveryCode{UserID: userID, CodeHash: hashToken(code)}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code, consuming it so it cannot be replayed.
+func checkSecondFactor(db *gorm.DB, user model.User, code string) (bool, error) {
+	if step, ok := validateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		result := db.Model(&model.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+	result := db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalized)).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// EnrollTwoFactor creates a new TOTP secret for the current user. 2FA is not
+// enforced until a code from it is confirmed with VerifyTwoFactor.
+func EnrollTwoFactor(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var user model.User
+	if err := model.DB.First(&user, userID).Error; err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeTwoFactorEnabled, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start enrollment")
+		return
+	}
+	if err := model.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to start enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": provisioningURI(secret, user.Username),
+	})
+}
+
+// VerifyTwoFactor confirms enrollment with a code from the authenticator app,
+// enables 2FA and returns the recovery codes.
+func VerifyTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+
+	var user model.User
+	if err := model.DB.First(&user, userID).Error; err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeTwoFactorEnabled, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeEnrollmentNotStarted, "Start enrollment first")
+		return
+	}
+
+	step, ok := validateTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidTwoFactorCode, "Invalid two-factor code")
+		return
+	}
+
+	if err := model.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_enabled_at": time.Now(),
+		"totp_last_step":  step,
+	}).Error; err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to enable two-factor authentication")
+		return
+	}
+	codes, err := newRecoveryCodes(model.DB, user.ID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	user, ok := twoFactorUser(c, req.Code)
+	if !ok {
+		return
+	}
+
+	codes, err := newRecoveryCodes(model.DB, user.ID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to create recovery codes")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns 2FA off after checking a TOTP or recovery code.
+func DisableTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+	user, ok := twoFactorUser(c, req.Code)
+	if !ok {
+		return
+	}
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error
+	})
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to disable two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// twoFactorUser loads the current user and checks that 2FA is enabled and
+// code is valid, writing an error response otherwise.
+func twoFactorUser(c *gin.Context, code string) (model.User, bool) {
+	var user model.User
+	if err := model.DB.First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return user, false
+	}
+	if user.TOTPEnabledAt == nil {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeTwoFactorNotEnabled, "Two-factor authentication is not enabled")
+		return user, false
+	}
+	ok, err := checkSecondFactor(model.DB, user, code)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check two-factor code")
+		return user, false
+	}
+	if !ok {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidTwoFactorCode, "Invalid two-factor code")
+		return user, false
+	}
+	return user, true
+}
+
+// LoginTwoFactor completes a login for an account with 2FA, exchanging the
+// challenge token from Login and a TOTP or recovery code for a TokenPair.
+func LoginTwoFactor(c *gin.Context) {
+	var req LoginTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+
+	userID, err := parseTwoFactorChallenge(req.ChallengeToken)
+	if err != nil {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidChallenge, "Invalid or expired challenge token")
+		return
+	}
+	var user model.User
+	if err := model.DB.First(&user, userID).Error; err != nil || user.TOTPEnabledAt == nil {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidChallenge, "Invalid or expired challenge token")
+		return
+	}
+
+	// Wrong codes count towards the same backoff and lockout as passwords
+	accountKey, ipKey := accountThrottleKey(user.Username), ipThrottleKey(c.ClientIP())
+	now := time.Now()
+	wait, err := loginWait(model.DB, now, accountKey, ipKey)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to log in")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeLoginThrottled, "Too many failed login attempts, try again later")
+		return
+	}
+
+	ok, err := checkSecondFactor(model.DB, user, req.Code)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to log in")
+		return
+	}
+	if !ok {
+		lockedUntil, err := recordLoginFailure(model.DB, now, accountKey, ipKey)
+		if err != nil {
+			log.Println("Failed to record login failure:", err)
+		}
+		if lockedUntil != nil {
+			OnAccountLocked(user, *lockedUntil)
+		}
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidTwoFactorCode, "Invalid two-factor code")
+		return
+	}
+	if err := clearLoginFailures(model.DB, accountKey); err != nil {
+		log.Println("Failed to clear login failures:", err)
+	}
+
+	token, err := GenerateTokens(user.ID, Client(c))
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to generate token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Login successful",
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		userIDField(c):  user.ID,
+	})
+}
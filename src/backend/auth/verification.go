@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apierror"
+	"backend/mailer"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+
+	// A user may ask for a new verification email once per
+	// verificationResendInterval and at most verificationDailyLimit times a day.
+	verificationResendInterval = time.Minute
+	verificationDailyLimit     = 5
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+var (
+	errInvalidEmail             = errors.New("invalid email address")
+	errInvalidVerificationToken = errors.New("invalid or expired verification token")
+)
+
+// normalizeEmail checks that s is a single bare address such as
+// "player@example.com" and returns it trimmed, with the domain lowercased.
+func normalizeEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return "", errInvalidEmail
+	}
+	at := strings.LastIndex(s, "@")
+	local, domain := s[:at], strings.ToLower(s[at+1:])
+	if local == "" || !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", errInvalidEmail
+	}
+	return local + "@" + domain, nil
+}
+
+// sendVerificationEmail issues a verification token for email, replacing any
+// unused one, and emails the confirmation link to that address.
+func sendVerificationEmail(db *gorm.DB, user model.User, email string) error {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.EmailVerificationToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.EmailVerificationToken{
+			UserID:    user.ID,
+			Email:     email,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(emailVerificationTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	link := AppURL() + "/verify-email?token=" + url.QueryEscape(token)
+	SendMail(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that this is your email address by opening this link "+
+			"within %d hours:\n\n%s\n\nIf you did not create an account, you can ignore this email.\n",
+			user.Username, int(emailVerificationTTL.Hours()), link),
+	})
+	return nil
+}
+
+// resendAllowedIn returns how long the user must wait before another
+// verification email may be sent, or zero if one may be sent now.
+func resendAllowedIn(db *gorm.DB, userID uint) (time.Duration, error) {
+	now := time.Now()
+
+	var sent []model.EmailVerificationToken
+	if err := db.Unscoped().
+		Where("user_id = ? AND created_at > ?", userID, now.Add(-24*time.Hour)).
+		Order("created_at DESC").
+		Find(&sent).Error; err != nil {
+		return 0, err
+	}
+	if len(sent) == 0 {
+		return 0, nil
+	}
+
+	if wait := sent[0].CreatedAt.Add(verificationResendInterval).Sub(now); wait > 0 {
+		return wait, nil
+	}
+	if len(sent) >= verificationDailyLimit {
+		oldest := sent[verificationDailyLimit-1]
+		return oldest.CreatedAt.Add(24 * time.Hour).Sub(now), nil
+	}
+	return 0, nil
+}
+
+// VerifyEmail confirms the address a verification token was sent to.
+func VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+		return
+	}
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		var verification model.EmailVerificationToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(req.Token)).
+			First(&verification).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+		if verification.UsedAt != nil || time.Now().After(verification.ExpiresAt) {
+			return errInvalidVerificationToken
+		}
+
+		// The address may have been taken by another account since the link
+		// was sent when verifying an email change
+		var taken int64
+		if err := tx.Model(&model.User{}).
+			Where("email = ? AND id <> ?", verification.Email, verification.UserID).
+			Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errEmailTaken
+		}
+
+		now := time.Now()
+		if err := tx.Model(&verification).Update("used_at", &now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.User{}).Where("id = ?", verification.UserID).
+			Updates(map[string]interface{}{
+				"email":             verification.Email,
+				"email_verified_at": now,
+			}).Error
+	})
+	if errors.Is(err, errInvalidVerificationToken) {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidVerification, "Invalid or expired verification token")
+		return
+	}
+	if errors.Is(err, errEmailTaken) {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeEmailTaken, "Email is already in use")
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to verify email")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification sends the current user a new verification email.
+func ResendVerification(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var user model.User
+	if err := model.DB.First(&user, userID).Error; err != nil {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+		return
+	}
+	if user.IsGuest {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeGuestNotAllowed, "Guest accounts have no email address to verify")
+		return
+	}
+	if user.EmailVerified() {
+		apierror.Abort(c, http.StatusConflict, apierror.CodeEmailAlreadyVerified, "Email is already verified")
+		return
+	}
+
+	wait, err := resendAllowedIn(model.DB, user.ID)
+	if err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to send verification email")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeVerificationThrottled, "Too many verification emails requested, try again later")
+		return
+	}
+
+	if err := sendVerificationEmail(model.DB, user, user.Email); err != nil {
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to send verification email")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	valid := map[string]string{
+		"player@example.com":      "player@example.com",
+		"  Player@Example.COM ":   "Player@example.com",
+		"first.last+tag@mail.org": "first.last+tag@mail.org",
+	}
+	for in, want := range valid {
+		got, err := normalizeEmail(in)
+		if err != nil || got != want {
+			t.Errorf("normalizeEmail(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"player",
+		"player@",
+		"@example.com",
+		"player@localhost",
+		"player@example.",
+		"Player <player@example.com>",
+		"a@b.com, c@d.com",
+	}
+	for _, in := range invalid {
+		if got, err := normalizeEmail(in); err == nil {
+			t.Errorf("normalizeEmail(%q) = %q; want an error", in, got)
+		}
+	}
+}
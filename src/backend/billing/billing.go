@@ -0,0 +1,301 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/apierror"
+	"backend/auth"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxWebhookBytes bounds the size of a webhook payload.
+const maxWebhookBytes = 1 << 20
+
+var errDuplicateEvent = errors.New("event already processed")
+
+// ErrBillingUnavailable is returned by CancelUserSubscriptions when a user
+// has a subscription that no configured provider can cancel.
+var ErrBillingUnavailable = errors.New("billing provider unavailable")
+
+// openStatuses are the statuses of subscriptions the provider may still
+// charge for.
+var openStatuses = []string{StatusActive, StatusTrialing, StatusPastDue, StatusUnpaid, StatusIncomplete}
+
+type CheckoutBody struct {
+	Plan string `json:"plan" binding:"required,oneof=monthly lifetime"`
+}
+
+type SubscriptionDTO struct {
+	Plan               string     `json:"plan"`
+	Status             string     `json:"status"`
+	CurrentPeriodStart *time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+}
+
+// entitledStatus reports whether a subscription in this status still grants
+// its plan. Past-due subscriptions keep it while the provider retries the
+// payment.
+func entitledStatus(status string) bool {
+	return status == StatusActive || status == StatusTrialing || status == StatusPastDue
+}
+
+// subscriptionLevel derives User.SubscriptionLevel from a user's
+// subscriptions.
+func subscriptionLevel(subs []model.Subscription) int {
+	level := model.SubscriptionFree
+	for _, s := range subs {
+		if !entitledStatus(s.Status) {
+			continue
+		}
+		switch s.Plan {
+		case PlanLifetime:
+			level = model.SubscriptionLifetime
+		case PlanMonthly:
+			if level < model.SubscriptionMonthly {
+				level = model.SubscriptionMonthly
+			}
+		}
+	}
+	return level
+}
+
+// eventUser finds the user an event is about, by the user ID attached at
+// checkout or else by customer ID.
+func eventUser(tx *gorm.DB, ev Event) (model.User, error) {
+	var user model.User
+	if ev.UserID != 0 {
+		err := tx.First(&user, ev.UserID).Error
+		return user, err
+	}
+	if ev.CustomerID == "" {
+		return user, gorm.ErrRecordNotFound
+	}
+	err := tx.Where("billing_customer_id = ?", ev.CustomerID).First(&user).Error
+	return user, err
+}
+
+// ProcessEvent applies a webhook event: it records the subscription's plan,
+// status and period and recomputes the user's subscription level. Each event
+// is applied once, however often it is delivered.
+func ProcessEvent(db *gorm.DB, provider string, ev Event) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		record := model.BillingEvent{Provider: provider, EventID: ev.ID, Type: ev.Type}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errDuplicateEvent
+		}
+		if ev.SubscriptionID == "" {
+			return nil
+		}
+
+		user, err := eventUser(tx, ev)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Billing event %s (%s) is for an unknown user, ignoring it", ev.ID, ev.Type)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if user.BillingCustomerID == "" && ev.CustomerID != "" {
+			if err := tx.Model(&user).Update("billing_customer_id", ev.CustomerID).Error; err != nil {
+				return err
+			}
+		}
+
+		var sub model.Subscription
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("provider = ? AND provider_id = ?", provider, ev.SubscriptionID).
+			First(&sub).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sub = model.Subscription{UserID: user.ID, Provider: provider, ProviderID: ev.SubscriptionID}
+		} else if err != nil {
+			return err
+		}
+
+		// An event older than the last one applied carries stale state, and a
+		// subscription that got going never goes back to incomplete
+		stale := ev.CreatedAt.Before(sub.LastEventAt) ||
+			(ev.Status == StatusIncomplete && sub.Status != "" && sub.Status != StatusIncomplete)
+		if !stale {
+			if ev.Plan != "" {
+				sub.Plan = ev.Plan
+			}
+			if ev.Status != "" {
+				sub.Status = ev.Status
+			}
+			if ev.CurrentPeriodStart != nil {
+				sub.CurrentPeriodStart = ev.CurrentPeriodStart
+			}
+			if ev.CurrentPeriodEnd != nil {
+				sub.CurrentPeriodEnd = ev.CurrentPeriodEnd
+			}
+			sub.CancelAtPeriodEnd = ev.CancelAtPeriodEnd
+			sub.LastEventAt = ev.CreatedAt
+			if err := tx.Save(&sub).Error; err != nil {
+				return err
+			}
+		}
+
+		var subs []model.Subscription
+		if err := tx.Where("user_id = ?", sub.UserID).Find(&subs).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.User{}).Where("id = ?", sub.UserID).
+			Update("subscription_level", subscriptionLevel(subs)).Error
+	})
+	if errors.Is(err, errDuplicateEvent) {
+		return nil
+	}
+	return err
+}
+
+// CancelUserSubscriptions cancels the user's recurring subscriptions with
+// the provider and marks them cancelled. One-time purchases have nothing to
+// cancel.
+func CancelUserSubscriptions(ctx context.Context, tx *gorm.DB, provider Provider, userID uint) error {
+	var subs []model.Subscription
+	if err := tx.Where("user_id = ? AND plan = ? AND status IN ?", userID, PlanMonthly, openStatuses).
+		Find(&subs).Error; err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if provider == nil || sub.Provider != provider.Name() {
+			return fmt.Errorf("cancelling subscription %s with %s: %w", sub.ProviderID, sub.Provider, ErrBillingUnavailable)
+		}
+		if err := provider.CancelSubscription(ctx, sub.ProviderID); err != nil {
+			return fmt.Errorf("cancelling subscription %s: %w", sub.ProviderID, err)
+		}
+		if err := tx.Model(&sub).Update("status", StatusCanceled).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checkout starts the purchase of a plan and returns the provider's checkout
+// page for the user to pay on.
+func Checkout(db *gorm.DB, provider Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if provider == nil {
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeBillingUnavailable, "Billing is not configured")
+			return
+		}
+		var req CheckoutBody
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+			return
+		}
+
+		var user model.User
+		if err := db.First(&user, c.MustGet("userID").(uint)).Error; err != nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+			return
+		}
+		if user.IsGuest {
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeGuestNotAllowed, "Upgrade the guest account before subscribing")
+			return
+		}
+		if user.SubscriptionLevel >= model.SubscriptionLifetime ||
+			(req.Plan == PlanMonthly && user.SubscriptionLevel >= model.SubscriptionMonthly) {
+			apierror.Abort(c, http.StatusConflict, apierror.CodePlanOwned, "You already have this plan or a better one")
+			return
+		}
+
+		session, err := provider.CreateCheckoutSession(c.Request.Context(), CheckoutRequest{
+			UserID:     user.ID,
+			Email:      user.Email,
+			CustomerID: user.BillingCustomerID,
+			Plan:       req.Plan,
+			SuccessURL: auth.AppURL() + "/subscription?status=success",
+			CancelURL:  auth.AppURL() + "/subscription?status=cancelled",
+		})
+		if err != nil {
+			log.Println("Failed to create checkout session:", err)
+			apierror.Abort(c, http.StatusBadGateway, apierror.CodeCheckoutFailed, "Failed to start checkout")
+			return
+		}
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// GetSubscription returns the current user's subscription level and
+// subscriptions.
+func GetSubscription(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+
+		var user model.User
+		if err := db.First(&user, userID).Error; err != nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
+			return
+		}
+		var subs []model.Subscription
+		if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&subs).Error; err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to load subscriptions")
+			return
+		}
+
+		dtos := make([]SubscriptionDTO, 0, len(subs))
+		for _, s := range subs {
+			dtos = append(dtos, SubscriptionDTO{
+				Plan:               s.Plan,
+				Status:             s.Status,
+				CurrentPeriodStart: s.CurrentPeriodStart,
+				CurrentPeriodEnd:   s.CurrentPeriodEnd,
+				CancelAtPeriodEnd:  s.CancelAtPeriodEnd,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"subscription_level": user.SubscriptionLevel,
+			"subscriptions":      dtos,
+		})
+	}
+}
+
+// Webhook receives the payment provider's events. Deliveries with a bad
+// signature are rejected; anything else is acknowledged once applied so the
+// provider stops retrying.
+func Webhook(db *gorm.DB, provider Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if provider == nil {
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeBillingUnavailable, "Billing is not configured")
+			return
+		}
+		payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBytes))
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to read payload")
+			return
+		}
+
+		ev, err := provider.ParseWebhook(payload, c.Request.Header)
+		if errors.Is(err, ErrInvalidSignature) {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidSignature, "Invalid signature")
+			return
+		}
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request", err.Error())
+			return
+		}
+
+		if err := ProcessEvent(db, provider.Name(), ev); err != nil {
+			log.Printf("Failed to process billing event %s: %v", ev.ID, err)
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to process event")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	}
+}
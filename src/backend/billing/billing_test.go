@@ -0,0 +1,186 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/model"
+)
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+
+	if err := verifySignature("secret", payload, Sign("secret", payload, now), now); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	cases := map[string]string{
+		"wrong secret":     Sign("other", payload, now),
+		"too old":          Sign("secret", payload, now.Add(-10*time.Minute)),
+		"missing v1":       "t=123",
+		"empty":            "",
+		"tampered payload": Sign("secret", []byte(`{"id":"evt_2"}`), now),
+	}
+	for name, header := range cases {
+		if err := verifySignature("secret", payload, header, now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestFakeProviderRoundTrip(t *testing.T) {
+	p := NewFakeProvider("secret")
+	session, err := p.CreateCheckoutSession(context.Background(), CheckoutRequest{
+		UserID: 7, Plan: PlanMonthly, SuccessURL: "http://app/subscription",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, header, err := p.CompleteCheckout(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := p.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.UserID != 7 || ev.Plan != PlanMonthly || ev.Status != StatusActive || ev.SubscriptionID != session.ID {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev.CurrentPeriodEnd == nil || !ev.CurrentPeriodEnd.After(time.Now()) {
+		t.Errorf("monthly plan should have a period end in the future, got %v", ev.CurrentPeriodEnd)
+	}
+
+	header.Set(FakeSignatureHeader, Sign("other", payload, time.Now()))
+	if _, err := p.ParseWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("forged event accepted: %v", err)
+	}
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	p := &StripeProvider{WebhookSecret: "whsec"}
+	payload := []byte(`{
+		"id": "evt_sub", "type": "customer.subscription.updated", "created": 1700000000,
+		"data": {"object": {
+			"id": "sub_1", "customer": "cus_1", "status": "past_due",
+			"current_period_start": 1700000000, "current_period_end": 1702592000,
+			"cancel_at_period_end": true,
+			"metadata": {"user_id": "42", "plan": "monthly"}
+		}}
+	}`)
+	header := http.Header{}
+	header.Set("Stripe-Signature", Sign("whsec", payload, time.Now()))
+
+	ev, err := p.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.UserID != 42 || ev.CustomerID != "cus_1" || ev.SubscriptionID != "sub_1" ||
+		ev.Status != StatusPastDue || ev.Plan != PlanMonthly || !ev.CancelAtPeriodEnd {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev.CurrentPeriodEnd == nil || ev.CurrentPeriodEnd.Unix() != 1702592000 {
+		t.Errorf("period end = %v", ev.CurrentPeriodEnd)
+	}
+
+	unpaid := []byte(`{"id": "evt_cs", "type": "checkout.session.completed", "created": 1700000000,
+		"data": {"object": {"id": "cs_1", "mode": "payment", "payment_status": "unpaid",
+			"client_reference_id": "42", "metadata": {"plan": "lifetime"}}}}`)
+	header.Set("Stripe-Signature", Sign("whsec", unpaid, time.Now()))
+	ev, err = p.ParseWebhook(unpaid, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.SubscriptionID != "" || ev.UserID != 42 {
+		t.Errorf("unpaid checkout should not grant a plan: %+v", ev)
+	}
+}
+
+func TestStripeCreateCheckoutSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"bad key"}}`))
+			return
+		}
+		r.ParseForm()
+		if r.Form.Get("mode") != "payment" || r.Form.Get("line_items[0][price]") != "price_life" ||
+			r.Form.Get("metadata[user_id]") != "3" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"unexpected form"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"cs_123","url":"https://checkout.example/cs_123"}`))
+	}))
+	defer server.Close()
+
+	p := &StripeProvider{
+		SecretKey: "sk_test",
+		Prices:    map[string]string{PlanMonthly: "price_month", PlanLifetime: "price_life"},
+		BaseURL:   server.URL,
+	}
+	session, err := p.CreateCheckoutSession(context.Background(), CheckoutRequest{UserID: 3, Plan: PlanLifetime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "cs_123" || session.URL != "https://checkout.example/cs_123" {
+		t.Errorf("unexpected session %+v", session)
+	}
+
+	p.SecretKey = "wrong"
+	if _, err := p.CreateCheckoutSession(context.Background(), CheckoutRequest{UserID: 3, Plan: PlanLifetime}); err == nil {
+		t.Error("expected an error for a rejected request")
+	}
+}
+
+func TestStripeCancelSubscription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "sk_test" || r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"bad request"}}`))
+			return
+		}
+		if r.URL.Path != "/v1/subscriptions/sub_123" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"No such subscription"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"sub_123","status":"canceled"}`))
+	}))
+	defer server.Close()
+
+	p := &StripeProvider{SecretKey: "sk_test", BaseURL: server.URL}
+	if err := p.CancelSubscription(context.Background(), "sub_123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CancelSubscription(context.Background(), "sub_456"); err == nil {
+		t.Error("expected an error for an unknown subscription")
+	}
+}
+
+func TestSubscriptionLevel(t *testing.T) {
+	cases := []struct {
+		subs []model.Subscription
+		want int
+	}{
+		{nil, model.SubscriptionFree},
+		{[]model.Subscription{{Plan: PlanMonthly, Status: StatusActive}}, model.SubscriptionMonthly},
+		{[]model.Subscription{{Plan: PlanMonthly, Status: StatusPastDue}}, model.SubscriptionMonthly},
+		{[]model.Subscription{{Plan: PlanMonthly, Status: StatusCanceled}}, model.SubscriptionFree},
+		{[]model.Subscription{
+			{Plan: PlanLifetime, Status: StatusActive},
+			{Plan: PlanMonthly, Status: StatusActive},
+		}, model.SubscriptionLifetime},
+	}
+	for i, tc := range cases {
+		if got := subscriptionLevel(tc.subs); got != tc.want {
+			t.Errorf("case %d: got %d, want %d", i, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// FakeSignatureHeader carries the signature of FakeProvider webhooks.
+const FakeSignatureHeader = "Billing-Signature"
+
+// FakeEvent is the webhook payload understood by FakeProvider.
+type FakeEvent struct {
+	ID                 string     `json:"id"`
+	Type               string     `json:"type"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UserID             uint       `json:"user_id"`
+	CustomerID         string     `json:"customer_id"`
+	SubscriptionID     string     `json:"subscription_id"`
+	Plan               string     `json:"plan"`
+	Status             string     `json:"status"`
+	CurrentPeriodStart *time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+}
+
+// FakeProvider is an in-memory provider for tests and offline development.
+// Checkouts never take payment; CompleteCheckout builds the signed webhook
+// a real provider would send once the user paid.
+type FakeProvider struct {
+	Secret string
+
+	mu        sync.Mutex
+	next      int
+	sessions  map[string]CheckoutRequest
+	cancelled []string
+}
+
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{Secret: secret, sessions: map[string]CheckoutRequest{}}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateCheckoutSession(ctx context.Context, req CheckoutRequest) (CheckoutSession, error) {
+	if !ValidPlan(req.Plan) {
+		return CheckoutSession{}, fmt.Errorf("unknown plan %q", req.Plan)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	id := fmt.Sprintf("cs_fake_%d", p.next)
+	p.sessions[id] = req
+	return CheckoutSession{ID: id, URL: req.SuccessURL + "?session_id=" + url.QueryEscape(id)}, nil
+}
+
+// CompleteCheckout returns the webhook payload and headers announcing that
+// the checkout session was paid.
+func (p *FakeProvider) CompleteCheckout(sessionID string) ([]byte, http.Header, error) {
+	p.mu.Lock()
+	req, ok := p.sessions[sessionID]
+	p.mu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown checkout session %q", sessionID)
+	}
+
+	now := time.Now()
+	ev := FakeEvent{
+		ID:             "evt_" + sessionID,
+		Type:           "checkout.completed",
+		CreatedAt:      now,
+		UserID:         req.UserID,
+		CustomerID:     req.CustomerID,
+		SubscriptionID: sessionID,
+		Plan:           req.Plan,
+		Status:         StatusActive,
+	}
+	if ev.CustomerID == "" {
+		ev.CustomerID = fmt.Sprintf("cus_fake_%d", req.UserID)
+	}
+	if req.Plan == PlanMonthly {
+		end := now.AddDate(0, 1, 0)
+		ev.CurrentPeriodStart, ev.CurrentPeriodEnd = &now, &end
+	}
+	return p.SignEvent(ev)
+}
+
+// SignEvent encodes and signs an event for delivery to the webhook.
+func (p *FakeProvider) SignEvent(ev FakeEvent) ([]byte, http.Header, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, Sign(p.Secret, payload, time.Now()))
+	return payload, header, nil
+}
+
+// CancelSubscription records the cancellation; see Cancelled.
+func (p *FakeProvider) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancelled = append(p.cancelled, subscriptionID)
+	return nil
+}
+
+// Cancelled returns the IDs of the subscriptions cancelled so far.
+func (p *FakeProvider) Cancelled() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.cancelled...)
+}
+
+func (p *FakeProvider) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	if err := verifySignature(p.Secret, payload, header.Get(FakeSignatureHeader), time.Now()); err != nil {
+		return Event{}, err
+	}
+	var ev FakeEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return Event{}, fmt.Errorf("decoding event: %w", err)
+	}
+	return Event{
+		ID:                 ev.ID,
+		Type:               ev.Type,
+		CreatedAt:          ev.CreatedAt,
+		UserID:             ev.UserID,
+		CustomerID:         ev.CustomerID,
+		SubscriptionID:     ev.SubscriptionID,
+		Plan:               ev.Plan,
+		Status:             ev.Status,
+		CurrentPeriodStart: ev.CurrentPeriodStart,
+		CurrentPeriodEnd:   ev.CurrentPeriodEnd,
+		CancelAtPeriodEnd:  ev.CancelAtPeriodEnd,
+	}, nil
+}
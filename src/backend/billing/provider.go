@@ -0,0 +1,127 @@
+// Package billing sells subscriptions through a payment provider and keeps
+// model.User.SubscriptionLevel in step with the provider's webhook events.
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Plans that can be bought.
+const (
+	PlanMonthly  = "monthly"
+	PlanLifetime = "lifetime"
+)
+
+// Subscription statuses, following the provider's vocabulary.
+const (
+	StatusActive     = "active"
+	StatusTrialing   = "trialing"
+	StatusPastDue    = "past_due"
+	StatusIncomplete = "incomplete"
+	StatusUnpaid     = "unpaid"
+	StatusCanceled   = "canceled"
+)
+
+// ErrInvalidSignature is returned by ParseWebhook when a payload is not
+// signed with the webhook secret.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// CheckoutRequest describes the purchase of a plan by a user.
+type CheckoutRequest struct {
+	UserID uint
+	Email  string
+	// CustomerID is the user's existing customer ID, if any
+	CustomerID string
+	Plan       string
+	SuccessURL string
+	CancelURL  string
+}
+
+// CheckoutSession is a hosted payment page the user is sent to.
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Event is a webhook event translated into the fields billing cares about.
+// Events that do not concern a subscription have an empty SubscriptionID.
+type Event struct {
+	ID        string
+	Type      string
+	CreatedAt time.Time
+
+	// UserID is taken from the metadata attached at checkout; it is zero when
+	// the event only names the customer.
+	UserID         uint
+	CustomerID     string
+	SubscriptionID string
+	Plan           string
+	Status         string
+
+	CurrentPeriodStart *time.Time
+	CurrentPeriodEnd   *time.Time
+	CancelAtPeriodEnd  bool
+}
+
+// Provider is a payment provider.
+type Provider interface {
+	// Name identifies the provider in stored subscriptions and events.
+	Name() string
+	CreateCheckoutSession(ctx context.Context, req CheckoutRequest) (CheckoutSession, error)
+	// ParseWebhook checks the signature of a webhook delivery and decodes it.
+	ParseWebhook(payload []byte, header http.Header) (Event, error)
+	// CancelSubscription ends a recurring subscription immediately, so the
+	// user is not charged again.
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+}
+
+// FromEnv builds the provider selected by BILLING_PROVIDER:
+//
+//	stripe  STRIPE_SECRET_KEY, STRIPE_WEBHOOK_SECRET, STRIPE_PRICE_MONTHLY,
+//	        STRIPE_PRICE_LIFETIME
+//	fake    hands out checkout sessions without taking payment; webhook
+//	        events are FakeEvent JSON signed with BILLING_WEBHOOK_SECRET
+//
+// Without BILLING_PROVIDER billing is disabled and FromEnv returns nil.
+func FromEnv() (Provider, error) {
+	switch kind := os.Getenv("BILLING_PROVIDER"); kind {
+	case "":
+		return nil, nil
+	case "stripe":
+		p := &StripeProvider{
+			SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+			WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+			Prices: map[string]string{
+				PlanMonthly:  os.Getenv("STRIPE_PRICE_MONTHLY"),
+				PlanLifetime: os.Getenv("STRIPE_PRICE_LIFETIME"),
+			},
+		}
+		if p.SecretKey == "" || p.WebhookSecret == "" {
+			return nil, fmt.Errorf("BILLING_PROVIDER=stripe requires STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+		}
+		for plan, price := range p.Prices {
+			if price == "" {
+				return nil, fmt.Errorf("BILLING_PROVIDER=stripe requires a price for the %s plan", plan)
+			}
+		}
+		return p, nil
+	case "fake":
+		secret := os.Getenv("BILLING_WEBHOOK_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("BILLING_PROVIDER=fake requires BILLING_WEBHOOK_SECRET")
+		}
+		return NewFakeProvider(secret), nil
+	default:
+		return nil, fmt.Errorf("unknown BILLING_PROVIDER %q", kind)
+	}
+}
+
+// ValidPlan reports whether plan can be bought.
+func ValidPlan(plan string) bool {
+	return plan == PlanMonthly || plan == PlanLifetime
+}
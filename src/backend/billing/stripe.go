@@ -0,0 +1,244 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeProvider sells plans through Stripe Checkout.
+type StripeProvider struct {
+	SecretKey     string
+	WebhookSecret string
+	// Prices maps each plan to its Stripe price ID
+	Prices map[string]string
+	// BaseURL overrides the Stripe API URL, for tests
+	BaseURL string
+	Client  *http.Client
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+func (p *StripeProvider) baseURL() string {
+	if p.BaseURL != "" {
+		return strings.TrimSuffix(p.BaseURL, "/")
+	}
+	return "https://api.stripe.com"
+}
+
+func (p *StripeProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 15 * time.Second}
+}
+
+// CreateCheckoutSession starts a Stripe Checkout session. The user ID and
+// plan are attached as metadata so webhook events can be matched to the user.
+func (p *StripeProvider) CreateCheckoutSession(ctx context.Context, req CheckoutRequest) (CheckoutSession, error) {
+	price, ok := p.Prices[req.Plan]
+	if !ok || price == "" {
+		return CheckoutSession{}, fmt.Errorf("no price configured for plan %q", req.Plan)
+	}
+	userID := strconv.FormatUint(uint64(req.UserID), 10)
+
+	form := url.Values{}
+	form.Set("line_items[0][price]", price)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", req.SuccessURL)
+	form.Set("cancel_url", req.CancelURL)
+	form.Set("client_reference_id", userID)
+	form.Set("metadata[user_id]", userID)
+	form.Set("metadata[plan]", req.Plan)
+	if req.CustomerID != "" {
+		form.Set("customer", req.CustomerID)
+	} else {
+		form.Set("customer_email", req.Email)
+	}
+	if req.Plan == PlanLifetime {
+		form.Set("mode", "payment")
+		if req.CustomerID == "" {
+			form.Set("customer_creation", "always")
+		}
+	} else {
+		form.Set("mode", "subscription")
+		form.Set("subscription_data[metadata][user_id]", userID)
+		form.Set("subscription_data[metadata][plan]", req.Plan)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL()+"/v1/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return CheckoutSession{}, err
+	}
+	httpReq.SetBasicAuth(p.SecretKey, "")
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client().Do(httpReq)
+	if err != nil {
+		return CheckoutSession{}, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		ID    string `json:"id"`
+		URL   string `json:"url"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return CheckoutSession{}, fmt.Errorf("decoding checkout session: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return CheckoutSession{}, fmt.Errorf("stripe returned %s: %s", resp.Status, body.Error.Message)
+	}
+	return CheckoutSession{ID: body.ID, URL: body.URL}, nil
+}
+
+// CancelSubscription cancels a Stripe subscription right away rather than at
+// the end of the paid period.
+func (p *StripeProvider) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, p.baseURL()+"/v1/subscriptions/"+url.PathEscape(subscriptionID), nil)
+	if err != nil {
+		return err
+	}
+	httpReq.SetBasicAuth(p.SecretKey, "")
+
+	resp, err := p.client().Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("decoding subscription: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stripe returned %s: %s", resp.Status, body.Error.Message)
+	}
+	return nil
+}
+
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeCheckoutSession struct {
+	ID                string            `json:"id"`
+	Mode              string            `json:"mode"`
+	PaymentStatus     string            `json:"payment_status"`
+	Customer          string            `json:"customer"`
+	Subscription      string            `json:"subscription"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type stripeSubscription struct {
+	ID                 string            `json:"id"`
+	Customer           string            `json:"customer"`
+	Status             string            `json:"status"`
+	CurrentPeriodStart int64             `json:"current_period_start"`
+	CurrentPeriodEnd   int64             `json:"current_period_end"`
+	CancelAtPeriodEnd  bool              `json:"cancel_at_period_end"`
+	Metadata           map[string]string `json:"metadata"`
+	// Newer API versions report the period on the subscription items
+	Items struct {
+		Data []struct {
+			CurrentPeriodStart int64 `json:"current_period_start"`
+			CurrentPeriodEnd   int64 `json:"current_period_end"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// ParseWebhook verifies the Stripe-Signature header and translates checkout
+// and subscription events. Other event types are returned without
+// subscription details.
+func (p *StripeProvider) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	if err := verifySignature(p.WebhookSecret, payload, header.Get("Stripe-Signature"), time.Now()); err != nil {
+		return Event{}, err
+	}
+
+	var raw stripeEvent
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return Event{}, fmt.Errorf("decoding event: %w", err)
+	}
+	ev := Event{ID: raw.ID, Type: raw.Type, CreatedAt: time.Unix(raw.Created, 0)}
+
+	switch raw.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(raw.Data.Object, &session); err != nil {
+			return Event{}, fmt.Errorf("decoding checkout session: %w", err)
+		}
+		ev.CustomerID = session.Customer
+		ev.UserID = metadataUserID(session.Metadata, session.ClientReferenceID)
+		ev.Plan = session.Metadata["plan"]
+		// Asynchronous payment methods complete the session before the money
+		// arrives; the purchase counts once the payment succeeds.
+		if session.PaymentStatus != "paid" && session.PaymentStatus != "no_payment_required" {
+			return ev, nil
+		}
+		ev.Status = StatusActive
+		if session.Mode == "payment" {
+			ev.SubscriptionID = session.ID
+		} else {
+			ev.SubscriptionID = session.Subscription
+		}
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var sub stripeSubscription
+		if err := json.Unmarshal(raw.Data.Object, &sub); err != nil {
+			return Event{}, fmt.Errorf("decoding subscription: %w", err)
+		}
+		ev.CustomerID = sub.Customer
+		ev.UserID = metadataUserID(sub.Metadata, "")
+		ev.Plan = sub.Metadata["plan"]
+		ev.SubscriptionID = sub.ID
+		ev.Status = sub.Status
+		ev.CancelAtPeriodEnd = sub.CancelAtPeriodEnd
+		start, end := sub.CurrentPeriodStart, sub.CurrentPeriodEnd
+		if start == 0 && len(sub.Items.Data) > 0 {
+			start, end = sub.Items.Data[0].CurrentPeriodStart, sub.Items.Data[0].CurrentPeriodEnd
+		}
+		ev.CurrentPeriodStart = unixTime(start)
+		ev.CurrentPeriodEnd = unixTime(end)
+	}
+	return ev, nil
+}
+
+func metadataUserID(metadata map[string]string, fallback string) uint {
+	value := metadata["user_id"]
+	if value == "" {
+		value = fallback
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
+
+func unixTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0)
+	return &t
+}
@@ -0,0 +1,215 @@
+// Package dbtest provides a fake database for testing code that uses gorm
+// without a PostgreSQL server.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Responder returns the columns and rows answering a query; no rows when
+// nothing matches.
+type Responder func(query string, args []driver.Value) (columns []string, rows [][]driver.Value)
+
+// Statement is a statement that was executed rather than queried.
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Executor runs a statement that is not a query and returns how many rows
+// it affected.
+type Executor func(query string, args []driver.Value) int64
+
+// DB records the statements executed against it. Queries are answered by
+// its Responder; every other statement affects one row unless OnExec says
+// otherwise.
+type DB struct {
+	respond Responder
+
+	mu       sync.Mutex
+	exec     []Statement
+	executor Executor
+	failures map[string]error
+}
+
+// Open returns a gorm connection to a fake database answering queries with
+// respond.
+func Open(t testing.TB, respond Responder) (*gorm.DB, *DB) {
+	t.Helper()
+	fake := &DB{respond: respond}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fake)}),
+		&gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, fake
+}
+
+// Executed returns the executed statements containing substr.
+func (d *DB) Executed(substr string) []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var found []Statement
+	for _, s := range d.exec {
+		if strings.Contains(s.Query, substr) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// OnExec makes exec run the statements that are not queries.
+func (d *DB) OnExec(exec Executor) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.executor = exec
+}
+
+// Fail makes every statement containing substr fail with err.
+func (d *DB) Fail(substr string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failures == nil {
+		d.failures = map[string]error{}
+	}
+	d.failures[substr] = err
+}
+
+func (d *DB) failure(query string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for substr, err := range d.failures {
+		if strings.Contains(query, substr) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inserted maps the columns of a single-row INSERT statement to the values
+// it inserts.
+func Inserted(query string, args []driver.Value) map[string]driver.Value {
+	open := strings.Index(query, "(")
+	values := strings.Index(query, ") VALUES (")
+	if open < 0 || values < open {
+		return nil
+	}
+	columns := strings.Split(query[open+1:values], ",")
+	rest := query[values+len(") VALUES ("):]
+	placeholders := strings.Split(rest[:strings.Index(rest, ")")], ",")
+
+	row := make(map[string]driver.Value, len(columns))
+	for i, column := range columns {
+		if i >= len(placeholders) {
+			break
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(placeholders[i]), "$"))
+		if err != nil || n < 1 || n > len(args) {
+			continue
+		}
+		row[strings.Trim(strings.TrimSpace(column), `"`)] = args[n-1]
+	}
+	return row
+}
+
+// Assigned maps the columns set by an UPDATE statement to their values.
+func Assigned(query string, args []driver.Value) map[string]driver.Value {
+	set := strings.Index(query, " SET ")
+	if set < 0 {
+		return nil
+	}
+	rest := query[set+len(" SET "):]
+	if where := strings.Index(rest, " WHERE "); where >= 0 {
+		rest = rest[:where]
+	}
+
+	row := map[string]driver.Value{}
+	for _, assignment := range strings.Split(rest, ",") {
+		column, placeholder, ok := strings.Cut(assignment, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(placeholder), "$"))
+		if err != nil || n < 1 || n > len(args) {
+			continue
+		}
+		row[strings.Trim(strings.TrimSpace(column), `"`)] = args[n-1]
+	}
+	return row
+}
+
+func (d *DB) Connect(context.Context) (driver.Conn, error) { return conn{d}, nil }
+func (d *DB) Driver() driver.Driver                        { return d }
+func (d *DB) Open(string) (driver.Conn, error)             { return conn{d}, nil }
+
+type conn struct{ d *DB }
+
+func (conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("dbtest: prepared statements are not supported")
+}
+func (conn) Close() error              { return nil }
+func (conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+func (c conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.d.failure(query); err != nil {
+		return nil, err
+	}
+	columns, data := c.d.respond(query, values(args))
+	return &rows{columns: columns, rows: data}, nil
+}
+
+func (c conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.d.failure(query); err != nil {
+		return nil, err
+	}
+	c.d.mu.Lock()
+	c.d.exec = append(c.d.exec, Statement{Query: query, Args: values(args)})
+	exec := c.d.executor
+	c.d.mu.Unlock()
+	if exec == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return driver.RowsAffected(exec(query, values(args))), nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, arg := range args {
+		v[i] = arg.Value
+	}
+	return v
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
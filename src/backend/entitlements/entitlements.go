@@ -0,0 +1,337 @@
+// Package entitlements maps subscription tiers to what a user may do and
+// enforces those limits in handlers.
+package entitlements
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+	"backend/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Names of the entitlements, used in responses that deny one.
+const (
+	SaveSlots          = "save_slots"
+	HardDifficulty     = "hard_difficulty"
+	PremiumThemes      = "premium_themes"
+	DailyGenerations   = "daily_generations"
+	PriorityGeneration = "priority_generation"
+	AsyncGeneration    = "async_generation"
+)
+
+// unverifiedSaveSlots is how many games a user may keep before confirming
+// their email address, whatever their tier.
+const unverifiedSaveSlots = 1
+
+// premiumThemes are the floor themes only subscribers may generate. Only
+// themes the frontend ships a tileset for belong here.
+var premiumThemes = map[string]bool{
+	"desert": true,
+}
+
+// Entitlements are the limits that apply to a user.
+type Entitlements struct {
+	Tier             string `json:"tier"`
+	SaveSlots        int    `json:"save_slots"`
+	HardDifficulty   bool   `json:"hard_difficulty"`
+	PremiumThemes    bool   `json:"premium_themes"`
+	DailyGenerations int    `json:"daily_generations"`
+	// PriorityGeneration lets the user's AI generations skip the queue
+	PriorityGeneration bool `json:"priority_generation"`
+	// AsyncGeneration lets the user ask for a generation job to poll
+	// instead of waiting for the floor
+	AsyncGeneration bool `json:"async_generation"`
+}
+
+// Usage is how much of their limits a user has used.
+type Usage struct {
+	SaveSlots        int64 `json:"save_slots"`
+	DailyGenerations int   `json:"daily_generations"`
+}
+
+// Response is the body GetEntitlements answers with.
+type Response struct {
+	Entitlements Entitlements `json:"entitlements"`
+	Usage        Usage        `json:"usage"`
+}
+
+var tiers = map[int]Entitlements{
+	model.SubscriptionFree: {
+		Tier:             "free",
+		SaveSlots:        3,
+		DailyGenerations: 10,
+	},
+	model.SubscriptionMonthly: {
+		Tier:               "monthly",
+		SaveSlots:          10,
+		HardDifficulty:     true,
+		PremiumThemes:      true,
+		DailyGenerations:   100,
+		PriorityGeneration: true,
+		AsyncGeneration:    true,
+	},
+	model.SubscriptionLifetime: {
+		Tier:               "lifetime",
+		SaveSlots:          25,
+		HardDifficulty:     true,
+		PremiumThemes:      true,
+		DailyGenerations:   100,
+		PriorityGeneration: true,
+		AsyncGeneration:    true,
+	},
+}
+
+// quotaEnv names the variable overriding the daily generation quota of each
+// tier.
+var quotaEnv = map[int]string{
+	model.SubscriptionFree:     "AI_DAILY_QUOTA_FREE",
+	model.SubscriptionMonthly:  "AI_DAILY_QUOTA_MONTHLY",
+	model.SubscriptionLifetime: "AI_DAILY_QUOTA_LIFETIME",
+}
+
+// ConfigureFromEnv applies the daily generation quotas set in
+// AI_DAILY_QUOTA_FREE, AI_DAILY_QUOTA_MONTHLY and AI_DAILY_QUOTA_LIFETIME.
+func ConfigureFromEnv() error {
+	for level, name := range quotaEnv {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		quota, err := strconv.Atoi(value)
+		if err != nil || quota < 0 {
+			return fmt.Errorf("%s must be a non-negative number, got %q", name, value)
+		}
+		e := tiers[level]
+		e.DailyGenerations = quota
+		tiers[level] = e
+	}
+	return nil
+}
+
+// ForLevel returns the entitlements of a subscription level. Unknown levels
+// get the free tier.
+func ForLevel(level int) Entitlements {
+	if e, ok := tiers[level]; ok {
+		return e
+	}
+	return tiers[model.SubscriptionFree]
+}
+
+// ForUser returns the entitlements of a user, taking into account that
+// unverified accounts get a single save slot.
+func ForUser(user model.User) Entitlements {
+	e := ForLevel(user.SubscriptionLevel)
+	if !user.EmailVerified() && e.SaveSlots > unverifiedSaveSlots {
+		e.SaveSlots = unverifiedSaveSlots
+	}
+	return e
+}
+
+// IsPremiumTheme reports whether generating floors with theme requires a
+// subscription.
+func IsPremiumTheme(theme string) bool {
+	return premiumThemes[theme]
+}
+
+// deny writes the response for a missing entitlement. 402 means a
+// subscription would lift the limit; 403 means something else must change.
+// Running out of daily generations is a 429 instead, see UseGeneration.
+func deny(c *gin.Context, status int, entitlement, tier, message string) {
+	code := apierror.CodeEntitlementMissing
+	if status == http.StatusForbidden {
+		code = apierror.CodeEmailNotVerified
+	}
+	apierror.AbortWithDetails(c, status, code, message, gin.H{
+		"entitlement": entitlement,
+		"tier":        tier,
+	})
+}
+
+// load fetches the user and their entitlements, writing an error response if
+// the user cannot be loaded.
+func load(c *gin.Context, db *gorm.DB, userID uint) (model.User, Entitlements, bool) {
+	var user model.User
+	if err := db.Select("id", "email_verified_at", "subscription_level").First(&user, userID).Error; err != nil {
+		apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUserNotFound, "User not found")
+		return user, Entitlements{}, false
+	}
+	return user, ForUser(user), true
+}
+
+// CheckSaveSlot reports whether the user may start another game, writing an
+// error response when they may not.
+func CheckSaveSlot(c *gin.Context, db *gorm.DB, userID uint) bool {
+	user, e, ok := load(c, db, userID)
+	if !ok {
+		return false
+	}
+
+	var games int64
+	if err := db.Model(&model.Game{}).Where("user_id = ?", userID).Count(&games).Error; err != nil {
+		apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+		return false
+	}
+	if games < int64(e.SaveSlots) {
+		return true
+	}
+	if !user.EmailVerified() {
+		deny(c, http.StatusForbidden, SaveSlots, e.Tier,
+			"Verify your email address to use more than one save slot")
+		return false
+	}
+	deny(c, http.StatusPaymentRequired, SaveSlots, e.Tier,
+		fmt.Sprintf("Your plan allows %d save slots; delete a game or upgrade", e.SaveSlots))
+	return false
+}
+
+// CheckGameOptions reports whether the user may play with the difficulty and
+// theme, writing an error response when they may not.
+func CheckGameOptions(c *gin.Context, db *gorm.DB, userID uint, difficulty, theme string) bool {
+	_, e, ok := load(c, db, userID)
+	if !ok {
+		return false
+	}
+	if difficulty == "hard" && !e.HardDifficulty {
+		deny(c, http.StatusPaymentRequired, HardDifficulty, e.Tier, "Hard difficulty requires a subscription")
+		return false
+	}
+	if IsPremiumTheme(theme) && !e.PremiumThemes {
+		deny(c, http.StatusPaymentRequired, PremiumThemes, e.Tier,
+			fmt.Sprintf("The %s theme requires a subscription", theme))
+		return false
+	}
+	return true
+}
+
+// CheckAsyncGeneration reports whether the user may run generations in the
+// background, writing an error response when they may not.
+func CheckAsyncGeneration(c *gin.Context, db *gorm.DB, userID uint) bool {
+	_, e, ok := load(c, db, userID)
+	if !ok {
+		return false
+	}
+	if !e.AsyncGeneration {
+		deny(c, http.StatusPaymentRequired, AsyncGeneration, e.Tier, "Background generation requires a subscription")
+		return false
+	}
+	return true
+}
+
+// today is the key of the current UTC day in model.GenerationUsage.
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+var errQuotaExhausted = errors.New("daily generation quota exhausted")
+
+// consumeGeneration counts one AI generation against the user's daily limit,
+// failing with errQuotaExhausted when the limit is reached.
+func consumeGeneration(db *gorm.DB, userID uint, limit int) error {
+	if limit <= 0 {
+		return errQuotaExhausted
+	}
+	now := time.Now()
+	result := db.Exec(`INSERT INTO generation_usages (user_id, day, count, created_at, updated_at)
+		VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT (user_id, day) DO UPDATE
+		SET count = generation_usages.count + 1, updated_at = EXCLUDED.updated_at
+		WHERE generation_usages.count < ?`,
+		userID, today(), now, now, limit)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errQuotaExhausted
+	}
+	return nil
+}
+
+// UseGeneration counts one AI generation against the user's daily quota and
+// reports it in the RateLimit headers. When the quota is used up it rejects
+// the request with 429 until the next UTC day. It returns whether the user
+// gets priority generation.
+func UseGeneration(c *gin.Context, db *gorm.DB, userID uint) (priority bool, ok bool) {
+	_, e, ok := load(c, db, userID)
+	if !ok {
+		return false, false
+	}
+
+	now := time.Now().UTC()
+	reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+	err := consumeGeneration(db, userID, e.DailyGenerations)
+	if errors.Is(err, errQuotaExhausted) {
+		ratelimit.SetHeaders(c, ratelimit.Result{Limit: e.DailyGenerations, Reset: reset})
+		ratelimit.TooManyRequests(c, reset, apierror.CodeQuotaExceeded,
+			fmt.Sprintf("Your plan allows %d AI generations a day", e.DailyGenerations),
+			gin.H{"entitlement": DailyGenerations, "tier": e.Tier})
+		return false, false
+	}
+	if err != nil {
+		apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+		return false, false
+	}
+
+	used, err := GenerationsUsed(db, userID)
+	if err == nil {
+		ratelimit.SetHeaders(c, ratelimit.Result{
+			Limit:     e.DailyGenerations,
+			Remaining: max(e.DailyGenerations-used, 0),
+			Reset:     reset,
+		})
+	}
+	return e.PriorityGeneration, true
+}
+
+// RefundGeneration gives back a generation that failed, so errors on our
+// side do not use up the user's quota.
+func RefundGeneration(db *gorm.DB, userID uint) error {
+	return db.Model(&model.GenerationUsage{}).
+		Where("user_id = ? AND day = ? AND count > 0", userID, today()).
+		Update("count", gorm.Expr("count - 1")).Error
+}
+
+// GenerationsUsed returns how many AI generations the user made today.
+func GenerationsUsed(db *gorm.DB, userID uint) (int, error) {
+	var usage model.GenerationUsage
+	err := db.Where("user_id = ? AND day = ?", userID, today()).First(&usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return usage.Count, err
+}
+
+// GetEntitlements returns the current user's entitlements and today's usage.
+func GetEntitlements(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("userID").(uint)
+		_, e, ok := load(c, db, userID)
+		if !ok {
+			return
+		}
+
+		var games int64
+		if err := db.Model(&model.Game{}).Where("user_id = ?", userID).Count(&games).Error; err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+		generations, err := GenerationsUsed(db, userID)
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Entitlements: e,
+			Usage:        Usage{SaveSlots: games, DailyGenerations: generations},
+		})
+	}
+}
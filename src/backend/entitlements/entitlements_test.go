@@ -0,0 +1,43 @@
+package entitlements
+
+import (
+	"testing"
+	"time"
+
+	"backend/model"
+)
+
+func TestForUser(t *testing.T) {
+	verified := time.Now()
+
+	free := ForUser(model.User{SubscriptionLevel: model.SubscriptionFree, EmailVerifiedAt: &verified})
+	if free.Tier != "free" || free.HardDifficulty || free.PremiumThemes || free.PriorityGeneration || free.AsyncGeneration {
+		t.Errorf("unexpected free entitlements %+v", free)
+	}
+
+	monthly := ForUser(model.User{SubscriptionLevel: model.SubscriptionMonthly, EmailVerifiedAt: &verified})
+	if !monthly.HardDifficulty || !monthly.PremiumThemes || !monthly.AsyncGeneration || monthly.SaveSlots <= free.SaveSlots ||
+		monthly.DailyGenerations <= free.DailyGenerations {
+		t.Errorf("monthly should unlock more than free: %+v", monthly)
+	}
+
+	unverified := ForUser(model.User{SubscriptionLevel: model.SubscriptionLifetime})
+	if unverified.SaveSlots != unverifiedSaveSlots || !unverified.HardDifficulty {
+		t.Errorf("unverified lifetime user: %+v", unverified)
+	}
+
+	if unknown := ForLevel(99); unknown.Tier != "free" {
+		t.Errorf("unknown level should fall back to free, got %q", unknown.Tier)
+	}
+}
+
+func TestIsPremiumTheme(t *testing.T) {
+	for _, theme := range []string{"castle", "jungle"} {
+		if IsPremiumTheme(theme) {
+			t.Errorf("%s should be free", theme)
+		}
+	}
+	if !IsPremiumTheme("desert") {
+		t.Error("desert should be premium")
+	}
+}
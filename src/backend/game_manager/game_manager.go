@@ -1,8 +1,12 @@
 package game_manager
 
 import (
+	"backend/apierror"
+	"backend/auth"
+	"backend/entitlements"
 	"backend/model"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -27,8 +31,13 @@ type UserDTO struct {
 
 type saveGameRequest struct {
 	Game model.Game `json:"game" binding:"required"`
+	// Version is the game version the client last saw. It can be sent here
+	// or as an If-Match header; it is ignored when creating a new game.
+	Version *uint `json:"version"`
 }
 
+var errNotOwner = errors.New("you do not own this game")
+
 
 type Floors struct {
 	Rooms           map[string][][]string `json:"rooms"`
@@ -65,30 +74,26 @@ type RoomNeighbors struct {
 }
 
 type GameConfig struct {
-	Theme     string `json:"theme" binding:"required"`
-	Difficulty string `json:"difficulty" binding:"required"`
+	Theme     string `json:"theme"`
+	Difficulty string `json:"difficulty"`
 }
 
 type FloorConfig struct {
-	Theme     string `json:"theme" binding:"required"`
-	Difficulty string `json:"difficulty" binding:"required"`
-	Level int `json:"level" binding:"required"`
-	LastStory string `json:"lastStory" binding:"required"`
-	LastTheme string `json:"lastTheme" binding:"required"`
-}
-
-const (
-	cols   = 13
-	rows   = 9
-	midX   = cols/2  // 6
-	midY   = rows/2  // 4
-)
+	Theme     string `json:"theme"`
+	Difficulty string `json:"difficulty"`
+	Level int `json:"level"`
+	// LastStory and LastTheme describe the previous floor, and may be left
+	// out for the first one.
+	LastStory string `json:"lastStory"`
+	LastTheme string `json:"lastTheme"`
+}
 
-var forbidden = map[[2]int]struct{}{
-	{midX, 0}:   {},
-	{midX, rows-1}: {},
-	{0, midY}:   {},
-	{cols-1, midY}: {},
+// isEntrance reports whether (x, y) is one of the door tiles in the middle
+// of each wall, which must be kept clear.
+func isEntrance(tiles model.TileGrid, x, y int) bool {
+	midX, midY := tiles.Width()/2, tiles.Height()/2
+	return (x == midX && (y == 0 || y == tiles.Height()-1)) ||
+		(y == midY && (x == 0 || x == tiles.Width()-1))
 }
 
 func getRoomNeighbors(floorMap [][]int) map[int]RoomNeighbors {
@@ -120,21 +125,6 @@ func getRoomNeighbors(floorMap [][]int) map[int]RoomNeighbors {
 	return neighbors
 }
 
-func convertTilesToString(tiles [][]string) string {
-	result := ""
-	for _, row := range tiles {
-		for _, col := range row {
-			result += col
-		}
-	}
-	return result
-}
-
-func toJSONString(v interface{}) string {
-	data, _ := json.Marshal(v)
-	return string(data)
-}
-
 func loadAPIKey() string {
 	return os.Getenv("API_KEY")
 }
@@ -165,36 +155,37 @@ func parseAIResponse(output []byte) (FloorData, error) {
 	return floorData, err
 }
 
-func pickLocation(roomTiles []rune) (int, int) {
+func pickLocation(rng *rand.Rand, roomTiles model.TileGrid) (int, int) {
     for {
-        // only pick inside the walls (1..cols-2, 1..rows-2)
-        x := rand.Intn(cols-2) + 1
-        y := rand.Intn(rows-2) + 1
+        // only pick inside the walls
+        x := rng.Intn(roomTiles.Width()-2) + 1
+        y := rng.Intn(roomTiles.Height()-2) + 1
 
         // skip entrances
-        if _, bad := forbidden[[2]int{x, y}]; bad {
+        if isEntrance(roomTiles, x, y) {
             continue
         }
 
-        // compute linear index
-        idx := y*cols + x
-        if roomTiles[idx] == '.' {
+        if roomTiles.Walkable(x, y) {
             return x, y
         }
     }
 }
 
-func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, theme string, c *gin.Context) (model.Floor, error) {
+// buildAndSaveFloor turns the AI output into a floor. Every random choice is
+// drawn from seed, which is stored on the floor and carried in save files.
+func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, theme string, seed int64) (model.Floor, error) {
+	rng := rand.New(rand.NewSource(seed))
 	floor := model.Floor{
-		FloorMap:  toJSONString(floorData.Floors.FloorMap),
-		Adjacency: toJSONString(floorData.Floors.AdjacencyMatrix),
+		Seed:      seed,
+		FloorMap:  model.FloorGrid(floorData.Floors.FloorMap),
+		Adjacency: model.AdjacencyMatrix(floorData.Floors.AdjacencyMatrix),
 		Rooms:     []model.Room{},
 		StoryText: floorData.Story,
 		Theme: theme,
 	}
 
 	if err := model.DB.Create(&floor).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return floor, err
 	}
 
@@ -212,10 +203,10 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 			stairRoom := 2
 
 			roomName := fmt.Sprintf("room%d", roomIndex+1)
-			roomTiles := convertTilesToString(floorData.Floors.Rooms[roomName])
+			roomTiles := model.NewTileGrid(floorData.Floors.Rooms[roomName])
 			roomIndex++
 
-			weaponData := floorData.Weapons[rand.Intn(len(floorData.Weapons))]
+			weaponData := floorData.Weapons[rng.Intn(len(floorData.Weapons))]
 			weaponDamage := math.Ceil(float64(weaponData.Attack * (float32(1) + level * multiplier) * (float32(1) + level * multiplier) * difficulty))
 
 			weapon := model.Weapon{
@@ -224,7 +215,6 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 				Type:         weaponData.Type,
 			}
 			if err := model.DB.Create(&weapon).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return floor, err
 			}
 
@@ -238,13 +228,11 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 
 
 			if err := model.DB.Create(&room).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return floor, err
 			}
 
-			if rand.Intn(4) == 1 {
-				tiles := []rune(roomTiles) // len == cols*rows
-				sx, sy := pickLocation(tiles)
+			if rng.Intn(4) == 1 {
+				sx, sy := pickLocation(rng, roomTiles)
 
 
 				chest := model.Chest{
@@ -254,7 +242,6 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 					PosY: sy,
 				}
 				if err := model.DB.Create(&chest).Error; err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return floor, err
 				}
 				room.Chest = &chest
@@ -265,8 +252,7 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 			if roomIndex == 6 {
 				room.Type = &stairRoom
 				
-				tiles := []rune(roomTiles) // len == cols*rows
-				sx, sy := pickLocation(tiles)
+				sx, sy := pickLocation(rng, roomTiles)
 				room.StairX = &sx
 				room.StairY = &sy
 					
@@ -283,29 +269,27 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 			}
 
 
-			enemyCount := rand.Intn(4)
+			enemyCount := rng.Intn(4)
 			for i := 0; i < enemyCount; i++ {
-				enemy_num := rand.Intn(len(Enemies))
+				enemy_num := rng.Intn(len(Enemies))
 				enemyData := Enemies[enemy_num]
 				enemy := model.Enemy{
 					Damage: enemyData.Attack * (float32(1) + level * multiplier) * difficulty,
 					Level: enemy_num + 1,
 					MaxHealth:      enemyData.Health * (float32(1) + level * multiplier) * (float32(1) + level * multiplier) * difficulty,
 					CurrentHealth: enemyData.Health * (float32(1) + level * multiplier) * (float32(1) + level * multiplier) * difficulty,
-					PosX: rand.Intn(11) + 1,
-					PosY: rand.Intn(7) + 1,
+					PosX: rng.Intn(roomTiles.Width()-2) + 1,
+					PosY: rng.Intn(roomTiles.Height()-2) + 1,
 					RoomID:      room.ID,
 					Sprite: theme,
 				}
 				if err := model.DB.Create(&enemy).Error; err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return floor, err
 				}
 				room.Enemies = append(room.Enemies, enemy)
 			}
 
 			if err := model.DB.Save(&room).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return floor, err
 			}
 			floor.Rooms = append(floor.Rooms, room)
@@ -332,7 +316,6 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 			room.RightID = &floor.Rooms[right].ID
 		}
 		if err := model.DB.Save(&room).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update neighbors"})
 			return floor, err
 		}
 	}
@@ -346,104 +329,90 @@ func buildAndSaveFloor(floorData FloorData, level float32, difficulty float32, t
 
 func CreateFloor(c *gin.Context) {
 	var config FloorConfig
-
-	if err := c.ShouldBindJSON(&config); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &config) {
 		return
 	}
 
-	apiKey := loadAPIKey()
-	args1 := []string{"castle", "cave", "forest"}
-	enemies := []string{"goblin", "bat", "knight"}
-	weapons := []string{"sword", "spear", "bow"}
-
-	output, err := runPythonAI(apiKey, args1, enemies, weapons, config.Theme, config.LastTheme, config.LastTheme)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI agent failed", "details": err.Error()})
+	userID := c.MustGet("userID").(uint)
+	if !entitlements.CheckGameOptions(c, model.DB, userID, config.Difficulty, config.Theme) {
 		return
 	}
-
-	fmt.Println("Python AI Output:", string(output))
-
-
-	floorData, err := parseAIResponse(output)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "JSON parsing failed", "details": err.Error()})
-		return
+	lastTheme, lastStory := config.LastTheme, config.LastStory
+	if lastTheme == "" {
+		lastTheme = noPreviousFloor
 	}
-
-	var difficultyMultiplier float32
-
-	switch config.Difficulty {
-	case "easy":
-		difficultyMultiplier = 1.0
-	case "medium":
-		difficultyMultiplier = 1.5
-	case "hard":
-		difficultyMultiplier = 2.0
-	default:
-		difficultyMultiplier = 1.0 // fallback if unknown
+	if lastStory == "" {
+		lastStory = noPreviousFloor
 	}
+	startGeneration(c, generation{
+		kind:      "floor",
+		theme:     config.Theme,
+		pastTheme: lastTheme,
+		story:     lastStory,
+		build: func(output []byte) (gin.H, *generationError) {
+			fmt.Println("Python AI Output:", string(output))
+
+			floorData, err := parseAIResponse(output)
+			if err != nil {
+				return nil, &generationError{http.StatusInternalServerError, apierror.CodeGenerationFailed, "Generated floor could not be parsed", err.Error()}
+			}
 
-	floor, err := buildAndSaveFloor(floorData, float32(config.Level), difficultyMultiplier, config.Theme, c)
-	if err != nil {
-		return
-	}
+			difficultyMultiplier := difficultyMultipliers[config.Difficulty]
+
+			floor, err := buildAndSaveFloor(floorData, float32(config.Level), difficultyMultiplier, config.Theme, rand.Int63())
+			if err != nil {
+				return nil, internalError(err)
+			}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Floor created successfully", "floor": floor})
+			return gin.H{"message": "Floor created successfully", "floor": floor}, nil
+		},
+	})
 }
 
 func CreateGame(c *gin.Context) {
 	var config GameConfig
-
-	if err := c.ShouldBindJSON(&config); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &config) {
 		return
 	}
 
 
 	userID := c.MustGet("userID").(uint)  // DELETE comment this out to make it work w/o logging in
-	apiKey := loadAPIKey()
-	args1 := []string{"castle", "cave", "forest"}
-	enemies := []string{"goblin", "bat", "knight"}
-	weapons := []string{"sword", "spear", "bow"}
-
-	output, err := runPythonAI(apiKey, args1, enemies, weapons, config.Theme, "None", "None")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "AI agent failed", "details": err.Error()})
-		log.Println("The AI failed to work, check runPythonAI")
-		log.Println("Output from the AI: ")
-		log.Println(output)
-		log.Println(err.Error())
+	if !entitlements.CheckSaveSlot(c, model.DB, userID) ||
+		!entitlements.CheckGameOptions(c, model.DB, userID, config.Difficulty, config.Theme) {
 		return
 	}
+	startGeneration(c, generation{
+		kind:      "game",
+		theme:     config.Theme,
+		pastTheme: noPreviousFloor,
+		story:     noPreviousFloor,
+		build: func(output []byte) (gin.H, *generationError) {
+			floorData, err := parseAIResponse(output)
+			if err != nil {
+				log.Println("The JSon parsing failed to work, check parseAIResponse")
+				log.Println(err)
+				return nil, &generationError{http.StatusInternalServerError, apierror.CodeGenerationFailed, "Generated floor could not be parsed", err.Error()}
+			}
 
-	floorData, err := parseAIResponse(output)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "JSON parsing failed", "details": err.Error()})
-		log.Println("The JSon parsing failed to work, check parseAIResponse")
-		log.Println(err)
-		return
-	}
+			difficultyMultiplier := difficultyMultipliers[config.Difficulty]
 
-	var difficultyMultiplier float32
+			floor, err := buildAndSaveFloor(floorData, float32(1), difficultyMultiplier, config.Theme, rand.Int63())
+			if err != nil {
+				return nil, internalError(err)
+			}
 
-	switch config.Difficulty {
-	case "easy":
-		difficultyMultiplier = 1.0
-	case "medium":
-		difficultyMultiplier = 1.5
-	case "hard":
-		difficultyMultiplier = 2.0
-	default:
-		difficultyMultiplier = 1.0 // fallback if unknown
-	}
+			game, err := createGame(userID, config, floor)
+			if err != nil {
+				return nil, internalError(err)
+			}
 
-	floor, err := buildAndSaveFloor(floorData, float32(1), difficultyMultiplier, config.Theme, c)
-	if err != nil {
-		return
-	}
+			return gin.H{"message": "Game created successfully", "game": game}, nil
+		},
+	})
+}
 
+// createGame starts a game of the user on a newly built first floor.
+func createGame(userID uint, config GameConfig, floor model.Floor) (model.Game, error) {
 	primary_weapon := model.Weapon{
 		Damage: 10,
 		Sprite: "Primary",
@@ -451,13 +420,11 @@ func CreateGame(c *gin.Context) {
 	}
 
 	if err := model.DB.Create(&primary_weapon).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return model.Game{}, err
 	}
 
 	start_room := floor.Rooms[0]
-	tiles := []rune(start_room.Tiles) // len == cols*rows
-	sx, sy := pickLocation(tiles)
+	sx, sy := pickLocation(rand.New(rand.NewSource(floor.Seed)), start_room.Tiles)
 	
 
 	player := model.Player{
@@ -470,8 +437,7 @@ func CreateGame(c *gin.Context) {
 		PrimaryWeapon: &primary_weapon,
 	}
 	if err := model.DB.Create(&player).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return model.Game{}, err
 	}
 
 	game := model.Game{
@@ -479,38 +445,33 @@ func CreateGame(c *gin.Context) {
 		FloorID:              floor.ID,
 		Floor:                floor,
 		PlayerSpecifications: "Cool Game",
+		Difficulty:           config.Difficulty,
 		PlayerID:             player.ID,
 		Player:               player,
 		UserID:				  userID, //DELETE turn this too a 1
 	}
 	if err := model.DB.Create(&game).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return model.Game{}, err
 	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Game created successfully", "game": game})
+	return game, nil
 }
 
 func SaveGame(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req saveGameRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":   "invalid request body",
-				"details": err.Error(),
-			})
+		if !bindJSON(c, &req) {
 			return
 		}
 		game := &req.Game // convenience pointer
 
 		uidRaw, ok := c.Get("userID")
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "Unauthorized")
 			return
 		}
 		userID, ok := uidRaw.(uint)
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user id in context"})
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Invalid user ID in context")
 			return
 		}
 		game.UserID = userID // claim / re‑claim
@@ -548,39 +509,98 @@ func SaveGame(db *gorm.DB) gin.HandlerFunc {
 			}
 		}
 
-		var err error
 		if game.ID == 0 {
 			// brand‑new: insert everything
-			err = db.Session(&gorm.Session{FullSaveAssociations: true}).
-				Create(game).Error
-		} else {
-			// existing: make sure the user owns it, then update
-			var existing model.Game
-			if err = db.Select("user_id").First(&existing, game.ID).Error; err != nil {
-				if err == gorm.ErrRecordNotFound {
-					c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
-					return
+			settings := gameSettings{Theme: game.Floor.Theme, Difficulty: game.Difficulty, Level: game.Level}
+			if !settings.validate().renamed(map[string]string{
+				"theme":      "game.Floor.Theme",
+				"difficulty": "game.Difficulty",
+				"level":      "game.Level",
+			}).check(c) {
+				return
+			}
+			if !entitlements.CheckSaveSlot(c, db, userID) ||
+				!entitlements.CheckGameOptions(c, db, userID, game.Difficulty, game.Floor.Theme) {
+				return
+			}
+			game.Version = 1
+			stampVersion(game)
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Create(game).Error; err != nil {
+					return err
 				}
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error", "details": err.Error()})
+				return recordSnapshot(tx, game.ID, SnapshotSave)
+			})
+			if err != nil {
+				apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save game", err.Error())
 				return
 			}
+			c.Header("ETag", gameETag(game.Version))
+			c.JSON(http.StatusOK, gin.H{
+				"message": "game saved successfully",
+				"game":    game,
+			})
+			return
+		}
+
+		expected, err := expectedVersion(c, req.Version)
+		if err != nil {
+			abortVersionError(c, err)
+			return
+		}
+
+		var existing model.Game
+		var conflict bool
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// existing: lock the row, make sure the user owns it and that
+			// nobody saved since the client last loaded it, then update
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "user_id", "version").
+				First(&existing, game.ID).Error; err != nil {
+				return err
+			}
 			if existing.UserID != userID {
-				c.JSON(http.StatusForbidden, gin.H{"error": "you do not own this game"})
-				return
+				return errNotOwner
+			}
+			if existing.Version != expected {
+				conflict = true
+				return nil
 			}
 
-			err = db.Session(&gorm.Session{FullSaveAssociations: true}).
-				Save(game).Error
+			game.Version = existing.Version + 1
+			stampVersion(game)
+			if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).
+				Save(game).Error; err != nil {
+				return err
+			}
+			return recordSnapshot(tx, game.ID, SnapshotSave)
+		})
+
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeGameNotFound, "Game not found")
+			return
+		case err == errNotOwner:
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeNotOwner, "You do not own this game")
+			return
+		case err != nil:
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save game", err.Error())
+			return
 		}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "failed to save game",
-				"details": err.Error(),
-			})
+		if conflict {
+			current, err := loadGame(db, game.ID)
+			if err != nil {
+				apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+				return
+			}
+			c.Header("ETag", gameETag(current.Version))
+			apierror.AbortWithDetails(c, http.StatusConflict, apierror.CodeVersionConflict,
+				"Game was modified since version "+strconv.FormatUint(uint64(expected), 10), gin.H{"game": current})
 			return
 		}
 
+		c.Header("ETag", gameETag(game.Version))
 		c.JSON(http.StatusOK, gin.H{
 			"message": "game saved successfully",
 			"game":    game,
@@ -588,18 +608,27 @@ func SaveGame(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// GetUser returns a user's profile, without secrets. Only the user themselves
+// and admins may read it.
 func GetUser(c *gin.Context) {
-	userID := c.Param("userId")
+	userID, ok := paramID(c, "userId", "user")
+	if !ok {
+		return
+	}
+	if userID != c.MustGet("userID").(uint) && !model.RoleAtLeast(c.GetString("role"), model.RoleAdmin) {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "You can only view your own profile")
+		return
+	}
 
 	var user model.User
-	result := model.DB.First(&user, "ID = ?", userID)
+	result := model.DB.First(&user, userID)
 
 	if result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodePlayerNotFound, "Player not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, auth.NewProfile(user))
 }
 
 func GetGames(c *gin.Context) {
@@ -608,7 +637,7 @@ func GetGames(c *gin.Context) {
 	// 2) Make sure the user exists
 	var user model.User
 	if err := model.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeUserNotFound, "User not found")
 		return
 	}
 
@@ -622,26 +651,37 @@ func GetGames(c *gin.Context) {
 		Pluck("level", &gameLevel).
 		Error; err != nil {
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch games"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to fetch games")
 		return
 	}
 
-	// 4) Respond
+	// 4) Respond, keeping the old field names on the unversioned API
+	if apierror.Legacy(c) {
+		c.JSON(http.StatusOK, gin.H{
+			"user_d":  userID,
+			"GameIDs": gameIDs,
+			"Levels": gameLevel,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"user_d":  userID,
-		"GameIDs": gameIDs,
-		"Levels": gameLevel,
+		"user_id":  userID,
+		"game_ids": gameIDs,
+		"levels":   gameLevel,
 	})
 }
 
 func GetPlayer(c *gin.Context) {
-	playerID := c.Param("playerId")
+	playerID, ok := paramID(c, "playerId", "player")
+	if !ok {
+		return
+	}
 
 	var player model.Player
-	result := model.DB.First(&player, "playerId = ?", playerID)
+	result := model.DB.First(&player, playerID)
 
 	if result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodePlayerNotFound, "Player not found")
 		return
 	}
 
@@ -649,13 +689,16 @@ func GetPlayer(c *gin.Context) {
 }
 
 func GetEnemy(c *gin.Context) {
-	enemyID := c.Param("enemyId")
+	enemyID, ok := paramID(c, "enemyId", "enemy")
+	if !ok {
+		return
+	}
 
 	var enemy model.Enemy
-	result := model.DB.First(&enemy, "enemyId = ?", enemyID)
+	result := model.DB.First(&enemy, enemyID)
 
 	if result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Enemy not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeEnemyNotFound, "Enemy not found")
 		return
 	}
 
@@ -664,204 +707,248 @@ func GetEnemy(c *gin.Context) {
 
 
 func SetEnemyHealthHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "enemy")
+	if !ok {
+		return
+	}
 	var body struct { Health int `json:"health"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Enemy{}).Where("id = ?", id).Update("health", body.Health).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "health updated"})
 }
 
 func SetEnemyWeaponHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "enemy")
+	if !ok {
+		return
+	}
 	var body struct { WeaponID uint `json:"weapon_id"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Enemy{}).Where("id = ?", id).Update("weapon_id", body.WeaponID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "weapon update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Weapon update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "weapon updated"})
 }
 
 func DeleteEnemyHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "enemy")
+	if !ok {
+		return
+	}
 	if err := model.DB.Delete(&model.Enemy{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "enemy deleted"})
 }
 
 func GetRoomHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "room")
+	if !ok {
+		return
+	}
 	var room model.Room
 	if err := model.DB.Preload("Enemies").Preload("Chest").First(&room, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeRoomNotFound, "Room not found")
 		return
 	}
 	c.JSON(http.StatusOK, room)
 }
 
 func SetRoomClearedHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "room")
+	if !ok {
+		return
+	}
 	var body struct { Cleared bool `json:"cleared"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Room{}).Where("id = ?", id).Update("cleared", body.Cleared).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "room cleared updated"})
 }
 
 func SetRoomChestHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "room")
+	if !ok {
+		return
+	}
 	var body struct { ChestID uint `json:"chest_id"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Room{}).Where("id = ?", id).Update("chest_id", body.ChestID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "chest update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Chest update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "room chest updated"})
 }
 
 func DeleteRoomHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "room")
+	if !ok {
+		return
+	}
 	if err := model.DB.Delete(&model.Room{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "room deleted"})
 }
 
 func GetChestHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "chest")
+	if !ok {
+		return
+	}
 	var chest model.Chest
 	if err := model.DB.Preload("Weapon").First(&chest, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "chest not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeChestNotFound, "Chest not found")
 		return
 	}
 	c.JSON(http.StatusOK, chest)
 }
 
 func SetChestWeaponHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "chest")
+	if !ok {
+		return
+	}
 	var body struct { WeaponID uint `json:"weapon_id"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Chest{}).Where("id = ?", id).Update("weapon_id", body.WeaponID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "weapon update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Weapon update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "chest weapon updated"})
 }
 
 func RemoveChestWeaponHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "chest")
+	if !ok {
+		return
+	}
 	if err := model.DB.Model(&model.Chest{}).Where("id = ?", id).Update("weapon_id", nil).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "weapon remove failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Weapon remove failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "chest weapon removed"})
 }
 
 func DeleteChestHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "chest")
+	if !ok {
+		return
+	}
 	if err := model.DB.Delete(&model.Chest{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "chest deleted"})
 }
 
 func GetWeaponHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "weapon")
+	if !ok {
+		return
+	}
 	var weapon model.Weapon
 	if err := model.DB.First(&weapon, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "weapon not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeWeaponNotFound, "Weapon not found")
 		return
 	}
 	c.JSON(http.StatusOK, weapon)
 }
 
 func SetWeaponDamageHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "weapon")
+	if !ok {
+		return
+	}
 	var body struct { Damage int `json:"attack_damage"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Weapon{}).Where("id = ?", id).Update("attack_damage", body.Damage).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "weapon damage updated"})
 }
 
 func DeleteWeaponHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "weapon")
+	if !ok {
+		return
+	}
 	if err := model.DB.Delete(&model.Weapon{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "weapon deleted"})
 }
 
 func GetFloorHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "floor")
+	if !ok {
+		return
+	}
 	var floor model.Floor
 	if err := model.DB.Preload("Rooms").First(&floor, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "floor not found"})
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeFloorNotFound, "Floor not found")
 		return
 	}
 	c.JSON(http.StatusOK, floor)
 }
 
 func SetFloorPlayerInHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "floor")
+	if !ok {
+		return
+	}
 	var body struct { PlayerID *uint `json:"player_id"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Floor{}).Where("id = ?", id).Update("player_in_id", body.PlayerID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "floor player updated"})
 }
 
 func DeleteFloorHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "floor")
+	if !ok {
+		return
+	}
 	if err := model.DB.Delete(&model.Floor{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "floor deleted"})
 }
 
-func GetGameHandler(c *gin.Context) {
-    // Parse the game ID
-    id, _ := strconv.Atoi(c.Param("id"))
+// loadGame fetches a game together with its player, floor, rooms, enemies,
+// chests and weapons.
+func loadGame(db *gorm.DB, id uint) (model.Game, error) {
     var game model.Game
 
     // Build the query
-    if err := model.DB.
+    err := db.
         Preload(clause.Associations).
 
         Preload("Player", func(db *gorm.DB) *gorm.DB {
@@ -883,57 +970,100 @@ func GetGameHandler(c *gin.Context) {
 
         }).
 
-        First(&game, id).Error; err != nil {
+        First(&game, id).Error
+
+    return game, err
+}
 
+func GetGameHandler(c *gin.Context) {
+    // Parse the game ID
+    id, ok := paramID(c, "id", "game")
+    if !ok {
+        return
+    }
+
+    game, err := loadGame(model.DB, id)
+    if err != nil {
         // Handle not‑found vs other errors
         if err == gorm.ErrRecordNotFound {
-            c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+            apierror.Abort(c, http.StatusNotFound, apierror.CodeGameNotFound, "Game not found")
         } else {
-            c.JSON(http.StatusInternalServerError, gin.H{
-                "error":   "db error",
-                "details": err.Error(),
-            })
+            apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
         }
         return
     }
 
+    // Let clients skip the download when they already hold this version
+    c.Header("ETag", gameETag(game.Version))
+    if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, game.Version) {
+        c.Status(http.StatusNotModified)
+        return
+    }
+
     // Return the fully‑populated Game
     c.JSON(http.StatusOK, game)
 }
 
 func SetGameLevelHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "game")
+	if !ok {
+		return
+	}
 	var body struct { Level int `json:"level"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
+		return
+	}
+	var errs fieldErrors
+	errs.between("level", body.Level, 1, MaxLevel)
+	if !errs.check(c) {
 		return
 	}
 	if err := model.DB.Model(&model.Game{}).Where("id = ?", id).Update("level", body.Level).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "game level updated"})
 }
 
 func SetFloorStoryTextHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "floor")
+	if !ok {
+		return
+	}
 	var body struct { Text string `json:"story_text"` }
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+	if !bindJSON(c, &body) {
 		return
 	}
 	if err := model.DB.Model(&model.Floor{}).Where("id = ?", id).Update("StoryText", body.Text).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Update failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "game story text updated"})
 }
 
 func DeleteGameHandler(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, ok := paramID(c, "id", "game")
+	if !ok {
+		return
+	}
 	if err := model.DB.Delete(&model.Game{}, id).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete failed"})
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "game deleted"})
 }
+
+// DeleteGame deletes one of the requesting user's games.
+func DeleteGame(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := ownedGame(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Delete(&model.Game{}, game.ID).Error; err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Delete failed")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "game deleted"})
+	}
+}
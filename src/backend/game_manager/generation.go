@@ -0,0 +1,165 @@
+package game_manager
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"backend/apierror"
+	"backend/entitlements"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// generationSlots bounds how many AI generations run at once. prioritySlots
+// are reserved for users entitled to priority generation, so they are not
+// stuck behind a queue of free users.
+var (
+	generationSlots = make(chan struct{}, generationConcurrency())
+	prioritySlots   = make(chan struct{}, 1)
+)
+
+// generationConcurrency reads AI_CONCURRENCY, defaulting to 4.
+func generationConcurrency() int {
+	if n, err := strconv.Atoi(os.Getenv("AI_CONCURRENCY")); err == nil && n > 0 {
+		return n
+	}
+	return 4
+}
+
+// acquireGenerator waits for a generation slot and returns the function
+// releasing it. It gives up when ctx is done.
+func acquireGenerator(ctx context.Context, priority bool) (func(), bool) {
+	done := ctx.Done()
+	if priority {
+		select {
+		case prioritySlots <- struct{}{}:
+			return func() { <-prioritySlots }, true
+		case generationSlots <- struct{}{}:
+			return func() { <-generationSlots }, true
+		case <-done:
+			return nil, false
+		}
+	}
+	select {
+	case generationSlots <- struct{}{}:
+		return func() { <-generationSlots }, true
+	case <-done:
+		return nil, false
+	}
+}
+
+// runAI runs the AI agent; tests replace it.
+var runAI = runPythonAI
+
+// refundGeneration gives back a generation counted by startGeneration that
+// failed.
+func refundGeneration(userID uint) {
+	if err := entitlements.RefundGeneration(model.DB, userID); err != nil {
+		log.Println("Failed to refund generation:", err)
+	}
+}
+
+// generationError is a failed generation and the error it is answered with.
+type generationError struct {
+	status  int
+	code    string
+	message string
+	details interface{}
+}
+
+func (e *generationError) Error() string {
+	return e.message
+}
+
+// internalError reports a database failure while saving what was generated.
+func internalError(err error) *generationError {
+	return &generationError{http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", err.Error()}
+}
+
+// generation is the work of an AI-backed request: running the agent with
+// the themes, then building the response body from its output. It does not
+// depend on the request, so it can outlive it when run as a job.
+type generation struct {
+	kind                    string
+	theme, pastTheme, story string
+	build                   func(output []byte) (gin.H, *generationError)
+}
+
+// run runs the generation once a slot is free. A failed generation is given
+// back to the user; so is one given up because ctx is done, which is
+// reported with ctx's error.
+func (g generation) run(ctx context.Context, userID uint, priority bool) (gin.H, error) {
+	release, ok := acquireGenerator(ctx, priority)
+	if !ok {
+		refundGeneration(userID)
+		return nil, ctx.Err()
+	}
+	defer release()
+
+	args1 := []string{"castle", "cave", "forest"}
+	enemies := []string{"goblin", "bat", "knight"}
+	weapons := []string{"sword", "spear", "bow"}
+
+	output, err := runAI(loadAPIKey(), args1, enemies, weapons, g.theme, g.pastTheme, g.story)
+	if err != nil {
+		refundGeneration(userID)
+		log.Println("The AI failed to work, check runPythonAI")
+		log.Println("Output from the AI: ")
+		log.Println(string(output))
+		log.Println(err.Error())
+		return nil, &generationError{http.StatusInternalServerError, apierror.CodeGenerationFailed, "Floor generation failed", err.Error()}
+	}
+
+	body, genErr := g.build(output)
+	if genErr != nil {
+		refundGeneration(userID)
+		return nil, genErr
+	}
+	return body, nil
+}
+
+// startGeneration counts a generation against the current user's daily
+// limit and runs it. Clients sending "Prefer: respond-async" get a job to
+// poll instead of waiting, if their plan allows it.
+func startGeneration(c *gin.Context, g generation) {
+	userID := c.MustGet("userID").(uint)
+	async := prefersAsync(c)
+	if async && !entitlements.CheckAsyncGeneration(c, model.DB, userID) {
+		return
+	}
+	priority, ok := entitlements.UseGeneration(c, model.DB, userID)
+	if !ok {
+		return
+	}
+
+	if async {
+		job, err := startGenerationJob(model.DB, userID, priority, g)
+		if err != nil {
+			refundGeneration(userID)
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+		c.Header("Location", generationJobPath(c, job.ID))
+		c.Header("Preference-Applied", "respond-async")
+		c.JSON(http.StatusAccepted, gin.H{"job": toGenerationJobDTO(job)})
+		return
+	}
+
+	body, err := g.run(c.Request.Context(), userID, priority)
+	var genErr *generationError
+	if errors.As(err, &genErr) {
+		apierror.AbortWithDetails(c, genErr.status, genErr.code, genErr.message, genErr.details)
+		return
+	}
+	if err != nil {
+		// The client went away while waiting
+		c.Abort()
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
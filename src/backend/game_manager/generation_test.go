@@ -0,0 +1,101 @@
+package game_manager
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/dbtest"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeGenerationDB points model.DB at a fake database holding a verified free
+// user whose generation count it keeps, and returns a function reading that
+// count.
+func fakeGenerationDB(t *testing.T) (*dbtest.DB, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	used := 0
+	db, fake := dbtest.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, `FROM "users"`):
+			return []string{"id", "email_verified_at", "subscription_level"}, [][]driver.Value{{int64(1), time.Now(), int64(0)}}
+		case strings.Contains(query, `FROM "generation_usages"`):
+			mu.Lock()
+			defer mu.Unlock()
+			return []string{"user_id", "count"}, [][]driver.Value{{int64(1), int64(used)}}
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO generation_usages"):
+			used++
+		case strings.HasPrefix(query, `UPDATE "generation_usages"`) && used > 0:
+			used--
+		}
+		return 1
+	})
+
+	previous := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = previous })
+	return fake, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return used
+	}
+}
+
+// TestFailedGenerationIsNotCounted checks that a generation whose output
+// cannot be turned into a floor is given back.
+func TestFailedGenerationIsNotCounted(t *testing.T) {
+	previous := runAI
+	t.Cleanup(func() { runAI = previous })
+
+	tests := []struct {
+		name   string
+		output string
+		fail   string
+	}{
+		{"unparsable output", "not JSON", ""},
+		{"floor not saved", "{}", `INSERT INTO "floors"`},
+	}
+	for _, tt := range tests {
+		fake, used := fakeGenerationDB(t)
+		if tt.fail != "" {
+			fake.Fail(tt.fail, errors.New("database is down"))
+		}
+		runAI = func(string, []string, []string, []string, string, string, string) ([]byte, error) {
+			return []byte(tt.output), nil
+		}
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/create_floor", func(c *gin.Context) { c.Set("userID", uint(1)) }, CreateFloor)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/create_floor",
+			strings.NewReader(`{"theme": "castle", "difficulty": "easy", "level": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status = %d; want 500: %s", tt.name, w.Code, w.Body)
+		}
+		if len(fake.Executed("INSERT INTO generation_usages")) != 1 {
+			t.Errorf("%s: the generation was not counted", tt.name)
+		}
+		if n := used(); n != 0 {
+			t.Errorf("%s: %d generation(s) used; want 0", tt.name, n)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package game_manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// generationJobTimeout is how long a job may stay pending before it is
+// reported as failed, for jobs whose server stopped while running them.
+const generationJobTimeout = 30 * time.Minute
+
+type GenerationJobDTO struct {
+	ID     uint   `json:"id"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	// Result is the body the request would have been answered with
+	Result    model.JSONDocument `json:"result"`
+	Error     *apierror.Error    `json:"error"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+func toGenerationJobDTO(job model.GenerationJob) GenerationJobDTO {
+	dto := GenerationJobDTO{
+		ID:        job.ID,
+		Kind:      job.Kind,
+		Status:    job.Status,
+		Result:    job.Result,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	if len(job.Error) > 0 {
+		dto.Error = &apierror.Error{}
+		if err := json.Unmarshal(job.Error, dto.Error); err != nil {
+			dto.Error = &apierror.Error{Code: apierror.CodeInternal, Message: "The job's error could not be read"}
+		}
+	}
+	if job.Status == model.GenerationPending && time.Since(job.CreatedAt) > generationJobTimeout {
+		dto.Status = model.GenerationFailed
+		dto.Error = &apierror.Error{Code: apierror.CodeGenerationFailed, Message: "The generation was interrupted"}
+	}
+	return dto
+}
+
+// prefersAsync reports whether the client sent "Prefer: respond-async"
+// (RFC 7240).
+func prefersAsync(c *gin.Context) bool {
+	for _, header := range c.Request.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(preference, ";")
+			name, _, _ = strings.Cut(name, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generationJobPath is where the job started by the request can be polled,
+// under the same API prefix as the request.
+func generationJobPath(c *gin.Context, id uint) string {
+	return path.Join(path.Dir(c.FullPath()), "generation_jobs", strconv.FormatUint(uint64(id), 10))
+}
+
+// startGenerationJob records a pending job and runs the generation in the
+// background.
+func startGenerationJob(db *gorm.DB, userID uint, priority bool, g generation) (model.GenerationJob, error) {
+	job := model.GenerationJob{UserID: userID, Kind: g.kind, Status: model.GenerationPending}
+	if err := db.Create(&job).Error; err != nil {
+		return job, err
+	}
+	go runGenerationJob(db, job.ID, userID, priority, g)
+	return job, nil
+}
+
+// runGenerationJob runs a job's generation and stores its outcome. Unlike a
+// request, a job has no recovery middleware, so it recovers from panics
+// itself rather than take the server down.
+func runGenerationJob(db *gorm.DB, jobID, userID uint, priority bool, g generation) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Generation job %d panicked: %v", jobID, r)
+			refundGeneration(userID)
+			finishGenerationJob(db, jobID, nil, &generationError{http.StatusInternalServerError, apierror.CodeInternal, "Internal server error", nil})
+		}
+	}()
+	body, err := g.run(context.Background(), userID, priority)
+	finishGenerationJob(db, jobID, body, err)
+}
+
+// finishGenerationJob stores the response body of a job, or its error.
+func finishGenerationJob(db *gorm.DB, jobID uint, body gin.H, err error) {
+	var result []byte
+	if err == nil {
+		result, err = json.Marshal(body)
+	}
+	update := map[string]interface{}{"status": model.GenerationSucceeded, "result": model.JSONDocument(result)}
+	if err != nil {
+		failure := apierror.Error{Code: apierror.CodeInternal, Message: "Internal server error", Details: err.Error()}
+		var genErr *generationError
+		if errors.As(err, &genErr) {
+			failure = apierror.Error{Code: genErr.code, Message: genErr.message, Details: genErr.details}
+		}
+		data, _ := json.Marshal(failure)
+		update = map[string]interface{}{"status": model.GenerationFailed, "error": model.JSONDocument(data)}
+	}
+	if err := db.Model(&model.GenerationJob{}).Where("id = ?", jobID).Updates(update).Error; err != nil {
+		log.Printf("Failed to record the outcome of generation job %d: %v", jobID, err)
+	}
+}
+
+// GetGenerationJob returns a generation job of the current user. Once it
+// has succeeded, its result holds the created game or floor.
+func GetGenerationJob(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := paramID(c, "id", "generation job")
+		if !ok {
+			return
+		}
+
+		var job model.GenerationJob
+		err := db.Where("user_id = ?", c.MustGet("userID").(uint)).First(&job, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeGenerationJobNotFound, "Generation job not found")
+			return
+		}
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"job": toGenerationJobDTO(job)})
+	}
+}
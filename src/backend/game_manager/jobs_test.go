@@ -0,0 +1,268 @@
+package game_manager
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/dbtest"
+	"backend/entitlements"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobStore is a fake database holding user 1 at a subscription level, their
+// generation count and their generation jobs.
+type jobStore struct {
+	mu   sync.Mutex
+	used int
+	jobs []map[string]driver.Value
+}
+
+var jobColumns = []string{"id", "created_at", "updated_at", "user_id", "kind", "status", "result", "error"}
+
+func useJobDB(t *testing.T, level int64) (*dbtest.DB, *jobStore) {
+	s := &jobStore{}
+	db, fake := dbtest.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.Contains(query, `FROM "users"`):
+			return []string{"id", "email_verified_at", "subscription_level"}, [][]driver.Value{{int64(1), time.Now(), level}}
+		case strings.Contains(query, `FROM "generation_usages"`):
+			return []string{"user_id", "count"}, [][]driver.Value{{int64(1), int64(s.used)}}
+		case strings.HasPrefix(query, `SELECT count(*) FROM "games"`):
+			return []string{"count"}, [][]driver.Value{{int64(0)}}
+		case strings.HasPrefix(query, `INSERT INTO "generation_jobs"`):
+			job := dbtest.Inserted(query, args)
+			job["id"] = int64(len(s.jobs) + 1)
+			s.jobs = append(s.jobs, job)
+			return []string{"id"}, [][]driver.Value{{job["id"]}}
+		case strings.HasPrefix(query, `SELECT * FROM "generation_jobs"`):
+			for _, job := range s.jobs {
+				if job["user_id"] == args[0] && job["id"] == args[1] {
+					row := make([]driver.Value, len(jobColumns))
+					for i, column := range jobColumns {
+						row[i] = job[column]
+					}
+					return jobColumns, [][]driver.Value{row}
+				}
+			}
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO generation_usages"):
+			s.used++
+		case strings.HasPrefix(query, `UPDATE "generation_usages"`) && s.used > 0:
+			s.used--
+		case strings.HasPrefix(query, `UPDATE "generation_jobs"`):
+			for column, value := range dbtest.Assigned(query, args) {
+				s.jobs[args[len(args)-1].(int64)-1][column] = value
+			}
+		}
+		return 1
+	})
+
+	previous := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = previous })
+	return fake, s
+}
+
+// wait returns job id once it is no longer pending.
+func (s *jobStore) wait(t *testing.T, id int) map[string]driver.Value {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		job := s.jobs[id-1]
+		done := job["status"] != model.GenerationPending
+		s.mu.Unlock()
+		if done {
+			return job
+		}
+	}
+	t.Fatalf("job %d is still pending", id)
+	return nil
+}
+
+func (s *jobStore) usedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// oneRoomFloor is AI output describing a floor of a single room.
+const oneRoomFloor = `{
+	"floors": {"floorMap": [[1]], "rooms": {"room1": [["#", "#", "#"], ["#", ".", "#"], ["#", "#", "#"]]}},
+	"weapons": [{"attack": 3, "type": 1, "sprite": "sword"}],
+	"story": "A quiet room"
+}`
+
+// useAIOutput makes the AI agent answer with output.
+func useAIOutput(t *testing.T, output string) {
+	previous := runAI
+	t.Cleanup(func() { runAI = previous })
+	runAI = func(string, []string, []string, []string, string, string, string) ([]byte, error) {
+		return []byte(output), nil
+	}
+}
+
+// serveAsync sends body to handler as user 1, preferring an asynchronous
+// response.
+func serveAsync(path string, handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(path, func(c *gin.Context) { c.Set("userID", uint(1)) }, handler)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "wait=10, respond-async")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// getJob fetches job id as the given user.
+func getJob(userID uint, id string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/generation_jobs/:id", func(c *gin.Context) { c.Set("userID", userID) }, GetGenerationJob(model.DB))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/generation_jobs/"+id, nil))
+	return w
+}
+
+func TestPrefersAsync(t *testing.T) {
+	tests := map[string]bool{
+		"":                       false,
+		"respond-async":          true,
+		"Respond-Async":          true,
+		"wait=10, respond-async": true,
+		"respond-async; wait=10": true,
+		"return=minimal":         false,
+		"respond-asynchronously": false,
+	}
+	for header, want := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		if header != "" {
+			c.Request.Header.Set("Prefer", header)
+		}
+		if got := prefersAsync(c); got != want {
+			t.Errorf("Prefer: %s: prefersAsync = %v; want %v", header, got, want)
+		}
+	}
+}
+
+func TestAsyncGenerationRequiresSubscription(t *testing.T) {
+	fake, _ := useJobDB(t, model.SubscriptionFree)
+	useAIOutput(t, "{}")
+
+	w := serveAsync("/create_floor", CreateFloor, `{"theme": "castle", "difficulty": "easy", "level": 1}`)
+	var body apierror.Response
+	json.Unmarshal(w.Body.Bytes(), &body)
+	details, _ := body.Error.Details.(map[string]interface{})
+	if w.Code != http.StatusPaymentRequired || body.Error.Code != apierror.CodeEntitlementMissing || details["entitlement"] != entitlements.AsyncGeneration {
+		t.Errorf("status = %d, error = %+v; want 402 naming %s", w.Code, body.Error, entitlements.AsyncGeneration)
+	}
+	if n := len(fake.Executed("generation_usages")) + len(fake.Executed("generation_jobs")); n != 0 {
+		t.Errorf("a refused request counted a generation or started a job")
+	}
+}
+
+func TestAsyncGeneration(t *testing.T) {
+	_, store := useJobDB(t, model.SubscriptionMonthly)
+	useAIOutput(t, oneRoomFloor)
+
+	w := serveAsync("/create_floor", CreateFloor, `{"theme": "castle", "difficulty": "easy", "level": 1}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if location := w.Header().Get("Location"); location != "/generation_jobs/1" {
+		t.Errorf("Location = %s; want /generation_jobs/1", location)
+	}
+	if applied := w.Header().Get("Preference-Applied"); applied != "respond-async" {
+		t.Errorf("Preference-Applied = %q; want respond-async", applied)
+	}
+	var accepted struct {
+		Job GenerationJobDTO `json:"job"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &accepted); err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Job.ID != 1 || accepted.Job.Kind != "floor" || accepted.Job.Status != model.GenerationPending {
+		t.Errorf("job = %+v; want pending floor job 1", accepted.Job)
+	}
+
+	store.wait(t, 1)
+	w = getJob(1, "1")
+	var got struct {
+		Job struct {
+			Status string `json:"status"`
+			Result struct {
+				Floor *model.Floor `json:"floor"`
+			} `json:"result"`
+			Error *apierror.Error `json:"error"`
+		} `json:"job"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || got.Job.Status != model.GenerationSucceeded || got.Job.Result.Floor == nil || got.Job.Error != nil {
+		t.Errorf("status = %d, body = %s; want the succeeded job with its floor", w.Code, w.Body)
+	}
+	if n := store.usedCount(); n != 1 {
+		t.Errorf("%d generations used; want 1", n)
+	}
+
+	// Jobs of other users are not found
+	w = getJob(2, "1")
+	if code, _ := errorOf(t, w); w.Code != http.StatusNotFound || code != apierror.CodeGenerationJobNotFound {
+		t.Errorf("another user's job: status = %d, code = %s; want 404 %s", w.Code, code, apierror.CodeGenerationJobNotFound)
+	}
+}
+
+// TestFailedGenerationJob checks that failed jobs record their error and
+// give the generation back, even when building the result panics.
+func TestFailedGenerationJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		handler gin.HandlerFunc
+		body    string
+		output  string
+		code    string
+	}{
+		{"unparsable output", "/create_floor", CreateFloor, `{"theme": "castle", "difficulty": "easy", "level": 1}`, "not JSON", apierror.CodeGenerationFailed},
+		{"floor without rooms, which panics", "/create_game", CreateGame, `{"theme": "castle", "difficulty": "easy"}`, "{}", apierror.CodeInternal},
+	}
+	for _, tt := range tests {
+		_, store := useJobDB(t, model.SubscriptionLifetime)
+		useAIOutput(t, tt.output)
+
+		if w := serveAsync(tt.path, tt.handler, tt.body); w.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d: %s", tt.name, w.Code, w.Body)
+		}
+		job := store.wait(t, 1)
+		if job["status"] != model.GenerationFailed {
+			t.Errorf("%s: status = %v; want failed", tt.name, job["status"])
+		}
+		var failure apierror.Error
+		if err := json.Unmarshal([]byte(job["error"].(string)), &failure); err != nil || failure.Code != tt.code {
+			t.Errorf("%s: error = %v; want %s", tt.name, job["error"], tt.code)
+		}
+		if n := store.usedCount(); n != 0 {
+			t.Errorf("%s: %d generations used; want 0", tt.name, n)
+		}
+	}
+}
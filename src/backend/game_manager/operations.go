@@ -0,0 +1,326 @@
+package game_manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Operation types accepted by PatchGame.
+const (
+	OpPlayerMoved    = "player_moved"
+	OpEnemyDamaged   = "enemy_damaged"
+	OpEnemyKilled    = "enemy_killed"
+	OpRoomCleared    = "room_cleared"
+	OpChestOpened    = "chest_opened"
+	OpWeaponEquipped = "weapon_equipped"
+)
+
+// GameOperation is a single change to a game's state. Which fields are used
+// depends on Op:
+//
+//	player_moved     x, y
+//	enemy_damaged    enemy_id, current_health
+//	enemy_killed     enemy_id
+//	room_cleared     room_id
+//	chest_opened     chest_id
+//	weapon_equipped  weapon_id, slot ("primary" or "secondary")
+type GameOperation struct {
+	Op            string   `json:"op" binding:"required"`
+	X             *int     `json:"x,omitempty"`
+	Y             *int     `json:"y,omitempty"`
+	EnemyID       uint     `json:"enemy_id,omitempty"`
+	CurrentHealth *float32 `json:"current_health,omitempty"`
+	RoomID        uint     `json:"room_id,omitempty"`
+	ChestID       uint     `json:"chest_id,omitempty"`
+	WeaponID      uint     `json:"weapon_id,omitempty"`
+	Slot          string   `json:"slot,omitempty"`
+}
+
+type patchGameRequest struct {
+	// Version is the game version the client last saw; it can also be sent
+	// as an If-Match header.
+	Version    *uint           `json:"version"`
+	Operations []GameOperation `json:"operations" binding:"required,min=1,dive"`
+}
+
+// operationError reports which operation of a patch could not be applied.
+type operationError struct {
+	index  int
+	status int
+	err    error
+}
+
+func (e *operationError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.index, e.err)
+}
+
+// code is the API error code matching the status of the error.
+func (e *operationError) code() string {
+	switch e.status {
+	case http.StatusBadRequest:
+		return apierror.CodeInvalidOperation
+	case http.StatusNotFound:
+		return apierror.CodeTargetNotFound
+	}
+	return apierror.CodeInternal
+}
+
+var errVersionConflict = errors.New("version conflict")
+
+// PatchGame applies a list of operations to a game in a single transaction
+// and bumps its version, so clients only send what changed since their last
+// save instead of the whole game graph.
+func PatchGame(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := paramID(c, "id", "game")
+		if !ok {
+			return
+		}
+
+		var req patchGameRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		expected, err := expectedVersion(c, req.Version)
+		if err != nil {
+			abortVersionError(c, err)
+			return
+		}
+
+		userID := c.MustGet("userID").(uint)
+
+		var game model.Game
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				First(&game, id).Error; err != nil {
+				return err
+			}
+			if game.UserID != userID {
+				return errNotOwner
+			}
+			if game.Version != expected {
+				return errVersionConflict
+			}
+
+			for i, op := range req.Operations {
+				if err := applyOperation(tx, &game, op); err != nil {
+					err.index = i
+					return err
+				}
+			}
+
+			game.Version++
+			if err := tx.Model(&model.Game{}).Where("id = ?", game.ID).
+				Update("version", game.Version).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&model.Floor{}).Where("id = ?", game.FloorID).
+				Update("version", game.Version).Error; err != nil {
+				return err
+			}
+			return tx.Model(&model.Player{}).Where("id = ?", game.PlayerID).
+				Update("version", game.Version).Error
+		})
+
+		var opErr *operationError
+		switch {
+		case err == nil:
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeGameNotFound, "Game not found")
+			return
+		case errors.Is(err, errNotOwner):
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeNotOwner, "You do not own this game")
+			return
+		case errors.Is(err, errVersionConflict):
+			current, err := loadGame(db, game.ID)
+			if err != nil {
+				apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+				return
+			}
+			c.Header("ETag", gameETag(current.Version))
+			apierror.AbortWithDetails(c, http.StatusConflict, apierror.CodeVersionConflict,
+				"Game was modified since version "+strconv.FormatUint(uint64(expected), 10), gin.H{"game": current})
+			return
+		case errors.As(err, &opErr):
+			apierror.AbortWithDetails(c, opErr.status, opErr.code(), "Failed to apply operation", opErr.Error())
+			return
+		default:
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to update game", err.Error())
+			return
+		}
+
+		c.Header("ETag", gameETag(game.Version))
+		c.JSON(http.StatusOK, gin.H{
+			"message": "game updated successfully",
+			"version": game.Version,
+		})
+	}
+}
+
+// applyOperation performs a single operation inside the patch transaction.
+// Every entity it touches must belong to the game's current floor or player.
+func applyOperation(tx *gorm.DB, game *model.Game, op GameOperation) *operationError {
+	invalid := func(format string, args ...interface{}) *operationError {
+		return &operationError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+	}
+	failed := func(err error) *operationError {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &operationError{status: http.StatusNotFound, err: fmt.Errorf("%s: target not found in this game", op.Op)}
+		}
+		return &operationError{status: http.StatusInternalServerError, err: err}
+	}
+
+	switch op.Op {
+	case OpPlayerMoved:
+		if op.X == nil || op.Y == nil {
+			return invalid("%s requires x and y", op.Op)
+		}
+		if *op.X < 0 || *op.X >= model.RoomWidth || *op.Y < 0 || *op.Y >= model.RoomHeight {
+			return invalid("position (%d, %d) is outside the room", *op.X, *op.Y)
+		}
+		err := tx.Model(&model.Player{}).Where("id = ?", game.PlayerID).
+			Updates(map[string]interface{}{"pos_x": *op.X, "pos_y": *op.Y}).Error
+		if err != nil {
+			return failed(err)
+		}
+
+	case OpEnemyDamaged:
+		if op.EnemyID == 0 || op.CurrentHealth == nil {
+			return invalid("%s requires enemy_id and current_health", op.Op)
+		}
+		enemy, err := gameEnemy(tx, game, op.EnemyID)
+		if err != nil {
+			return failed(err)
+		}
+		if *op.CurrentHealth < 0 || *op.CurrentHealth > enemy.MaxHealth {
+			return invalid("current_health must be between 0 and %g", enemy.MaxHealth)
+		}
+		if err := tx.Model(&enemy).Update("current_health", *op.CurrentHealth).Error; err != nil {
+			return failed(err)
+		}
+
+	case OpEnemyKilled:
+		if op.EnemyID == 0 {
+			return invalid("%s requires enemy_id", op.Op)
+		}
+		enemy, err := gameEnemy(tx, game, op.EnemyID)
+		if err != nil {
+			return failed(err)
+		}
+		if err := tx.Delete(&enemy).Error; err != nil {
+			return failed(err)
+		}
+
+	case OpRoomCleared:
+		if op.RoomID == 0 {
+			return invalid("%s requires room_id", op.Op)
+		}
+		var room model.Room
+		if err := tx.Where("id = ? AND floor_id = ?", op.RoomID, game.FloorID).First(&room).Error; err != nil {
+			return failed(err)
+		}
+		if err := tx.Model(&room).Update("cleared", true).Error; err != nil {
+			return failed(err)
+		}
+
+	case OpChestOpened:
+		if op.ChestID == 0 {
+			return invalid("%s requires chest_id", op.Op)
+		}
+		chest, err := gameChest(tx, game, "chests.id = ?", op.ChestID)
+		if err != nil {
+			return failed(err)
+		}
+		if err := tx.Model(&chest).Update("opened", true).Error; err != nil {
+			return failed(err)
+		}
+
+	case OpWeaponEquipped:
+		if op.WeaponID == 0 {
+			return invalid("%s requires weapon_id", op.Op)
+		}
+		column := map[string]string{
+			"primary":   "primary_weapon_id",
+			"secondary": "secondary_weapon_id",
+		}[op.Slot]
+		if column == "" {
+			return invalid("slot must be \"primary\" or \"secondary\"")
+		}
+		if err := equipWeapon(tx, game, op.WeaponID, column); err != nil {
+			return failed(err)
+		}
+
+	default:
+		return invalid("unknown operation %q", op.Op)
+	}
+	return nil
+}
+
+// gameEnemy loads an enemy that lives in one of the rooms of the game's floor.
+func gameEnemy(tx *gorm.DB, game *model.Game, enemyID uint) (model.Enemy, error) {
+	var enemy model.Enemy
+	err := tx.Joins("JOIN rooms ON rooms.id = enemies.room_id AND rooms.deleted_at IS NULL").
+		Where("enemies.id = ? AND rooms.floor_id = ?", enemyID, game.FloorID).
+		First(&enemy).Error
+	return enemy, err
+}
+
+// gameChest loads a chest placed in one of the rooms of the game's floor.
+func gameChest(tx *gorm.DB, game *model.Game, query string, args ...interface{}) (model.Chest, error) {
+	var chest model.Chest
+	err := tx.Joins("JOIN rooms ON rooms.chest_id = chests.id AND rooms.deleted_at IS NULL").
+		Where("rooms.floor_id = ?", game.FloorID).
+		Where(query, args...).
+		First(&chest).Error
+	return chest, err
+}
+
+// equipWeapon puts a weapon into one of the player's slots. The weapon must
+// already be carried by the player or lie in a chest on the current floor; in
+// the latter case the weapon it replaces is left in the chest.
+func equipWeapon(tx *gorm.DB, game *model.Game, weaponID uint, column string) error {
+	var player model.Player
+	if err := tx.First(&player, game.PlayerID).Error; err != nil {
+		return err
+	}
+
+	current := player.PrimaryWeaponID
+	if column == "secondary_weapon_id" {
+		current = player.SecondaryWeaponID
+	}
+	if current != nil && *current == weaponID {
+		return nil
+	}
+
+	other, otherColumn := player.SecondaryWeaponID, "secondary_weapon_id"
+	if column == "secondary_weapon_id" {
+		other, otherColumn = player.PrimaryWeaponID, "primary_weapon_id"
+	}
+
+	if other != nil && *other == weaponID {
+		// switching hands: the weapon in this slot moves to the other one
+		if err := tx.Model(&player).Update(otherColumn, current).Error; err != nil {
+			return err
+		}
+	} else {
+		chest, err := gameChest(tx, game, "chests.weapon_id = ?", weaponID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&chest).Update("weapon_id", current).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&player).Update(column, weaponID).Error
+}
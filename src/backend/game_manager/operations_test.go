@@ -0,0 +1,70 @@
+package game_manager
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/dbtest"
+	"backend/model"
+)
+
+// TestApplyOperationRejects checks the operations a patch may not apply,
+// in particular those naming a room or enemy on another floor than the
+// game's. The fake database holds room 21 with enemy 31 on the game's floor
+// 5, and room 22 with enemy 32 on floor 6.
+func TestApplyOperationRejects(t *testing.T) {
+	floorOf := map[int64]int64{21: 5, 22: 6}
+	roomOf := map[int64]int64{31: 21, 32: 22}
+	db, _ := dbtest.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, `FROM "rooms"`) && strings.Contains(query, "floor_id = $2"):
+			if floorOf[args[0].(int64)] == args[1].(int64) {
+				return []string{"id"}, [][]driver.Value{{args[0]}}
+			}
+		case strings.Contains(query, `FROM "enemies"`) && strings.Contains(query, "rooms.floor_id = $2"):
+			if floorOf[roomOf[args[0].(int64)]] == args[1].(int64) {
+				return []string{"id", "max_health"}, [][]driver.Value{{args[0], 50.0}}
+			}
+		}
+		return nil, nil
+	})
+	game := &model.Game{FloorID: 5, PlayerID: 4}
+
+	health := float32(20)
+	tests := []struct {
+		name   string
+		op     GameOperation
+		status int
+	}{
+		{"unknown operation", GameOperation{Op: "door_opened"}, http.StatusBadRequest},
+		{"room without room_id", GameOperation{Op: OpRoomCleared}, http.StatusBadRequest},
+		{"enemy without enemy_id", GameOperation{Op: OpEnemyKilled}, http.StatusBadRequest},
+		{"damage without health", GameOperation{Op: OpEnemyDamaged, EnemyID: 31}, http.StatusBadRequest},
+		{"chest without chest_id", GameOperation{Op: OpChestOpened}, http.StatusBadRequest},
+		{"weapon without slot", GameOperation{Op: OpWeaponEquipped, WeaponID: 1}, http.StatusBadRequest},
+		{"move without y", GameOperation{Op: OpPlayerMoved, X: new(int)}, http.StatusBadRequest},
+		{"missing room", GameOperation{Op: OpRoomCleared, RoomID: 99}, http.StatusNotFound},
+		{"room on another floor", GameOperation{Op: OpRoomCleared, RoomID: 22}, http.StatusNotFound},
+		{"enemy on another floor", GameOperation{Op: OpEnemyKilled, EnemyID: 32}, http.StatusNotFound},
+		{"damaged enemy on another floor", GameOperation{Op: OpEnemyDamaged, EnemyID: 32, CurrentHealth: &health}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		err := applyOperation(db, game, tt.op)
+		if err == nil || err.status != tt.status {
+			t.Errorf("%s: error = %v; want status %d", tt.name, err, tt.status)
+		}
+	}
+
+	// The same operations succeed on the game's own floor
+	for _, op := range []GameOperation{
+		{Op: OpRoomCleared, RoomID: 21},
+		{Op: OpEnemyKilled, EnemyID: 31},
+		{Op: OpEnemyDamaged, EnemyID: 31, CurrentHealth: &health},
+	} {
+		if err := applyOperation(db, game, op); err != nil {
+			t.Errorf("%+v: %v", op, err)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package game_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"backend/apierror"
+	"backend/entitlements"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SaveFileFormat identifies an exported save file.
+const SaveFileFormat = "the-last-game/save"
+
+// SaveFileVersion is the schema version written by ExportGame.
+//
+// Version 1 wraps a game document as returned by GET /game/:id:
+// {"format": ..., "schema_version": 1, "game": {...}}. Version 2 is SaveFile.
+const SaveFileVersion = 2
+
+// maxSaveFileSize is the largest save file ImportGame accepts, in bytes. A
+// generated game is a few dozen kilobytes.
+const maxSaveFileSize = 1 << 20
+
+// saveFileUpgrades turns a save file of version N into version N+1.
+var saveFileUpgrades = map[int]func(json.RawMessage) (json.RawMessage, error){
+	1: upgradeSaveFileV1,
+}
+
+// SaveFile is a self-contained, portable copy of a game. IDs inside it are
+// only meaningful within the file: they are used to link rooms together and
+// are replaced by fresh ones on import.
+type SaveFile struct {
+	Format        string       `json:"format"`
+	SchemaVersion int          `json:"schema_version"`
+	ExportedAt    time.Time    `json:"exported_at"`
+	Seed          int64        `json:"seed"`
+	Config        SaveConfig   `json:"config"`
+	Game          SaveGameInfo `json:"game"`
+	Player        SavePlayer   `json:"player"`
+	Floor         SaveFloor    `json:"floor"`
+}
+
+type SaveConfig struct {
+	Theme      string `json:"theme"`
+	Difficulty string `json:"difficulty"`
+	Level      int    `json:"level"`
+}
+
+type SaveGameInfo struct {
+	ID                   uint   `json:"id"`
+	Version              uint   `json:"version"`
+	PlayerSpecifications string `json:"player_specifications"`
+}
+
+type SavePlayer struct {
+	ID              uint        `json:"id"`
+	MaxHealth       int         `json:"max_health"`
+	CurrentHealth   int         `json:"current_health"`
+	SpriteName      string      `json:"sprite_name"`
+	PosX            int         `json:"pos_x"`
+	PosY            int         `json:"pos_y"`
+	PrimaryWeapon   *SaveWeapon `json:"primary_weapon"`
+	SecondaryWeapon *SaveWeapon `json:"secondary_weapon"`
+}
+
+type SaveWeapon struct {
+	ID     uint    `json:"id"`
+	Damage float32 `json:"damage"`
+	Sprite string  `json:"sprite"`
+	Type   int     `json:"type"`
+}
+
+type SaveFloor struct {
+	ID        uint                  `json:"id"`
+	StoryText string                `json:"story_text"`
+	Theme     string                `json:"theme"`
+	FloorMap  model.FloorGrid       `json:"floor_map"`
+	Adjacency model.AdjacencyMatrix `json:"adjacency"`
+	Rooms     []SaveRoom            `json:"rooms"`
+}
+
+type SaveRoom struct {
+	ID       uint           `json:"id"`
+	X        int            `json:"x"`
+	Y        int            `json:"y"`
+	Type     *int           `json:"type"`
+	Cleared  bool           `json:"cleared"`
+	Tiles    model.TileGrid `json:"tiles"`
+	StairX   *int           `json:"stair_x"`
+	StairY   *int           `json:"stair_y"`
+	TopID    *uint          `json:"top_id"`
+	BottomID *uint          `json:"bottom_id"`
+	LeftID   *uint          `json:"left_id"`
+	RightID  *uint          `json:"right_id"`
+	Enemies  []SaveEnemy    `json:"enemies"`
+	Chest    *SaveChest     `json:"chest"`
+}
+
+type SaveEnemy struct {
+	ID            uint    `json:"id"`
+	Damage        float32 `json:"damage"`
+	Level         int     `json:"level"`
+	CurrentHealth float32 `json:"current_health"`
+	MaxHealth     float32 `json:"max_health"`
+	PosX          int     `json:"pos_x"`
+	PosY          int     `json:"pos_y"`
+	Sprite        string  `json:"sprite"`
+}
+
+type SaveChest struct {
+	ID     uint        `json:"id"`
+	PosX   int         `json:"pos_x"`
+	PosY   int         `json:"pos_y"`
+	Opened bool        `json:"opened"`
+	Weapon *SaveWeapon `json:"weapon"`
+}
+
+func toSaveWeapon(w *model.Weapon) *SaveWeapon {
+	if w == nil {
+		return nil
+	}
+	return &SaveWeapon{ID: w.ID, Damage: w.Damage, Sprite: w.Sprite, Type: w.Type}
+}
+
+func fromSaveWeapon(w *SaveWeapon) *model.Weapon {
+	if w == nil {
+		return nil
+	}
+	return &model.Weapon{Damage: w.Damage, Sprite: w.Sprite, Type: w.Type}
+}
+
+// newSaveFile converts a fully loaded game into the current save format.
+func newSaveFile(game model.Game) SaveFile {
+	rooms := make([]SaveRoom, 0, len(game.Floor.Rooms))
+	for _, r := range game.Floor.Rooms {
+		enemies := make([]SaveEnemy, 0, len(r.Enemies))
+		for _, e := range r.Enemies {
+			enemies = append(enemies, SaveEnemy{
+				ID:            e.ID,
+				Damage:        e.Damage,
+				Level:         e.Level,
+				CurrentHealth: e.CurrentHealth,
+				MaxHealth:     e.MaxHealth,
+				PosX:          e.PosX,
+				PosY:          e.PosY,
+				Sprite:        e.Sprite,
+			})
+		}
+
+		var chest *SaveChest
+		if r.Chest != nil {
+			chest = &SaveChest{
+				ID:     r.Chest.ID,
+				PosX:   r.Chest.PosX,
+				PosY:   r.Chest.PosY,
+				Opened: r.Chest.Opened,
+				Weapon: toSaveWeapon(r.Chest.Weapon),
+			}
+		}
+
+		rooms = append(rooms, SaveRoom{
+			ID:       r.ID,
+			X:        r.X,
+			Y:        r.Y,
+			Type:     r.Type,
+			Cleared:  r.Cleared,
+			Tiles:    r.Tiles,
+			StairX:   r.StairX,
+			StairY:   r.StairY,
+			TopID:    r.TopID,
+			BottomID: r.BottomID,
+			LeftID:   r.LeftID,
+			RightID:  r.RightID,
+			Enemies:  enemies,
+			Chest:    chest,
+		})
+	}
+
+	return SaveFile{
+		Format:        SaveFileFormat,
+		SchemaVersion: SaveFileVersion,
+		ExportedAt:    time.Now().UTC(),
+		Seed:          game.Floor.Seed,
+		Config: SaveConfig{
+			Theme:      game.Floor.Theme,
+			Difficulty: game.Difficulty,
+			Level:      game.Level,
+		},
+		Game: SaveGameInfo{
+			ID:                   game.ID,
+			Version:              game.Version,
+			PlayerSpecifications: game.PlayerSpecifications,
+		},
+		Player: SavePlayer{
+			ID:              game.Player.ID,
+			MaxHealth:       game.Player.MaxHealth,
+			CurrentHealth:   game.Player.CurrentHealth,
+			SpriteName:      game.Player.SpriteName,
+			PosX:            game.Player.PosX,
+			PosY:            game.Player.PosY,
+			PrimaryWeapon:   toSaveWeapon(game.Player.PrimaryWeapon),
+			SecondaryWeapon: toSaveWeapon(game.Player.SecondaryWeapon),
+		},
+		Floor: SaveFloor{
+			ID:        game.Floor.ID,
+			StoryText: game.Floor.StoryText,
+			Theme:     game.Floor.Theme,
+			FloorMap:  game.Floor.FloorMap,
+			Adjacency: game.Floor.Adjacency,
+			Rooms:     rooms,
+		},
+	}
+}
+
+// upgradeSaveFileV1 converts a wrapped GET /game/:id document.
+func upgradeSaveFileV1(data json.RawMessage) (json.RawMessage, error) {
+	var v1 struct {
+		Game model.Game `json:"game"`
+	}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return nil, err
+	}
+	if v1.Game.Difficulty == "" {
+		v1.Game.Difficulty = "easy"
+	}
+	file := newSaveFile(v1.Game)
+	file.SchemaVersion = 2
+	return json.Marshal(file)
+}
+
+// parseSaveFile checks the format and schema version of an uploaded save
+// file, upgrading older versions step by step to the current one.
+func parseSaveFile(data []byte) (SaveFile, error) {
+	var file SaveFile
+	var header struct {
+		Format        string `json:"format"`
+		SchemaVersion int    `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return file, err
+	}
+	if header.Format != SaveFileFormat {
+		return file, fmt.Errorf("not a save file: format is %q, expected %q", header.Format, SaveFileFormat)
+	}
+	if header.SchemaVersion < 1 || header.SchemaVersion > SaveFileVersion {
+		return file, fmt.Errorf("unsupported schema version %d (this server reads 1 to %d)", header.SchemaVersion, SaveFileVersion)
+	}
+
+	for v := header.SchemaVersion; v < SaveFileVersion; v++ {
+		upgraded, err := saveFileUpgrades[v](data)
+		if err != nil {
+			return file, fmt.Errorf("upgrading schema version %d: %w", v, err)
+		}
+		data = upgraded
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, err
+	}
+	return file, validateSaveFile(file)
+}
+
+// validateSaveFile checks that a save file describes a playable game and
+// that every room reference points at a room in the file. Its settings are
+// checked field by field in ImportGame.
+func validateSaveFile(file SaveFile) error {
+	if len(file.Floor.Rooms) == 0 {
+		return errors.New("floor has no rooms")
+	}
+
+	rooms := make(map[uint]bool, len(file.Floor.Rooms))
+	for _, r := range file.Floor.Rooms {
+		if rooms[r.ID] {
+			return fmt.Errorf("room %d appears twice", r.ID)
+		}
+		rooms[r.ID] = true
+	}
+
+	for _, r := range file.Floor.Rooms {
+		if r.Tiles.Width() != model.RoomWidth || r.Tiles.Height() != model.RoomHeight {
+			return fmt.Errorf("room %d: tiles must be %dx%d", r.ID, model.RoomWidth, model.RoomHeight)
+		}
+		for _, ref := range []*uint{r.TopID, r.BottomID, r.LeftID, r.RightID} {
+			if ref != nil && !rooms[*ref] {
+				return fmt.Errorf("room %d: links to unknown room %d", r.ID, *ref)
+			}
+		}
+	}
+
+	p := file.Player
+	if p.PosX < 0 || p.PosX >= model.RoomWidth || p.PosY < 0 || p.PosY >= model.RoomHeight {
+		return errors.New("player position is outside the room")
+	}
+	if p.CurrentHealth > p.MaxHealth {
+		return errors.New("player health exceeds its maximum")
+	}
+	return nil
+}
+
+// importSaveFile creates a new game for userID from a validated save file.
+// Every entity gets a new ID; room links are remapped to the new rooms.
+func importSaveFile(tx *gorm.DB, file SaveFile, userID uint) (uint, error) {
+	player := model.Player{
+		MaxHealth:       file.Player.MaxHealth,
+		CurrentHealth:   file.Player.CurrentHealth,
+		SpriteName:      file.Player.SpriteName,
+		PosX:            file.Player.PosX,
+		PosY:            file.Player.PosY,
+		PrimaryWeapon:   fromSaveWeapon(file.Player.PrimaryWeapon),
+		SecondaryWeapon: fromSaveWeapon(file.Player.SecondaryWeapon),
+	}
+	if err := tx.Create(&player).Error; err != nil {
+		return 0, err
+	}
+
+	floor := model.Floor{
+		StoryText: file.Floor.StoryText,
+		Theme:     file.Floor.Theme,
+		FloorMap:  file.Floor.FloorMap,
+		Adjacency: file.Floor.Adjacency,
+		Seed:      file.Seed,
+	}
+	if err := tx.Create(&floor).Error; err != nil {
+		return 0, err
+	}
+
+	newIDs := make(map[uint]uint, len(file.Floor.Rooms))
+	rooms := make([]model.Room, len(file.Floor.Rooms))
+	for i, r := range file.Floor.Rooms {
+		room := model.Room{
+			FloorID: &floor.ID,
+			Type:    r.Type,
+			Cleared: r.Cleared,
+			Tiles:   r.Tiles,
+			StairX:  r.StairX,
+			StairY:  r.StairY,
+			X:       r.X,
+			Y:       r.Y,
+		}
+		if r.Chest != nil {
+			room.Chest = &model.Chest{
+				PosX:   r.Chest.PosX,
+				PosY:   r.Chest.PosY,
+				Opened: r.Chest.Opened,
+				Weapon: fromSaveWeapon(r.Chest.Weapon),
+			}
+		}
+		for _, e := range r.Enemies {
+			room.Enemies = append(room.Enemies, model.Enemy{
+				Damage:        e.Damage,
+				Level:         e.Level,
+				CurrentHealth: e.CurrentHealth,
+				MaxHealth:     e.MaxHealth,
+				PosX:          e.PosX,
+				PosY:          e.PosY,
+				Sprite:        e.Sprite,
+			})
+		}
+		if err := tx.Create(&room).Error; err != nil {
+			return 0, err
+		}
+		if room.Chest != nil {
+			if err := tx.Model(room.Chest).Update("room_in_id", room.ID).Error; err != nil {
+				return 0, err
+			}
+		}
+		newIDs[r.ID] = room.ID
+		rooms[i] = room
+	}
+
+	for i, r := range file.Floor.Rooms {
+		if err := tx.Model(&rooms[i]).Updates(roomLinks(r, newIDs)).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	game := model.Game{
+		Level:                file.Config.Level,
+		FloorID:              floor.ID,
+		PlayerSpecifications: file.Game.PlayerSpecifications,
+		PlayerID:             player.ID,
+		UserID:               userID,
+		Difficulty:           file.Config.Difficulty,
+		Version:              1,
+	}
+	if err := tx.Omit("Floor", "Player").Create(&game).Error; err != nil {
+		return 0, err
+	}
+	return game.ID, nil
+}
+
+// roomLinks is the update pointing an imported room at its neighbours' new
+// IDs. newIDs maps the IDs in the save file to the created rooms.
+func roomLinks(r SaveRoom, newIDs map[uint]uint) map[string]interface{} {
+	remap := func(old *uint) *uint {
+		if old == nil {
+			return nil
+		}
+		id := newIDs[*old]
+		return &id
+	}
+	return map[string]interface{}{
+		"top_id":    remap(r.TopID),
+		"bottom_id": remap(r.BottomID),
+		"left_id":   remap(r.LeftID),
+		"right_id":  remap(r.RightID),
+	}
+}
+
+// UserGameData is everything stored about one of a user's games.
+type UserGameData struct {
+	SaveFile  SaveFile      `json:"save_file"`
+	Snapshots []SnapshotDTO `json:"snapshots"`
+}
+
+// UserGames collects every game of a user with its snapshot history, for
+// personal data exports.
+func UserGames(db *gorm.DB, userID uint) ([]UserGameData, error) {
+	var gameIDs []uint
+	if err := db.Model(&model.Game{}).Where("user_id = ?", userID).Order("id").Pluck("id", &gameIDs).Error; err != nil {
+		return nil, err
+	}
+
+	games := make([]UserGameData, 0, len(gameIDs))
+	for _, id := range gameIDs {
+		game, err := loadGame(db, id)
+		if err != nil {
+			return nil, err
+		}
+		var snapshots []model.GameSnapshot
+		if err := db.Omit("state").Where("game_id = ?", id).Order("id DESC").Find(&snapshots).Error; err != nil {
+			return nil, err
+		}
+		dtos := make([]SnapshotDTO, 0, len(snapshots))
+		for _, s := range snapshots {
+			dtos = append(dtos, toSnapshotDTO(s))
+		}
+		games = append(games, UserGameData{SaveFile: newSaveFile(game), Snapshots: dtos})
+	}
+	return games, nil
+}
+
+// ExportGame downloads a game as a portable save file.
+func ExportGame(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owned, ok := ownedGame(c, db)
+		if !ok {
+			return
+		}
+		game, err := loadGame(db, owned.ID)
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"the-last-game-%d.json\"", game.ID))
+		c.JSON(http.StatusOK, newSaveFile(game))
+	}
+}
+
+// ImportGame creates a new game for the requesting user from an uploaded
+// save file of any supported schema version.
+func ImportGame(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSaveFileSize)
+		data, err := c.GetRawData()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+				fmt.Sprintf("Save files may be at most %d bytes", maxSaveFileSize))
+			return
+		}
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body")
+			return
+		}
+		file, err := parseSaveFile(data)
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidSaveFile, "Invalid save file", err.Error())
+			return
+		}
+		settings := gameSettings{Theme: file.Floor.Theme, Difficulty: file.Config.Difficulty, Level: file.Config.Level}
+		if !settings.validate().renamed(map[string]string{
+			"theme":      "floor.theme",
+			"difficulty": "config.difficulty",
+			"level":      "config.level",
+		}).check(c) {
+			return
+		}
+
+		userID := c.MustGet("userID").(uint)
+		if !entitlements.CheckSaveSlot(c, db, userID) ||
+			!entitlements.CheckGameOptions(c, db, userID, file.Config.Difficulty, file.Floor.Theme) {
+			return
+		}
+		var gameID uint
+		err = db.Transaction(func(tx *gorm.DB) error {
+			id, err := importSaveFile(tx, file, userID)
+			if err != nil {
+				return err
+			}
+			gameID = id
+			return recordSnapshot(tx, gameID, SnapshotImport)
+		})
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to import game", err.Error())
+			return
+		}
+
+		game, err := loadGame(db, gameID)
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+		c.Header("ETag", gameETag(game.Version))
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "game imported successfully",
+			"game":    game,
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package game_manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/apierror"
+	"backend/model"
+
+	"gorm.io/gorm"
+)
+
+func testTiles() model.TileGrid {
+	tiles := make(model.TileGrid, model.RoomHeight)
+	for y := range tiles {
+		tiles[y] = strings.Repeat(".", model.RoomWidth)
+	}
+	return tiles
+}
+
+func uintPtr(v uint) *uint { return &v }
+
+// testGame is a loaded two-room game whose rooms link to each other.
+func testGame() model.Game {
+	return model.Game{
+		Model: gorm.Model{ID: 7},
+		Level: 3,
+		Player: model.Player{
+			Model:         gorm.Model{ID: 4},
+			MaxHealth:     100,
+			CurrentHealth: 80,
+			PosX:          2,
+			PosY:          3,
+		},
+		Floor: model.Floor{
+			Theme: "jungle",
+			Rooms: []model.Room{
+				{Model: gorm.Model{ID: 11}, Tiles: testTiles(), RightID: uintPtr(12)},
+				{Model: gorm.Model{ID: 12}, Tiles: testTiles(), LeftID: uintPtr(11)},
+			},
+		},
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseSaveFileUpgradesV1(t *testing.T) {
+	data := mustJSON(t, map[string]interface{}{
+		"format":         SaveFileFormat,
+		"schema_version": 1,
+		"game":           testGame(),
+	})
+	file, err := parseSaveFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.SchemaVersion != SaveFileVersion {
+		t.Errorf("schema_version = %d; want %d", file.SchemaVersion, SaveFileVersion)
+	}
+	// Version 1 games predate difficulties
+	if file.Config.Difficulty != "easy" || file.Config.Level != 3 || file.Config.Theme != "jungle" {
+		t.Errorf("config = %+v", file.Config)
+	}
+	if len(file.Floor.Rooms) != 2 || file.Floor.Rooms[0].RightID == nil || *file.Floor.Rooms[0].RightID != 12 {
+		t.Errorf("rooms = %+v", file.Floor.Rooms)
+	}
+	if file.Player.CurrentHealth != 80 {
+		t.Errorf("player = %+v", file.Player)
+	}
+}
+
+func TestParseSaveFileRejects(t *testing.T) {
+	valid := newSaveFile(testGame())
+	valid.Config.Difficulty = "medium"
+
+	tests := []struct {
+		name   string
+		edit   func(file map[string]interface{})
+		within string
+	}{
+		{"wrong format", func(f map[string]interface{}) { f["format"] = "another-game/save" }, "not a save file"},
+		{"missing format", func(f map[string]interface{}) { delete(f, "format") }, "not a save file"},
+		{"newer version", func(f map[string]interface{}) { f["schema_version"] = SaveFileVersion + 1 }, "unsupported schema version"},
+		{"unknown version", func(f map[string]interface{}) { f["schema_version"] = 0 }, "unsupported schema version"},
+		{"dangling room reference", func(f map[string]interface{}) {
+			rooms := f["floor"].(map[string]interface{})["rooms"].([]interface{})
+			rooms[0].(map[string]interface{})["right_id"] = 99
+		}, "links to unknown room 99"},
+		{"duplicate room", func(f map[string]interface{}) {
+			rooms := f["floor"].(map[string]interface{})["rooms"].([]interface{})
+			rooms[1].(map[string]interface{})["id"] = 11
+		}, "appears twice"},
+		{"no rooms", func(f map[string]interface{}) { f["floor"].(map[string]interface{})["rooms"] = []interface{}{} }, "no rooms"},
+		{"player outside room", func(f map[string]interface{}) { f["player"].(map[string]interface{})["pos_x"] = model.RoomWidth }, "outside the room"},
+	}
+
+	if _, err := parseSaveFile(mustJSON(t, valid)); err != nil {
+		t.Fatalf("valid save file rejected: %v", err)
+	}
+	for _, tt := range tests {
+		var file map[string]interface{}
+		if err := json.Unmarshal(mustJSON(t, valid), &file); err != nil {
+			t.Fatal(err)
+		}
+		tt.edit(file)
+		_, err := parseSaveFile(mustJSON(t, file))
+		if err == nil || !strings.Contains(err.Error(), tt.within) {
+			t.Errorf("%s: error = %v; want one mentioning %q", tt.name, err, tt.within)
+		}
+	}
+}
+
+func TestRoomLinks(t *testing.T) {
+	room := SaveRoom{ID: 11, TopID: uintPtr(12), LeftID: uintPtr(13)}
+	links := roomLinks(room, map[uint]uint{11: 101, 12: 102, 13: 103})
+
+	want := map[string]*uint{"top_id": uintPtr(102), "left_id": uintPtr(103), "bottom_id": nil, "right_id": nil}
+	for column, id := range want {
+		got := links[column].(*uint)
+		if (got == nil) != (id == nil) || (got != nil && *got != *id) {
+			t.Errorf("%s = %v; want %v", column, got, id)
+		}
+	}
+}
+
+func TestImportGameRejectsLargeBodies(t *testing.T) {
+	body := `{"format": "` + strings.Repeat("x", maxSaveFileSize) + `"}`
+	w := serve(http.MethodPost, "/games/import", "/games/import", body, ImportGame(nil))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d; want 413", w.Code)
+	}
+	if code, _ := errorOf(t, w); code != apierror.CodeBodyTooLarge {
+		t.Errorf("code = %s; want %s", code, apierror.CodeBodyTooLarge)
+	}
+}
+
+func TestImportGameReportsInvalidSettings(t *testing.T) {
+	file := newSaveFile(testGame())
+	file.Config.Difficulty = "nightmare"
+	file.Config.Level = 0
+	file.Floor.Theme = "space"
+
+	w := serve(http.MethodPost, "/games/import", "/games/import", string(mustJSON(t, file)), ImportGame(nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", w.Code)
+	}
+	code, fields := errorOf(t, w)
+	if code != apierror.CodeInvalidRequest || len(fields) != 3 ||
+		!fields["config.difficulty"] || !fields["config.level"] || !fields["floor.theme"] {
+		t.Errorf("code = %s, fields = %v; want %s on config.difficulty, config.level and floor.theme", code, fields, apierror.CodeInvalidRequest)
+	}
+}
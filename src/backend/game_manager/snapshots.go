@@ -0,0 +1,224 @@
+package game_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons recorded on a snapshot. Patches are not snapshotted: they arrive
+// on every move and would push the saves out of the retention limit.
+const (
+	SnapshotSave    = "save"
+	SnapshotRestore = "restore"
+	SnapshotImport  = "import"
+)
+
+// defaultSnapshotRetention is how many snapshots are kept per game unless
+// GAME_SNAPSHOT_RETENTION says otherwise.
+const defaultSnapshotRetention = 20
+
+type SnapshotDTO struct {
+	ID        uint      `json:"id"`
+	GameID    uint      `json:"game_id"`
+	Version   uint      `json:"version"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func snapshotRetention() int {
+	if n, err := strconv.Atoi(os.Getenv("GAME_SNAPSHOT_RETENTION")); err == nil && n > 0 {
+		return n
+	}
+	return defaultSnapshotRetention
+}
+
+// recordSnapshot stores the current state of a game and drops the oldest
+// snapshots beyond the retention limit. It runs inside the transaction that
+// changed the game, so the snapshot always matches what was committed.
+func recordSnapshot(tx *gorm.DB, gameID uint, reason string) error {
+	game, err := loadGame(tx, gameID)
+	if err != nil {
+		return err
+	}
+	state, err := json.Marshal(game)
+	if err != nil {
+		return err
+	}
+
+	snapshot := model.GameSnapshot{
+		GameID:  game.ID,
+		Version: game.Version,
+		Reason:  reason,
+		State:   state,
+	}
+	if err := tx.Create(&snapshot).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&model.GameSnapshot{}).
+		Select("id").
+		Where("game_id = ?", gameID).
+		Order("id DESC").
+		Limit(snapshotRetention())
+	return tx.Unscoped().
+		Where("game_id = ? AND id NOT IN (?)", gameID, keep).
+		Delete(&model.GameSnapshot{}).Error
+}
+
+// ownedGame checks that the game exists and belongs to the requesting user,
+// writing the error response when it does not.
+func ownedGame(c *gin.Context, db *gorm.DB) (model.Game, bool) {
+	var game model.Game
+	id, ok := paramID(c, "id", "game")
+	if !ok {
+		return game, false
+	}
+	if err := db.Select("id", "user_id", "version").First(&game, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeGameNotFound, "Game not found")
+		} else {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+		}
+		return game, false
+	}
+	if game.UserID != c.MustGet("userID").(uint) {
+		apierror.Abort(c, http.StatusForbidden, apierror.CodeNotOwner, "You do not own this game")
+		return game, false
+	}
+	return game, true
+}
+
+// findSnapshot loads the snapshot named in the URL for the given game.
+func findSnapshot(c *gin.Context, db *gorm.DB, gameID uint) (model.GameSnapshot, bool) {
+	var snapshot model.GameSnapshot
+	id, ok := paramID(c, "snapshotId", "snapshot")
+	if !ok {
+		return snapshot, false
+	}
+	if err := db.Where("game_id = ?", gameID).First(&snapshot, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeSnapshotNotFound, "Snapshot not found")
+		} else {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+		}
+		return snapshot, false
+	}
+	return snapshot, true
+}
+
+func toSnapshotDTO(s model.GameSnapshot) SnapshotDTO {
+	return SnapshotDTO{
+		ID:        s.ID,
+		GameID:    s.GameID,
+		Version:   s.Version,
+		Reason:    s.Reason,
+		CreatedAt: s.CreatedAt,
+	}
+}
+
+// ListSnapshots returns the snapshots kept for a game, newest first.
+func ListSnapshots(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := ownedGame(c, db)
+		if !ok {
+			return
+		}
+
+		var snapshots []model.GameSnapshot
+		if err := db.Omit("state").Where("game_id = ?", game.ID).Order("id DESC").Find(&snapshots).Error; err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to fetch snapshots")
+			return
+		}
+
+		dtos := make([]SnapshotDTO, 0, len(snapshots))
+		for _, s := range snapshots {
+			dtos = append(dtos, toSnapshotDTO(s))
+		}
+		c.JSON(http.StatusOK, gin.H{"snapshots": dtos})
+	}
+}
+
+// GetSnapshot returns a single snapshot including the saved game state.
+func GetSnapshot(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := ownedGame(c, db)
+		if !ok {
+			return
+		}
+		snapshot, ok := findSnapshot(c, db, game.ID)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"snapshot": toSnapshotDTO(snapshot),
+			"game":     snapshot.State,
+		})
+	}
+}
+
+// RestoreSnapshot writes the state held in a snapshot back over the game.
+// The restore is itself a new version, so it can be undone by restoring an
+// earlier snapshot.
+func RestoreSnapshot(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		game, ok := ownedGame(c, db)
+		if !ok {
+			return
+		}
+		snapshot, ok := findSnapshot(c, db, game.ID)
+		if !ok {
+			return
+		}
+
+		var restored model.Game
+		if err := json.Unmarshal(snapshot.State, &restored); err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeSnapshotCorrupt, "Snapshot is corrupt", err.Error())
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var current model.Game
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "user_id", "version").
+				First(&current, game.ID).Error; err != nil {
+				return err
+			}
+
+			restored.ID = current.ID
+			restored.UserID = current.UserID
+			restored.Version = current.Version + 1
+			stampVersion(&restored)
+			if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(&restored).Error; err != nil {
+				return err
+			}
+			return recordSnapshot(tx, restored.ID, SnapshotRestore)
+		})
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to restore snapshot", err.Error())
+			return
+		}
+
+		current, err := loadGame(db, game.ID)
+		if err != nil {
+			apierror.AbortWithDetails(c, http.StatusInternalServerError, apierror.CodeInternal, "Database error", err.Error())
+			return
+		}
+		c.Header("ETag", gameETag(current.Version))
+		c.JSON(http.StatusOK, gin.H{
+			"message": "game restored successfully",
+			"game":    current,
+		})
+	}
+}
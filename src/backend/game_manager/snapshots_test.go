@@ -0,0 +1,262 @@
+package game_manager
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/apierror"
+	"backend/dbtest"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// snapshotStore is a fake database holding game 9 of user 4 and its
+// snapshots.
+type snapshotStore struct {
+	mu        sync.Mutex
+	game      map[string]driver.Value
+	snapshots []storedSnapshot
+	nextID    int64
+}
+
+type storedSnapshot struct {
+	id      int64
+	version int64
+	reason  string
+	state   string
+}
+
+var gameColumns = []string{"id", "user_id", "version", "level", "difficulty", "floor_id", "player_id"}
+
+func newSnapshotStore(t *testing.T) (*gorm.DB, *snapshotStore) {
+	s := &snapshotStore{game: map[string]driver.Value{
+		"id": int64(9), "user_id": int64(4), "version": int64(5), "level": int64(3),
+		"difficulty": "hard", "floor_id": int64(0), "player_id": int64(0),
+	}}
+	db, fake := dbtest.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.Contains(query, `FROM "games"`):
+			if args[0] != s.game["id"] {
+				return nil, nil
+			}
+			row := make([]driver.Value, len(gameColumns))
+			for i, column := range gameColumns {
+				row[i] = s.game[column]
+			}
+			return gameColumns, [][]driver.Value{row}
+		case strings.HasPrefix(query, `INSERT INTO "game_snapshots"`):
+			row := dbtest.Inserted(query, args)
+			s.nextID++
+			s.snapshots = append(s.snapshots, storedSnapshot{
+				id:      s.nextID,
+				version: row["version"].(int64),
+				reason:  row["reason"].(string),
+				state:   row["state"].(string),
+			})
+			return []string{"id"}, [][]driver.Value{{s.nextID}}
+		case strings.Contains(query, `FROM "game_snapshots" WHERE game_id = $1 AND "game_snapshots"."id" = $2`):
+			for _, snap := range s.snapshots {
+				if args[0] == s.game["id"] && snap.id == args[1] {
+					return []string{"id", "game_id", "version", "reason", "state"},
+						[][]driver.Value{{snap.id, args[0], snap.version, snap.reason, snap.state}}
+				}
+			}
+		case strings.Contains(query, `FROM "game_snapshots" WHERE game_id = $1`):
+			if strings.Contains(query, `"state"`) {
+				t.Errorf("listing snapshots loads their state: %s", query)
+			}
+			var rows [][]driver.Value
+			for i := len(s.snapshots) - 1; i >= 0; i-- {
+				snap := s.snapshots[i]
+				rows = append(rows, []driver.Value{snap.id, args[0], snap.version, snap.reason})
+			}
+			return []string{"id", "game_id", "version", "reason"}, rows
+		}
+		return nil, nil
+	})
+	fake.OnExec(func(query string, args []driver.Value) int64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case strings.HasPrefix(query, `DELETE FROM "game_snapshots"`) && strings.Contains(query, "ORDER BY id DESC LIMIT $3"):
+			keep := int(args[2].(int64))
+			if dropped := len(s.snapshots) - keep; dropped > 0 {
+				s.snapshots = s.snapshots[dropped:]
+				return int64(dropped)
+			}
+			return 0
+		case strings.HasPrefix(query, `UPDATE "games"`):
+			for column, value := range dbtest.Assigned(query, args) {
+				if _, ok := s.game[column]; ok {
+					s.game[column] = value
+				}
+			}
+		}
+		return 1
+	})
+	return db, s
+}
+
+func (s *snapshotStore) set(column string, value driver.Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.game[column] = value
+}
+
+func (s *snapshotStore) list() []storedSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]storedSnapshot(nil), s.snapshots...)
+}
+
+// serveSnapshot runs handler on the snapshot routes as the given user.
+func serveSnapshot(method, path string, userID uint, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	setUser := func(c *gin.Context) { c.Set("userID", userID) }
+	r.GET("/game/:id/snapshots", setUser, handler)
+	r.GET("/game/:id/snapshots/:snapshotId", setUser, handler)
+	r.POST("/game/:id/snapshots/:snapshotId/restore", setUser, handler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestRecordSnapshotPrunesOldest(t *testing.T) {
+	t.Setenv("GAME_SNAPSHOT_RETENTION", "3")
+	db, store := newSnapshotStore(t)
+
+	for version := int64(1); version <= 5; version++ {
+		store.set("version", version)
+		if err := recordSnapshot(db, 9, SnapshotSave); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots := store.list()
+	if len(snapshots) != 3 || snapshots[0].id != 3 || snapshots[2].id != 5 {
+		t.Fatalf("kept snapshots = %+v; want the newest three", snapshots)
+	}
+	latest := snapshots[2]
+	var game model.Game
+	if err := json.Unmarshal([]byte(latest.state), &game); err != nil {
+		t.Fatal(err)
+	}
+	if latest.version != 5 || latest.reason != SnapshotSave || game.ID != 9 || game.Version != 5 || game.Level != 3 {
+		t.Errorf("latest snapshot = %+v with game %+v; want version 5 of game 9", latest, game)
+	}
+}
+
+func TestSnapshotEndpoints(t *testing.T) {
+	db, store := newSnapshotStore(t)
+	store.set("level", int64(2))
+	store.set("version", int64(4))
+	if err := recordSnapshot(db, 9, SnapshotSave); err != nil {
+		t.Fatal(err)
+	}
+	store.set("level", int64(3))
+	store.set("version", int64(5))
+	if err := recordSnapshot(db, 9, SnapshotSave); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveSnapshot(http.MethodGet, "/game/9/snapshots", 4, ListSnapshots(db))
+	var list struct {
+		Snapshots []SnapshotDTO `json:"snapshots"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(list.Snapshots) != 2 || list.Snapshots[0].ID != 2 || list.Snapshots[1].Version != 4 {
+		t.Errorf("list: status = %d, snapshots = %+v; want snapshots 2 then 1", w.Code, list.Snapshots)
+	}
+
+	w = serveSnapshot(http.MethodGet, "/game/9/snapshots/1", 4, GetSnapshot(db))
+	var got struct {
+		Snapshot SnapshotDTO `json:"snapshot"`
+		Game     model.Game  `json:"game"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || got.Snapshot.ID != 1 || got.Game.Level != 2 || got.Game.Version != 4 {
+		t.Errorf("get: status = %d, body = %+v; want snapshot 1 holding level 2", w.Code, got)
+	}
+
+	errorCases := []struct {
+		name   string
+		path   string
+		userID uint
+		status int
+		code   string
+	}{
+		{"unknown snapshot", "/game/9/snapshots/99", 4, http.StatusNotFound, apierror.CodeSnapshotNotFound},
+		{"another user's game", "/game/9/snapshots/1", 5, http.StatusForbidden, apierror.CodeNotOwner},
+		{"unknown game", "/game/10/snapshots/1", 4, http.StatusNotFound, apierror.CodeGameNotFound},
+		{"invalid snapshot ID", "/game/9/snapshots/first", 4, http.StatusBadRequest, apierror.CodeInvalidID},
+	}
+	for _, tt := range errorCases {
+		for name, handler := range map[string]gin.HandlerFunc{"get": GetSnapshot(db), "restore": RestoreSnapshot(db)} {
+			method, path := http.MethodGet, tt.path
+			if name == "restore" {
+				method, path = http.MethodPost, tt.path+"/restore"
+			}
+			w := serveSnapshot(method, path, tt.userID, handler)
+			if code, _ := errorOf(t, w); w.Code != tt.status || code != tt.code {
+				t.Errorf("%s, %s: status = %d, code = %s; want %d %s", tt.name, name, w.Code, code, tt.status, tt.code)
+			}
+		}
+	}
+	if n := len(store.list()); n != 2 {
+		t.Errorf("%d snapshots after failed requests; want 2", n)
+	}
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	db, store := newSnapshotStore(t)
+	store.set("level", int64(2))
+	store.set("version", int64(4))
+	if err := recordSnapshot(db, 9, SnapshotSave); err != nil {
+		t.Fatal(err)
+	}
+	store.set("level", int64(3))
+	store.set("version", int64(5))
+
+	// The game's owner is kept whatever the snapshot says
+	store.mu.Lock()
+	tampered := strings.Replace(store.snapshots[0].state, `"UserID":4`, `"UserID":99`, 1)
+	if tampered == store.snapshots[0].state {
+		t.Fatalf("snapshot state %s does not name user 4", tampered)
+	}
+	store.snapshots[0].state = tampered
+	store.mu.Unlock()
+
+	w := serveSnapshot(http.MethodPost, "/game/9/snapshots/1/restore", 4, RestoreSnapshot(db))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"6"` {
+		t.Errorf("ETag = %s; want \"6\"", etag)
+	}
+
+	store.mu.Lock()
+	level, version, owner := store.game["level"], store.game["version"], store.game["user_id"]
+	store.mu.Unlock()
+	if level != int64(2) || version != int64(6) || owner != int64(4) {
+		t.Errorf("game = level %v, version %v, user %v; want level 2 at version 6 owned by user 4", level, version, owner)
+	}
+
+	snapshots := store.list()
+	if len(snapshots) != 2 || snapshots[1].reason != SnapshotRestore || snapshots[1].version != 6 {
+		t.Errorf("snapshots = %+v; want a restore snapshot at version 6", snapshots)
+	}
+}
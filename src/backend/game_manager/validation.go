@@ -0,0 +1,205 @@
+package game_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"backend/apierror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// Themes are the themes floors can be generated with: those the frontend has
+// a tileset for. Which of them a user may pick depends on their plan; see
+// entitlements.IsPremiumTheme.
+var Themes = []string{"castle", "jungle", "desert"}
+
+// Difficulties are the difficulties a game can be played at.
+var Difficulties = []string{"easy", "medium", "hard"}
+
+// difficultyMultipliers scale the health and damage of generated enemies.
+var difficultyMultipliers = map[string]float32{
+	"easy":   1.0,
+	"medium": 1.5,
+	"hard":   2.0,
+}
+
+// MaxLevel is the deepest floor a game can reach.
+const MaxLevel = 100
+
+// noPreviousFloor is what the generator is told about the previous floor's
+// theme and story when there is none.
+const noPreviousFloor = "None"
+
+// fieldErrors collects the invalid fields of a request.
+type fieldErrors []apierror.FieldError
+
+func (e *fieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, apierror.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// oneOf checks that a required value is one of allowed.
+func (e *fieldErrors) oneOf(field, value string, allowed []string) {
+	if value == "" {
+		e.add(field, "is required")
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	e.add(field, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+// between checks that value is within [min, max].
+func (e *fieldErrors) between(field string, value, min, max int) {
+	if value < min || value > max {
+		e.add(field, "must be between %d and %d", min, max)
+	}
+}
+
+// renamed returns the errors with their fields renamed through names, for
+// checks shared by requests that carry the same values under other names.
+func (e fieldErrors) renamed(names map[string]string) fieldErrors {
+	out := make(fieldErrors, len(e))
+	for i, fe := range e {
+		if name, ok := names[fe.Field]; ok {
+			fe.Field = name
+		}
+		out[i] = fe
+	}
+	return out
+}
+
+// check writes a 400 response listing the errors, if there are any, and
+// reports whether there were none.
+func (e fieldErrors) check(c *gin.Context) bool {
+	if len(e) == 0 {
+		return true
+	}
+	apierror.AbortWithFields(c, e)
+	return false
+}
+
+// validatable is implemented by requests that check their fields once bound.
+type validatable interface {
+	validate() fieldErrors
+}
+
+// bindJSON binds the request body into req and validates it, writing a 400
+// response listing the invalid fields and returning false when it is not a
+// valid request.
+func bindJSON(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		apierror.AbortWithFields(c, bindingErrors(req, err))
+		return false
+	}
+	if v, ok := req.(validatable); ok {
+		return v.validate().check(c)
+	}
+	return true
+}
+
+// bindingErrors describes why a body could not be bound, by field where the
+// error allows it.
+func bindingErrors(req interface{}, err error) fieldErrors {
+	var errs fieldErrors
+	var typeErr *json.UnmarshalTypeError
+	var invalid validator.ValidationErrors
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		errs.add(typeErr.Field, "must be a %s", jsonType(typeErr.Type))
+	case errors.As(err, &invalid):
+		for _, fe := range invalid {
+			field := jsonName(reflect.TypeOf(req), fe.StructField())
+			if fe.Tag() == "required" {
+				errs.add(field, "is required")
+			} else {
+				errs.add(field, "is invalid")
+			}
+		}
+	default:
+		errs.add("body", "is not valid JSON")
+	}
+	return errs
+}
+
+// jsonName is the JSON name of a field of the struct t points to.
+func jsonName(t reflect.Type, field string) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if f, ok := t.FieldByName(field); ok {
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return field
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// paramID parses the path parameter name as the ID of a what, such as
+// "game", writing a 400 response when it is not a positive integer.
+func paramID(c *gin.Context, name, what string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		apierror.AbortWithDetails(c, http.StatusBadRequest, apierror.CodeInvalidID, "Invalid "+what+" ID",
+			gin.H{"fields": []apierror.FieldError{{Field: name, Message: "must be a positive integer"}}})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (config GameConfig) validate() fieldErrors {
+	var errs fieldErrors
+	errs.oneOf("theme", config.Theme, Themes)
+	errs.oneOf("difficulty", config.Difficulty, Difficulties)
+	return errs
+}
+
+// gameSettings are the theme, difficulty and level every stored game must
+// have valid values for, however it was created.
+type gameSettings struct {
+	Theme      string
+	Difficulty string
+	Level      int
+}
+
+func (g gameSettings) validate() fieldErrors {
+	var errs fieldErrors
+	errs.oneOf("theme", g.Theme, Themes)
+	errs.oneOf("difficulty", g.Difficulty, Difficulties)
+	errs.between("level", g.Level, 1, MaxLevel)
+	return errs
+}
+
+func (config FloorConfig) validate() fieldErrors {
+	errs := gameSettings{Theme: config.Theme, Difficulty: config.Difficulty, Level: config.Level}.validate()
+	if config.LastTheme != "" && config.LastTheme != noPreviousFloor {
+		errs.oneOf("lastTheme", config.LastTheme, Themes)
+	}
+	return errs
+}
@@ -0,0 +1,175 @@
+package game_manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/apierror"
+	"backend/entitlements"
+
+	"github.com/gin-gonic/gin"
+)
+
+func fieldsOf(errs fieldErrors) map[string]bool {
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	return fields
+}
+
+func TestGameConfigValidate(t *testing.T) {
+	if errs := (GameConfig{Theme: "desert", Difficulty: "hard"}).validate(); len(errs) != 0 {
+		t.Errorf("valid config rejected: %v", errs)
+	}
+	errs := GameConfig{Theme: "space", Difficulty: ""}.validate()
+	if fields := fieldsOf(errs); len(errs) != 2 || !fields["theme"] || !fields["difficulty"] {
+		t.Errorf("errors = %v; want theme and difficulty", errs)
+	}
+}
+
+func TestFloorConfigValidate(t *testing.T) {
+	// The first floor has no previous story or theme
+	if errs := (FloorConfig{Theme: "castle", Difficulty: "easy", Level: 1}).validate(); len(errs) != 0 {
+		t.Errorf("first floor rejected: %v", errs)
+	}
+	if errs := (FloorConfig{Theme: "castle", Difficulty: "easy", Level: 2, LastStory: "...", LastTheme: "desert"}).validate(); len(errs) != 0 {
+		t.Errorf("valid config rejected: %v", errs)
+	}
+
+	invalid := []struct {
+		field  string
+		config FloorConfig
+	}{
+		{"level", FloorConfig{Theme: "castle", Difficulty: "easy", Level: 0}},
+		{"level", FloorConfig{Theme: "castle", Difficulty: "easy", Level: MaxLevel + 1}},
+		{"lastTheme", FloorConfig{Theme: "castle", Difficulty: "easy", Level: 2, LastTheme: "space"}},
+	}
+	for _, tt := range invalid {
+		errs := tt.config.validate()
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("%+v: errors = %v; want %s", tt.config, errs, tt.field)
+		}
+	}
+}
+
+func serve(method, route, path, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, route, handler)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func errorOf(t *testing.T, w *httptest.ResponseRecorder) (string, map[string]bool) {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				Fields []apierror.FieldError `json:"fields"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Error.Code, fieldsOf(body.Error.Details.Fields)
+}
+
+func TestParamIDRejectsMalformedIDs(t *testing.T) {
+	for _, id := range []string{"abc", "0", "-1", "1.5"} {
+		w := serve(http.MethodGet, "/room/:id", "/room/"+id, "", GetRoomHandler)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("/room/%s: status = %d; want 400", id, w.Code)
+			continue
+		}
+		if code, fields := errorOf(t, w); code != apierror.CodeInvalidID || !fields["id"] {
+			t.Errorf("/room/%s: code = %s, fields = %v; want %s on id", id, code, fields, apierror.CodeInvalidID)
+		}
+	}
+}
+
+func TestBindJSONReportsFields(t *testing.T) {
+	tests := []struct {
+		body   string
+		fields []string
+	}{
+		{`{"theme": "space", "difficulty": "hard"}`, []string{"theme"}},
+		{`{"theme": "castle", "difficulty": 3}`, []string{"difficulty"}},
+		{`{`, []string{"body"}},
+	}
+	for _, tt := range tests {
+		w := serve(http.MethodPost, "/create_game", "/create_game", tt.body, CreateGame)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d; want 400", tt.body, w.Code)
+			continue
+		}
+		code, fields := errorOf(t, w)
+		if code != apierror.CodeInvalidRequest || len(fields) != len(tt.fields) {
+			t.Errorf("%s: code = %s, fields = %v; want %s on %v", tt.body, code, fields, apierror.CodeInvalidRequest, tt.fields)
+			continue
+		}
+		for _, f := range tt.fields {
+			if !fields[f] {
+				t.Errorf("%s: fields = %v; want %v", tt.body, fields, tt.fields)
+			}
+		}
+	}
+}
+
+func TestFloorConfigReportsEveryField(t *testing.T) {
+	w := serve(http.MethodPost, "/create_floor", "/create_floor", `{"level": 0, "lastTheme": "space"}`, CreateFloor)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", w.Code)
+	}
+	_, fields := errorOf(t, w)
+	for _, f := range []string{"theme", "difficulty", "level", "lastTheme"} {
+		if !fields[f] {
+			t.Errorf("fields = %v; want %s among them", fields, f)
+		}
+	}
+}
+
+// TestPremiumThemesArePlayable checks that the premium themes entitlement
+// gates some, but not all, of the themes the game can be played with.
+func TestPremiumThemesArePlayable(t *testing.T) {
+	premium := 0
+	for _, theme := range Themes {
+		if entitlements.IsPremiumTheme(theme) {
+			premium++
+		}
+	}
+	if premium == 0 || premium == len(Themes) {
+		t.Errorf("%d of %d themes are premium; want some but not all", premium, len(Themes))
+	}
+}
+
+// TestSaveGameValidatesNewGames checks that the first save of a game, which
+// creates it, is held to the same settings as a generated game.
+func TestSaveGameValidatesNewGames(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/save_game", func(c *gin.Context) { c.Set("userID", uint(1)) }, SaveGame(nil))
+	w := httptest.NewRecorder()
+	body := `{"game": {"Level": 0, "Difficulty": "nightmare", "Floor": {"Theme": "space"}}}`
+	req := httptest.NewRequest(http.MethodPost, "/save_game", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", w.Code)
+	}
+	_, fields := errorOf(t, w)
+	for _, f := range []string{"game.Level", "game.Difficulty", "game.Floor.Theme"} {
+		if !fields[f] {
+			t.Errorf("fields = %v; want %s among them", fields, f)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package game_manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/apierror"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errMissingVersion = errors.New("missing If-Match header or version field")
+
+// gameETag is the entity tag for a game at the given version.
+func gameETag(version uint) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// parseETag extracts the version from an entity tag, accepting weak tags.
+func parseETag(tag string) (uint, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	v, err := strconv.ParseUint(strings.Trim(tag, "\""), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity tag %q", tag)
+	}
+	return uint(v), nil
+}
+
+// expectedVersion returns the game version the client last saw, taken from
+// the If-Match header or, failing that, from the version in the request body.
+func expectedVersion(c *gin.Context, bodyVersion *uint) (uint, error) {
+	if header := c.GetHeader("If-Match"); header != "" {
+		return parseETag(header)
+	}
+	if bodyVersion != nil {
+		return *bodyVersion, nil
+	}
+	return 0, errMissingVersion
+}
+
+// abortVersionError writes the response for an error from expectedVersion:
+// 428 when the client sent no version, 400 when it sent one that could not
+// be parsed.
+func abortVersionError(c *gin.Context, err error) {
+	if errors.Is(err, errMissingVersion) {
+		apierror.Abort(c, http.StatusPreconditionRequired, apierror.CodeVersionRequired, err.Error())
+		return
+	}
+	apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid If-Match header: "+err.Error())
+}
+
+// etagMatches reports whether an If-None-Match header matches the given tag.
+func etagMatches(header string, version uint) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if v, err := parseETag(tag); err == nil && v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// stampVersion copies the game's version onto the floor and player sent with
+// it. Associations the client left out are not touched, so they are not
+// mistaken for new records on save.
+func stampVersion(game *model.Game) {
+	if game.Floor.ID != 0 || len(game.Floor.Rooms) > 0 {
+		game.Floor.Version = game.Version
+	}
+	if game.Player.ID != 0 {
+		game.Player.Version = game.Version
+	}
+}
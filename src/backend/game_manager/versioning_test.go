@@ -0,0 +1,123 @@
+package game_manager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseETag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		version uint
+		ok      bool
+	}{
+		{`"3"`, 3, true},
+		{`W/"3"`, 3, true},
+		{` "12" `, 12, true},
+		{`3`, 3, true},
+		{`abc`, 0, false},
+		{`"abc"`, 0, false},
+		{`*`, 0, false},
+		{`"1", "3"`, 0, false},
+		{`"-1"`, 0, false},
+	}
+	for _, tt := range tests {
+		v, err := parseETag(tt.tag)
+		if (err == nil) != tt.ok || v != tt.version {
+			t.Errorf("parseETag(%s) = %d, %v; want %d, ok = %t", tt.tag, v, err, tt.version, tt.ok)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"3"`, true},
+		{`W/"3"`, true},
+		{`*`, true},
+		{`"1", "3"`, true},
+		{`"1",W/"3"`, true},
+		{`"4"`, false},
+		{`"1", "2"`, false},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, 3); got != tt.want {
+			t.Errorf("etagMatches(%s, 3) = %t; want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestExpectedVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		body    *uint
+		version uint
+		err     error
+	}{
+		{"header", `"5"`, nil, 5, nil},
+		{"weak header", `W/"5"`, nil, 5, nil},
+		{"header wins over body", `"5"`, uintPtr(4), 5, nil},
+		{"body", "", uintPtr(4), 4, nil},
+		{"missing", "", nil, 0, errMissingVersion},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+		if tt.header != "" {
+			c.Request.Header.Set("If-Match", tt.header)
+		}
+		v, err := expectedVersion(c, tt.body)
+		if v != tt.version || !errors.Is(err, tt.err) {
+			t.Errorf("%s: expectedVersion = %d, %v; want %d, %v", tt.name, v, err, tt.version, tt.err)
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+	c.Request.Header.Set("If-Match", "abc")
+	if _, err := expectedVersion(c, uintPtr(4)); err == nil || errors.Is(err, errMissingVersion) {
+		t.Errorf("malformed header: error = %v; want a parse error", err)
+	}
+}
+
+func TestPatchGameVersionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		code    string
+	}{
+		{"missing version", "", http.StatusPreconditionRequired, apierror.CodeVersionRequired},
+		{"malformed If-Match", "abc", http.StatusBadRequest, apierror.CodeInvalidRequest},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		r := gin.New()
+		r.PATCH("/games/:id", PatchGame(nil))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/games/7", strings.NewReader(`{"operations": [{"op": "room_cleared", "room_id": 1}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d; want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if code, _ := errorOf(t, w); code != tt.code {
+			t.Errorf("%s: code = %s; want %s", tt.name, code, tt.code)
+		}
+	}
+}
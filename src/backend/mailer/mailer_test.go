@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{
+		To:      "bob@example.com",
+		Subject: "Hello\r\nBcc: eve@example.com",
+		Body:    "line one\nline two\n",
+	}
+	got := string(format("Game <no-reply@example.com>", msg))
+
+	header, body, ok := strings.Cut(got, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no blank line between header and body in %q", got)
+	}
+	for _, want := range []string{
+		"From: Game <no-reply@example.com>\r\n",
+		"To: bob@example.com\r\n",
+		"Subject: HelloBcc: eve@example.com\r\n",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header+"\r\n", want) {
+			t.Errorf("header %q does not contain %q", header, want)
+		}
+	}
+	if strings.Contains(header, "\r\nBcc:") {
+		t.Errorf("subject added a header: %q", header)
+	}
+	if body != "line one\r\nline two\r\n" {
+		t.Errorf("body = %q; want CRLF line endings", body)
+	}
+}
+
+func TestEnvelopeAddress(t *testing.T) {
+	tests := map[string]string{
+		"Game <no-reply@example.com>": "no-reply@example.com",
+		"no-reply@example.com":        "no-reply@example.com",
+	}
+	for from, want := range tests {
+		if got := envelopeAddress(from); got != want {
+			t.Errorf("envelopeAddress(%q) = %q; want %q", from, got, want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &FileMailer{Dir: dir, From: "no-reply@example.com"}
+	if err := m.Send(context.Background(), Message{To: "../bob@example.com", Subject: "Hi", Body: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-.._bob@example.com.eml") {
+		t.Fatalf("files = %v; want one .eml file named after the recipient", files)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Subject: Hi\r\n") || !strings.HasSuffix(string(data), "\r\n\r\nHello") {
+		t.Errorf("file = %q", data)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := m.Send(context.Background(), Message{To: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := m.Messages()
+	if len(messages) != 2 || messages[0].To != "a@example.com" || messages[1].To != "b@example.com" {
+		t.Fatalf("messages = %v", messages)
+	}
+
+	// Messages returns a copy
+	messages[0].To = "changed"
+	if m.Messages()[0].To != "a@example.com" {
+		t.Error("changing the returned messages changed the mailer's")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("MAIL_FROM", "")
+	t.Setenv("MAIL_DIR", "")
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("SMTP_PORT", "")
+
+	t.Setenv("MAILER", "")
+	m, err := FromEnv()
+	if f, ok := m.(*FileMailer); err != nil || !ok || f.Dir != "mail" {
+		t.Errorf("default: FromEnv() = %#v, %v; want a FileMailer writing to mail", m, err)
+	}
+
+	t.Setenv("MAILER", "memory")
+	if m, err := FromEnv(); err != nil {
+		t.Error(err)
+	} else if _, ok := m.(*MemoryMailer); !ok {
+		t.Errorf("memory: FromEnv() = %#v; want a MemoryMailer", m)
+	}
+
+	t.Setenv("MAILER", "smtp")
+	if _, err := FromEnv(); err == nil {
+		t.Error("smtp without SMTP_HOST: FromEnv() succeeded")
+	}
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "Game <game@example.com>")
+	m, err = FromEnv()
+	if s, ok := m.(*SMTPMailer); err != nil || !ok || s.Port != "587" || s.From != "Game <game@example.com>" {
+		t.Errorf("smtp: FromEnv() = %#v, %v; want an SMTPMailer on port 587", m, err)
+	}
+
+	t.Setenv("MAILER", "pigeon")
+	if _, err := FromEnv(); err == nil {
+		t.Error("unknown MAILER: FromEnv() succeeded")
+	}
+}
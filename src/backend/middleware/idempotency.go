@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/apierror"
+	"backend/model"
+	"backend/openapi"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// IdempotencyKeyHeader names the header clients send a key in.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyTTL is how long a key and its response are kept.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL is how long a request may hold a key before another
+	// one may assume it was abandoned.
+	idempotencyLockTTL      = 10 * time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// responseRecorder keeps a copy of the response body.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// requestFingerprint identifies a request by method, path and body.
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IdempotencyStore keeps idempotency keys and the responses stored under
+// them.
+type IdempotencyStore interface {
+	// Claim stores a new key for the request, or returns the stored one when
+	// the key was used before. claimed is true when this request should run
+	// the handler.
+	Claim(record model.IdempotencyKey, now time.Time) (stored model.IdempotencyKey, claimed bool, err error)
+	// Complete stores the response set on a claimed key.
+	Complete(key model.IdempotencyKey) error
+	// Release deletes a claimed key so the request can be retried.
+	Release(key model.IdempotencyKey) error
+}
+
+// DBIdempotencyStore keeps idempotency keys in the database so that every
+// server instance shares them.
+type DBIdempotencyStore struct {
+	DB *gorm.DB
+}
+
+func (s *DBIdempotencyStore) Claim(record model.IdempotencyKey, now time.Time) (stored model.IdempotencyKey, claimed bool, err error) {
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			stored, claimed = record, true
+			return nil
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND key = ?", record.UserID, record.Key).
+			First(&stored).Error; err != nil {
+			return err
+		}
+		expired := now.After(stored.ExpiresAt)
+		abandoned := stored.CompletedAt == nil && now.Sub(stored.UpdatedAt) > idempotencyLockTTL
+		if !expired && !abandoned {
+			return nil
+		}
+
+		// Take the key over from an expired or abandoned request
+		stored.Fingerprint, stored.Method, stored.Path = record.Fingerprint, record.Method, record.Path
+		stored.StatusCode, stored.ResponseBody, stored.ContentType, stored.ETag = 0, nil, "", ""
+		stored.CompletedAt, stored.ExpiresAt = nil, record.ExpiresAt
+		claimed = true
+		return tx.Save(&stored).Error
+	})
+	return stored, claimed, err
+}
+
+func (s *DBIdempotencyStore) Complete(key model.IdempotencyKey) error {
+	return s.DB.Model(&key).Updates(map[string]interface{}{
+		"status_code":   key.StatusCode,
+		"response_body": key.ResponseBody,
+		"content_type":  key.ContentType,
+		"e_tag":         key.ETag,
+		"completed_at":  key.CompletedAt,
+	}).Error
+}
+
+func (s *DBIdempotencyStore) Release(key model.IdempotencyKey) error {
+	return s.DB.Unscoped().Delete(&key).Error
+}
+
+// Idempotency lets clients retry a request safely by sending the same
+// Idempotency-Key header. The first request with a key runs; a successful
+// response is stored for 24 hours and replayed to retries of the same
+// request, marked with "Idempotent-Replayed: true". A different request
+// under the same key is rejected with 422, and a retry while the first
+// request is still running with 409. Failed responses are not stored, so
+// the request can be retried. Keys are scoped to the authenticated user.
+// Bodies larger than openapi.MaxBodySize are rejected with 413.
+func Idempotency(store IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		userID, authenticated := c.Get("userID")
+		if key == "" || !authenticated {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		// The legacy routes are not validated, so nothing has limited the
+		// body yet
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, openapi.MaxBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+				fmt.Sprintf("Request bodies may be at most %d bytes", openapi.MaxBodySize))
+			return
+		}
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := model.IdempotencyKey{
+			UserID:      userID.(uint),
+			Key:         key,
+			Fingerprint: requestFingerprint(c.Request.Method, c.FullPath(), body),
+			Method:      c.Request.Method,
+			Path:        c.FullPath(),
+			ExpiresAt:   now.Add(idempotencyTTL),
+		}
+		stored, claimed, err := store.Claim(record, now)
+		if err != nil {
+			log.Println("Failed to claim idempotency key:", err)
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check Idempotency-Key")
+			return
+		}
+
+		if !claimed {
+			switch {
+			case stored.Fingerprint != record.Fingerprint:
+				apierror.Abort(c, http.StatusUnprocessableEntity, apierror.CodeIdempotencyKeyReused,
+					"Idempotency-Key was already used for a different request")
+			case stored.CompletedAt == nil:
+				c.Header("Retry-After", "1")
+				apierror.Abort(c, http.StatusConflict, apierror.CodeIdempotencyInFlight,
+					"A request with this Idempotency-Key is still being processed")
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				if stored.ETag != "" {
+					c.Header("ETag", stored.ETag)
+				}
+				c.Data(stored.StatusCode, stored.ContentType, stored.ResponseBody)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status < 200 || status >= 300 {
+			if err := store.Release(stored); err != nil {
+				log.Println("Failed to release idempotency key:", err)
+			}
+			return
+		}
+		completed := time.Now()
+		stored.StatusCode = status
+		stored.ResponseBody = recorder.body.Bytes()
+		stored.ContentType = recorder.Header().Get("Content-Type")
+		stored.ETag = recorder.Header().Get("ETag")
+		stored.CompletedAt = &completed
+		if err := store.Complete(stored); err != nil {
+			log.Println("Failed to store idempotent response:", err)
+		}
+	}
+}
+
+// StartIdempotencyCleanup periodically deletes expired idempotency keys.
+func StartIdempotencyCleanup(db *gorm.DB) {
+	go func() {
+		tick := time.Tick(time.Hour)
+		for {
+			if err := db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&model.IdempotencyKey{}).Error; err != nil {
+				log.Println("Idempotency key cleanup failed:", err)
+			}
+			<-tick
+		}
+	}()
+}
@@ -0,0 +1,185 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/middleware"
+	"backend/model"
+	"backend/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveIdempotent(key string, authenticated bool) int {
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		if authenticated {
+			c.Set("userID", uint(1))
+		}
+	}, middleware.Idempotency(nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	if key != "" {
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestIdempotencyWithoutKeyPassesThrough(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	assert.Equal(t, http.StatusOK, serveIdempotent("", true))
+	assert.Equal(t, http.StatusOK, serveIdempotent("key", false))
+}
+
+func TestIdempotencyRejectsLongKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	assert.Equal(t, http.StatusBadRequest, serveIdempotent(strings.Repeat("k", 256), true))
+}
+
+// memoryIdempotencyStore keeps keys in a map, without the expiry and
+// takeover rules of the database store.
+type memoryIdempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]model.IdempotencyKey
+}
+
+func (s *memoryIdempotencyStore) Claim(record model.IdempotencyKey, now time.Time) (model.IdempotencyKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.keys[record.Key]; ok {
+		return stored, false, nil
+	}
+	s.keys[record.Key] = record
+	return record, true, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(key model.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.Key] = key
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(key model.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key.Key)
+	return nil
+}
+
+// idempotentRouter serves POST /items behind Idempotency with handler.
+func idempotentRouter(store middleware.IdempotencyStore, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/items", func(c *gin.Context) { c.Set("userID", uint(1)) }, middleware.Idempotency(store), handler)
+	return r
+}
+
+func postItem(r http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+	req.Header.Set(middleware.IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplaysStoredResponse(t *testing.T) {
+	store := &memoryIdempotencyStore{keys: map[string]model.IdempotencyKey{}}
+	calls := 0
+	r := idempotentRouter(store, func(c *gin.Context) {
+		calls++
+		c.Header("ETag", `"1"`)
+		c.JSON(http.StatusCreated, gin.H{"id": calls})
+	})
+
+	first := postItem(r, "key", `{"name": "sword"}`)
+	retry := postItem(r, "key", `{"name": "sword"}`)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, `"1"`, retry.Header().Get("ETag"))
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotencyRejectsDifferentRequestUnderSameKey(t *testing.T) {
+	store := &memoryIdempotencyStore{keys: map[string]model.IdempotencyKey{}}
+	calls := 0
+	r := idempotentRouter(store, func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusCreated)
+	})
+
+	postItem(r, "key", `{"name": "sword"}`)
+	w := postItem(r, "key", `{"name": "bow"}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), apierror.CodeIdempotencyKeyReused)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotencyRejectsRetryWhileInProgress(t *testing.T) {
+	store := &memoryIdempotencyStore{keys: map[string]model.IdempotencyKey{}}
+	var retry *httptest.ResponseRecorder
+	var r *gin.Engine
+	r = idempotentRouter(store, func(c *gin.Context) {
+		if retry == nil {
+			// the client retries before the first request has finished
+			retry = postItem(r, "key", `{"name": "sword"}`)
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	first := postItem(r, "key", `{"name": "sword"}`)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusConflict, retry.Code)
+	assert.Contains(t, retry.Body.String(), apierror.CodeIdempotencyInFlight)
+	assert.Equal(t, "1", retry.Header().Get("Retry-After"))
+}
+
+func TestIdempotencyReleasesKeyAfterFailure(t *testing.T) {
+	store := &memoryIdempotencyStore{keys: map[string]model.IdempotencyKey{}}
+	calls := 0
+	r := idempotentRouter(store, func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	failed := postItem(r, "key", `{"name": "sword"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, failed.Code)
+	assert.Empty(t, store.keys, "failed response kept its key")
+
+	retry := postItem(r, "key", `{"name": "sword"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyRejectsLargeBodies(t *testing.T) {
+	store := &memoryIdempotencyStore{keys: map[string]model.IdempotencyKey{}}
+	r := idempotentRouter(store, func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	w := postItem(r, "key", `{"name": "`+strings.Repeat("x", openapi.MaxBodySize)+`"}`)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), apierror.CodeBodyTooLarge)
+	assert.Empty(t, store.keys)
+}
@@ -1,92 +1,150 @@
 package middleware
 
 import (
-	"fmt"
+	"backend/apierror"
+	"backend/auth"
+	"backend/model"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func verifyToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		secret := os.Getenv("ACCESS_TOKEN_SECRET")
-		if secret == "" {
-			return nil, fmt.Errorf("missing ACCESS_TOKEN_SECRET environment variable")
-		}
-
-		return []byte(secret), nil
-	})
-
-	if err != nil {
-		log.Printf("Token parsing error: %v", err)
-		return nil, err
-	}
-
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
-
-	return token, nil
-}
+// How a request was authenticated, stored in the context as "authMethod".
+const (
+	AuthMethodJWT = "jwt"
+	AuthMethodPAT = "pat"
+)
 
 func AuthenticateMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		log.Printf("Authorization header received: %q", authHeader)
 
 		const prefix = "Bearer "
 		if authHeader == "" || !strings.HasPrefix(authHeader, prefix) {
 			log.Println("Invalid or missing Authorization header")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header missing or malformed"})
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "Authorization header missing or malformed")
 			return
 		}
 
 		tokenString := strings.TrimSpace(strings.TrimPrefix(authHeader, prefix))
-		log.Printf("Extracted token string: %q", tokenString)
 
-		// Verify token
-		token, err := verifyToken(tokenString)
-		if err != nil {
-			log.Printf("Token verification failed: %v", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		// Personal access tokens are opaque and looked up in the database
+		if strings.HasPrefix(tokenString, auth.PATPrefix) {
+			userID, scopes, err := auth.AuthenticatePAT(model.DB, tokenString)
+			if err != nil {
+				log.Printf("Access token verification failed: %v", err)
+				apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid token")
+				return
+			}
+			c.Set("userID", userID)
+			c.Set("role", model.RolePlayer)
+			c.Set("authMethod", AuthMethodPAT)
+			c.Set("scopes", scopes)
+			log.Printf("Access token verified successfully. User ID: %d", userID)
+
+			c.Next()
 			return
 		}
 
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
-			log.Println("Invalid token claims")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
+		// Verify signature, issuer, audience and expiry
+		claims, err := auth.ParseAccessToken(tokenString)
+		if err != nil {
+			log.Printf("Token verification failed: %v", err)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid token")
 			return
 		}
 
 		sub, ok := claims["sub"].(string)
 		if !ok {
 			log.Println("Token missing or invalid 'sub' claim")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: missing 'sub'"})
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid token: missing 'sub'")
 			return
 		}
 
 		userID, err := strconv.ParseUint(sub, 10, 64)
 		if err != nil {
 			log.Println("Failed to parse 'sub' to uint")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid user ID format in token"})
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid user ID format in token")
+			return
+		}
+
+		// Reject tokens whose session has been signed out
+		sid, ok := claims["sid"].(string)
+		if !ok || sid == "" {
+			log.Println("Token missing or invalid 'sid' claim")
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid token: missing 'sid'")
+			return
+		}
+
+		active, err := auth.SessionActive(model.DB, uint(userID), sid)
+		if err != nil {
+			log.Printf("Session lookup failed: %v", err)
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check session")
+			return
+		}
+		if !active {
+			log.Printf("Session %s is revoked", sid)
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeSessionRevoked, "Session revoked")
 			return
 		}
 
+		role, _ := claims["role"].(string)
+		if !model.ValidRole(role) {
+			role = model.RolePlayer
+		}
+
 		c.Set("userID", uint(userID))
+		c.Set("sessionID", sid)
+		c.Set("role", role)
+		c.Set("authMethod", AuthMethodJWT)
 		log.Printf("Token verified successfully. User ID: %d", userID)
 
 		c.Next()
 	}
 }
+
+// RequireRole allows the request only if the authenticated user has at least
+// the given role. It must run after AuthenticateMiddleware.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !model.RoleAtLeast(c.GetString("role"), role) {
+			log.Printf("Role %q is not allowed, %q required", c.GetString("role"), role)
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeInsufficientRole, "Insufficient role")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope allows requests made with a personal access token only if the
+// token grants scope. Requests signed in with a JWT have every scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") == AuthMethodJWT {
+			c.Next()
+			return
+		}
+		scopes, _ := c.Get("scopes")
+		granted, _ := scopes.([]string)
+		if !auth.HasScope(granted, scope) {
+			apierror.AbortWithDetails(c, http.StatusForbidden, apierror.CodeInsufficientScope, "Insufficient scope", gin.H{"required_scope": scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession allows only requests signed in with a JWT, keeping account
+// management out of reach of personal access tokens.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != AuthMethodJWT {
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeSessionRequired, "This route cannot be used with an access token")
+			return
+		}
+		c.Next()
+	}
+}
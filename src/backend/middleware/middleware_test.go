@@ -1 +1,118 @@
-package middleware_test
\ No newline at end of file
+package middleware_test
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/apierror"
+	"backend/auth"
+	"backend/dbtest"
+	"backend/middleware"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useSessionDB points model.DB and auth.Keys at a fake database with a
+// signing key, where user 7 is signed in to the sessions in active.
+func useSessionDB(t *testing.T, active map[string]bool) *dbtest.DB {
+	var keys [][]driver.Value
+	db, fake := dbtest.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, `INSERT INTO "signing_keys"`):
+			row := dbtest.Inserted(query, args)
+			keys = append(keys, []driver.Value{int64(1), time.Now(), row["k_id"], row["private_key"], row["expires_at"]})
+			return []string{"id"}, [][]driver.Value{{int64(1)}}
+		case strings.HasPrefix(query, `SELECT * FROM "signing_keys"`):
+			return []string{"id", "created_at", "k_id", "private_key", "expires_at"}, keys
+		case strings.HasPrefix(query, `SELECT count(*) FROM "sessions"`):
+			var count int64
+			if args[1] == int64(7) && active[args[0].(string)] {
+				count = 1
+			}
+			return []string{"count"}, [][]driver.Value{{count}}
+		}
+		return nil, nil
+	})
+
+	previousDB, previousKeys := model.DB, auth.Keys
+	model.DB, auth.Keys = db, &auth.KeySet{}
+	t.Cleanup(func() { model.DB, auth.Keys = previousDB, previousKeys })
+	require.NoError(t, auth.Keys.Rotate(db, time.Hour))
+	return fake
+}
+
+func accessToken(t *testing.T, claims jwt.MapClaims) string {
+	now := time.Now()
+	claims["aud"] = auth.Audience()
+	claims["exp"] = now.Add(time.Minute).Unix()
+	token, err := auth.Keys.Sign(claims)
+	require.NoError(t, err)
+	return token
+}
+
+// authenticate runs a request with the bearer token through the middleware
+// and returns the response and the session it was attributed to.
+func authenticate(token string) (*httptest.ResponseRecorder, string) {
+	var sessionID string
+	r := gin.New()
+	r.GET("/", middleware.AuthenticateMiddleware(), func(c *gin.Context) {
+		sessionID = c.GetString("sessionID")
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, sessionID
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body apierror.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body.Error.Code
+}
+
+func TestAuthenticateChecksSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useSessionDB(t, map[string]bool{"phone": true})
+
+	w, sessionID := authenticate(accessToken(t, jwt.MapClaims{"sub": "7", "sid": "phone"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "phone", sessionID)
+
+	cases := map[string]struct {
+		claims jwt.MapClaims
+		code   string
+	}{
+		"revoked session":        {jwt.MapClaims{"sub": "7", "sid": "laptop"}, apierror.CodeSessionRevoked},
+		"another user's session": {jwt.MapClaims{"sub": "8", "sid": "phone"}, apierror.CodeSessionRevoked},
+		"no session":             {jwt.MapClaims{"sub": "7"}, apierror.CodeInvalidToken},
+		"empty session":          {jwt.MapClaims{"sub": "7", "sid": ""}, apierror.CodeInvalidToken},
+		"no subject":             {jwt.MapClaims{"sid": "phone"}, apierror.CodeInvalidToken},
+	}
+	for name, tc := range cases {
+		w, _ := authenticate(accessToken(t, tc.claims))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+		assert.Equal(t, tc.code, errorCode(t, w), name)
+	}
+}
+
+func TestAuthenticateFailsWhenSessionsAreUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := useSessionDB(t, map[string]bool{"phone": true})
+	token := accessToken(t, jwt.MapClaims{"sub": "7", "sid": "phone"})
+	fake.Fail(`FROM "sessions"`, errors.New("connection reset"))
+
+	w, _ := authenticate(token)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,40 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/middleware"
+	"backend/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		role string
+		want int
+	}{
+		{model.RoleAdmin, http.StatusOK},
+		{model.RoleModerator, http.StatusForbidden},
+		{model.RolePlayer, http.StatusForbidden},
+		{"", http.StatusForbidden},
+		{"superuser", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) {
+			c.Set("role", tc.role)
+		}, middleware.RequireRole(model.RoleAdmin), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, tc.want, w.Code, "role %q", tc.role)
+	}
+}
@@ -0,0 +1,50 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/auth"
+	"backend/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWithAuth(method string, scopes []string, handlers ...gin.HandlerFunc) int {
+	r := gin.New()
+	setAuth := func(c *gin.Context) {
+		c.Set("authMethod", method)
+		if scopes != nil {
+			c.Set("scopes", scopes)
+		}
+	}
+	r.GET("/", append([]gin.HandlerFunc{setAuth}, append(handlers, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})...)...)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Code
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	read := middleware.RequireScope(auth.ScopeGameRead)
+	write := middleware.RequireScope(auth.ScopeGameWrite)
+
+	assert.Equal(t, http.StatusOK, serveWithAuth(middleware.AuthMethodJWT, nil, write))
+	assert.Equal(t, http.StatusOK, serveWithAuth(middleware.AuthMethodPAT, []string{auth.ScopeGameRead}, read))
+	assert.Equal(t, http.StatusForbidden, serveWithAuth(middleware.AuthMethodPAT, []string{auth.ScopeGameRead}, write))
+	assert.Equal(t, http.StatusOK, serveWithAuth(middleware.AuthMethodPAT, []string{auth.ScopeGameWrite}, read))
+	assert.Equal(t, http.StatusForbidden, serveWithAuth(middleware.AuthMethodPAT, nil, read))
+}
+
+func TestRequireSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	session := middleware.RequireSession()
+
+	assert.Equal(t, http.StatusOK, serveWithAuth(middleware.AuthMethodJWT, nil, session))
+	assert.Equal(t, http.StatusForbidden, serveWithAuth(middleware.AuthMethodPAT, []string{auth.ScopeGameWrite}, session))
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"backend/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID of a request in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the IDs accepted from clients to what is safe to log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID gives every request an ID, reusing the one the client sent in
+// X-Request-ID when it looks sane. The ID is echoed in the response header
+// and in error bodies so that a failure can be found in the logs.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(apierror.RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Deprecated marks the routes of a group as deprecated aliases: responses
+// keep the legacy error shape and field names, and carry Deprecation,
+// Sunset and Link headers pointing at the route under successor, which
+// replaces prefix in the request path.
+func Deprecated(prefix, successor string, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apierror.LegacyKey, true)
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		path := successor + strings.TrimPrefix(c.Request.URL.Path, prefix)
+		c.Header("Link", "<"+path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
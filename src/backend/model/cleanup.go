@@ -0,0 +1,211 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeleteGames permanently deletes games together with their snapshots,
+// floors, rooms, enemies, chests, players and weapons.
+func DeleteGames(tx *gorm.DB, gameIDs []uint) error {
+	if len(gameIDs) == 0 {
+		return nil
+	}
+	var games []Game
+	if err := tx.Unscoped().Select("id", "floor_id", "player_id").Where("id IN ?", gameIDs).Find(&games).Error; err != nil {
+		return err
+	}
+	var floorIDs, playerIDs []uint
+	for _, g := range games {
+		if g.FloorID != 0 {
+			floorIDs = append(floorIDs, g.FloorID)
+		}
+		if g.PlayerID != 0 {
+			playerIDs = append(playerIDs, g.PlayerID)
+		}
+	}
+
+	var rooms []Room
+	if len(floorIDs) > 0 {
+		if err := tx.Unscoped().Select("id", "chest_id").Where("floor_id IN ?", floorIDs).Find(&rooms).Error; err != nil {
+			return err
+		}
+	}
+	var roomIDs, chestIDs []uint
+	for _, r := range rooms {
+		roomIDs = append(roomIDs, r.ID)
+		if r.ChestID != nil {
+			chestIDs = append(chestIDs, *r.ChestID)
+		}
+	}
+
+	var weaponIDs []uint
+	if len(playerIDs) > 0 {
+		var players []Player
+		if err := tx.Unscoped().Select("id", "primary_weapon_id", "secondary_weapon_id").Where("id IN ?", playerIDs).Find(&players).Error; err != nil {
+			return err
+		}
+		for _, p := range players {
+			if p.PrimaryWeaponID != nil {
+				weaponIDs = append(weaponIDs, *p.PrimaryWeaponID)
+			}
+			if p.SecondaryWeaponID != nil {
+				weaponIDs = append(weaponIDs, *p.SecondaryWeaponID)
+			}
+		}
+	}
+	if len(chestIDs) > 0 {
+		var chests []Chest
+		if err := tx.Unscoped().Select("id", "weapon_id").Where("id IN ?", chestIDs).Find(&chests).Error; err != nil {
+			return err
+		}
+		for _, c := range chests {
+			if c.WeaponID != nil {
+				weaponIDs = append(weaponIDs, *c.WeaponID)
+			}
+		}
+	}
+
+	// Delete referencing rows before the rows they point at
+	steps := []struct {
+		model  interface{}
+		column string
+		ids    []uint
+	}{
+		{&GameSnapshot{}, "game_id", gameIDs},
+		{&Game{}, "id", gameIDs},
+		{&Enemy{}, "room_id", roomIDs},
+		{&Room{}, "id", roomIDs},
+		{&Chest{}, "id", chestIDs},
+		{&Player{}, "id", playerIDs},
+		{&Floor{}, "id", floorIDs},
+		{&Weapon{}, "id", weaponIDs},
+	}
+	for _, step := range steps {
+		if len(step.ids) == 0 {
+			continue
+		}
+		if err := tx.Unscoped().Where(step.column+" IN ?", step.ids).Delete(step.model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUserGames permanently deletes every game of a user.
+func DeleteUserGames(tx *gorm.DB, userID uint) error {
+	var gameIDs []uint
+	if err := tx.Unscoped().Model(&Game{}).Where("user_id = ?", userID).Pluck("id", &gameIDs).Error; err != nil {
+		return err
+	}
+	return DeleteGames(tx, gameIDs)
+}
+
+// AnonymiseUser erases a user at their request. Their games and everything
+// that authenticates them are deleted; the user row itself is kept, stripped
+// of personal data and soft-deleted, so records that must be retained (such
+// as billing) still point at a valid user.
+func AnonymiseUser(tx *gorm.DB, userID uint) error {
+	var user User
+	if err := tx.Unscoped().Select("id", "username").First(&user, userID).Error; err != nil {
+		return err
+	}
+	if err := deleteUserData(tx, user); err != nil {
+		return err
+	}
+
+	placeholder := fmt.Sprintf("deleted-%d", userID)
+	if err := tx.Unscoped().Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"username":              placeholder,
+		"email":                 placeholder + "@deleted.invalid",
+		"password":              "",
+		"email_verified_at":     nil,
+		"totp_secret":           "",
+		"totp_enabled_at":       nil,
+		"totp_last_step":        0,
+		"last_seen_at":          nil,
+		"deletion_scheduled_at": nil,
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&User{}, userID).Error
+}
+
+// deleteUserData deletes a user's games and everything that authenticates
+// them, leaving the user row.
+func deleteUserData(tx *gorm.DB, user User) error {
+	if err := DeleteUserGames(tx, user.ID); err != nil {
+		return err
+	}
+
+	owned := []interface{}{
+		&Session{},
+		&RefreshToken{},
+		&PasswordResetToken{},
+		&EmailVerificationToken{},
+		&LinkedIdentity{},
+		&RecoveryCode{},
+		&PersonalAccessToken{},
+		&GenerationUsage{},
+		&GenerationJob{},
+		&IdempotencyKey{},
+	}
+	for _, m := range owned {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(m).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("key = ?", "user:"+strings.ToLower(user.Username)).Delete(&LoginThrottle{}).Error
+}
+
+// DeleteUser permanently deletes a user with their games and everything
+// that authenticates them.
+func DeleteUser(tx *gorm.DB, userID uint) error {
+	var user User
+	if err := tx.Unscoped().Select("id", "username").First(&user, userID).Error; err != nil {
+		return err
+	}
+	if err := deleteUserData(tx, user); err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&User{}, userID).Error
+}
+
+// ReapUsers applies action, each in its own transaction, to up to 500 users
+// matching the condition query with args, and returns how many it applied
+// it to. Every user is locked and checked against the condition again
+// first, so one that stopped matching since they were listed is skipped.
+func ReapUsers(db *gorm.DB, action func(tx *gorm.DB, userID uint) error, query string, args ...interface{}) (int, error) {
+	var ids []uint
+	if err := db.Model(&User{}).Where(query, args...).Limit(500).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	reaped := 0
+	for _, id := range ids {
+		matched := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var user User
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, args...).First(&user, id).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			matched = true
+			return action(tx, id)
+		})
+		if err != nil {
+			return reaped, err
+		}
+		if matched {
+			reaped++
+		}
+	}
+	return reaped, nil
+}
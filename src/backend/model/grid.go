@@ -0,0 +1,128 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Every generated room is RoomWidth tiles wide and RoomHeight tiles tall.
+const (
+	RoomWidth  = 13
+	RoomHeight = 9
+)
+
+const (
+	TileWall  = 'w'
+	TileFloor = '.'
+)
+
+// FloorGrid is the layout of a floor: each cell holds the 1-based number of
+// the room placed there, or 0 when the cell is empty.
+type FloorGrid [][]int
+
+func (g FloorGrid) Value() (driver.Value, error) {
+	return jsonValue(g)
+}
+
+func (g *FloorGrid) Scan(src interface{}) error {
+	return jsonScan(src, g)
+}
+
+// AdjacencyMatrix records which rooms of a floor are connected, as produced by
+// the AI floor workflow.
+type AdjacencyMatrix [][]string
+
+func (m AdjacencyMatrix) Value() (driver.Value, error) {
+	return jsonValue(m)
+}
+
+func (m *AdjacencyMatrix) Scan(src interface{}) error {
+	return jsonScan(src, m)
+}
+
+// TileGrid holds the tiles of a room, one string per row. It is stored and
+// sent to clients as a JSON array of rows.
+type TileGrid []string
+
+// NewTileGrid builds a TileGrid from the per-character rows returned by the
+// AI floor workflow.
+func NewTileGrid(rows [][]string) TileGrid {
+	grid := make(TileGrid, len(rows))
+	for y, row := range rows {
+		grid[y] = strings.Join(row, "")
+	}
+	return grid
+}
+
+// ParseTileGrid splits the legacy flattened tile string into rows of
+// RoomWidth tiles.
+func ParseTileGrid(flat string) (TileGrid, error) {
+	if len(flat)%RoomWidth != 0 {
+		return nil, fmt.Errorf("tile string of length %d is not a multiple of %d", len(flat), RoomWidth)
+	}
+	grid := make(TileGrid, 0, len(flat)/RoomWidth)
+	for i := 0; i < len(flat); i += RoomWidth {
+		grid = append(grid, flat[i:i+RoomWidth])
+	}
+	return grid, nil
+}
+
+func (g TileGrid) Width() int {
+	if len(g) == 0 {
+		return 0
+	}
+	return len(g[0])
+}
+
+func (g TileGrid) Height() int {
+	return len(g)
+}
+
+// At returns the tile at column x of row y, or 0 when the position is
+// outside the grid.
+func (g TileGrid) At(x, y int) byte {
+	if y < 0 || y >= len(g) || x < 0 || x >= len(g[y]) {
+		return 0
+	}
+	return g[y][x]
+}
+
+// Walkable reports whether a player or enemy can stand on the tile at (x, y).
+func (g TileGrid) Walkable(x, y int) bool {
+	return g.At(x, y) == TileFloor
+}
+
+// String returns the tiles flattened row by row.
+func (g TileGrid) String() string {
+	return strings.Join(g, "")
+}
+
+func (g TileGrid) Value() (driver.Value, error) {
+	return jsonValue(g)
+}
+
+func (g *TileGrid) Scan(src interface{}) error {
+	return jsonScan(src, g)
+}
+
+// UnmarshalJSON accepts both an array of rows and the legacy flattened tile
+// string, so older clients and saved payloads keep working.
+func (g *TileGrid) UnmarshalJSON(data []byte) error {
+	var flat string
+	if err := json.Unmarshal(data, &flat); err == nil {
+		parsed, err := ParseTileGrid(flat)
+		if err != nil {
+			return err
+		}
+		*g = parsed
+		return nil
+	}
+	var rows []string
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+	*g = rows
+	return nil
+}
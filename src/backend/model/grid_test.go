@@ -0,0 +1,66 @@
+package model_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"backend/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const flatRoom = "wwwwwwwwwwwww" +
+	"w...........w" +
+	"w.ww.www.ww.w" +
+	"w...........w" +
+	"w.w.........w" +
+	"w...........w" +
+	"w.w...w.....w" +
+	"w...........w" +
+	"wwwwwwwwwwwww"
+
+func TestTileGridFromLegacyString(t *testing.T) {
+	grid, err := model.ParseTileGrid(flatRoom)
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoomWidth, grid.Width())
+	assert.Equal(t, model.RoomHeight, grid.Height())
+	assert.Equal(t, flatRoom, grid.String())
+
+	assert.False(t, grid.Walkable(0, 0))
+	assert.True(t, grid.Walkable(1, 1))
+	assert.False(t, grid.Walkable(2, 2))
+	assert.False(t, grid.Walkable(-1, 4))
+	assert.False(t, grid.Walkable(model.RoomWidth, 4))
+
+	_, err = model.ParseTileGrid(flatRoom[:20])
+	assert.Error(t, err)
+}
+
+func TestTileGridJSON(t *testing.T) {
+	grid, _ := model.ParseTileGrid(flatRoom)
+
+	data, err := json.Marshal(grid)
+	assert.NoError(t, err)
+
+	var fromRows model.TileGrid
+	assert.NoError(t, json.Unmarshal(data, &fromRows))
+	assert.Equal(t, grid, fromRows)
+
+	var fromString model.TileGrid
+	assert.NoError(t, json.Unmarshal([]byte(`"`+flatRoom+`"`), &fromString))
+	assert.Equal(t, grid, fromString)
+}
+
+func TestTileGridScan(t *testing.T) {
+	grid, _ := model.ParseTileGrid(flatRoom)
+	value, err := grid.Value()
+	assert.NoError(t, err)
+
+	var scanned model.TileGrid
+	assert.NoError(t, scanned.Scan([]byte(value.(string))))
+	assert.Equal(t, grid, scanned)
+
+	var floor model.FloorGrid
+	assert.NoError(t, floor.Scan(`[[0,1],[2,3]]`))
+	assert.Equal(t, model.FloorGrid{{0, 1}, {2, 3}}, floor)
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONDocument is an opaque JSON value stored in a jsonb column and embedded
+// as-is when marshalled.
+type JSONDocument json.RawMessage
+
+func (d JSONDocument) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+func (d *JSONDocument) UnmarshalJSON(data []byte) error {
+	*d = append((*d)[:0], data...)
+	return nil
+}
+
+func (d JSONDocument) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	return string(d), nil
+}
+
+func (d *JSONDocument) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = nil
+	case []byte:
+		*d = append((*d)[:0], v...)
+	case string:
+		*d = JSONDocument(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON column", src)
+	}
+	return nil
+}
+
+// UserPreferences are the display settings a user has chosen.
+type UserPreferences struct {
+	ColorScheme       string `json:"color_scheme"`
+	Language          string `json:"language"`
+	ReduceMotion      bool   `json:"reduce_motion"`
+	ShowDamageNumbers bool   `json:"show_damage_numbers"`
+}
+
+func (p UserPreferences) Value() (driver.Value, error) {
+	return jsonValue(p)
+}
+
+func (p *UserPreferences) Scan(src interface{}) error {
+	return jsonScan(src, p)
+}
+
+func jsonValue(v interface{}) (driver.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func jsonScan(src interface{}, dst interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON column", src)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// jsonColumnConversions turns the text columns that used to hold JSON (or,
+// for room tiles, a flattened tile string) into jsonb. Each USING expression
+// rewrites the existing value into the shape the typed column expects.
+var jsonColumnConversions = []struct {
+	table  string
+	column string
+	using  string
+}{
+	{"floors", "floor_map", "NULLIF(floor_map, '')::jsonb"},
+	{"floors", "adjacency", "NULLIF(adjacency, '')::jsonb"},
+	{"rooms", "tiles", fmt.Sprintf(
+		`CASE WHEN COALESCE(tiles, '') = '' THEN NULL
+		 ELSE to_jsonb(string_to_array(regexp_replace(tiles, '(.{%d})(?!$)', '\1,', 'g'), ','))
+		 END`, RoomWidth)},
+}
+
+// migrateJSONColumns converts legacy text columns in place. It is a no-op on a
+// fresh database and on one that has already been converted.
+func migrateJSONColumns(db *gorm.DB) error {
+	for _, conv := range jsonColumnConversions {
+		var dataType string
+		err := db.Raw(
+			"SELECT data_type FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?",
+			conv.table, conv.column,
+		).Scan(&dataType).Error
+		if err != nil {
+			return err
+		}
+		if dataType != "text" {
+			continue
+		}
+
+		log.Printf("Converting %s.%s to jsonb", conv.table, conv.column)
+		stmt := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE jsonb USING %s", conv.table, conv.column, conv.using)
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("converting %s.%s: %w", conv.table, conv.column, err)
+		}
+	}
+	return nil
+}
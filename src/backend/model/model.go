@@ -3,6 +3,7 @@ package model
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
@@ -125,6 +126,10 @@ func TeardownTestDB() {
 }
 
 func MigrateDB() {
+	if err := migrateJSONColumns(DB); err != nil {
+		log.Fatal("Failed to convert JSON columns:", err)
+	}
+
 	err := DB.AutoMigrate(
 		&User{},
 		&Floor{},
@@ -134,6 +139,23 @@ func MigrateDB() {
 		&Enemy{},
 		&Player{},
 		&Game{},
+		&GameSnapshot{},
+		&RefreshToken{},
+		&Session{},
+		&SigningKey{},
+		&PasswordResetToken{},
+		&EmailVerificationToken{},
+		&LoginThrottle{},
+		&LinkedIdentity{},
+		&OIDCLoginState{},
+		&RecoveryCode{},
+		&PersonalAccessToken{},
+		&Subscription{},
+		&BillingEvent{},
+		&GenerationUsage{},
+		&GenerationJob{},
+		&RateLimitBucket{},
+		&IdempotencyKey{},
 		)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
@@ -155,8 +177,29 @@ type User struct {
 	Username	string `gorm:"unique"`
 	Email	string `gorm:"unique"`
 	Password	string
+	// EmailVerifiedAt is set once the user follows the link emailed to them
+	EmailVerifiedAt	*time.Time
+	Role	string `gorm:"not null;default:player"`
+	// IsGuest marks an anonymous account created to try the game. Guests
+	// that stay inactive are deleted unless they are upgraded.
+	IsGuest	bool `gorm:"index"`
+	LastSeenAt	*time.Time
+	// DeletionScheduledAt is when a requested account deletion takes effect,
+	// unless the user cancels it before then.
+	DeletionScheduledAt	*time.Time `gorm:"index"`
+	Preferences	UserPreferences `gorm:"type:jsonb"`
+	// TOTPSecret is the base32 two-factor secret. It is set at enrollment and
+	// only enforced once TOTPEnabledAt is set.
+	TOTPSecret	string `json:"-"`
+	TOTPEnabledAt	*time.Time
+	// TOTPLastStep is the time step of the last accepted code, so a code
+	// cannot be used twice.
+	TOTPLastStep	int64 `json:"-"`
+	// SubscriptionLevel is derived from the user's subscriptions whenever a
+	// billing event changes one of them.
 	SubscriptionLevel	int
-	StripeID	int
+	// BillingCustomerID is the user's customer ID at the payment provider.
+	BillingCustomerID	string `gorm:"index" json:"-"`
 	Games []Game `gorm:"foreignKey:UserID" json:"games,omitempty"`
 }
 
@@ -171,6 +214,7 @@ type Player struct {
 	SpriteName     	 string	 `gorm:"type:text"`
 	PosX	int
 	PosY	int
+	Version	uint `gorm:"not null;default:1"`
 }
 
 type Game struct {
@@ -182,16 +226,23 @@ type Game struct {
 	PlayerID uint
 	Player	Player
 	UserID uint
+	Difficulty string
+	// Version is bumped on every save; clients send back the version they
+	// last saw so concurrent saves of the same game can be detected.
+	Version uint `gorm:"not null;default:1"`
 }
 
 type Floor struct {
     gorm.Model
     Rooms      []Room `gorm:"foreignKey:FloorID;constraint:OnDelete:CASCADE;"`
     PlayerInID uint `gorm:"default:null"`
-	FloorMap   string `gorm:"type:text"` // Store floor layout as JSON
-	Adjacency  string `gorm:"type:text"` // Store adjacency matrix as JSON
+	FloorMap   FloorGrid `gorm:"type:jsonb"`
+	Adjacency  AdjacencyMatrix `gorm:"type:jsonb"`
 	StoryText  string
 	Theme      string
+	// Seed drives every random choice made while building the floor
+	Seed       int64
+	Version    uint `gorm:"not null;default:1"`
 }
 
 
@@ -207,7 +258,7 @@ type Room struct {
     LeftID       *uint  `gorm:"constraint:OnDelete:SET NULL;"`
     RightID      *uint  `gorm:"constraint:OnDelete:SET NULL;"`
     Cleared      bool
-    Tiles        string `gorm:"type:text"`
+    Tiles        TileGrid `gorm:"type:jsonb"`
 	Type     	*int
 	StairX       *int
 	StairY       *int
@@ -241,6 +292,246 @@ type Chest struct {
     RoomInID  *uint   `gorm:"default:null"` // Nullable Room reference
     WeaponID  *uint   `gorm:"default:null"` // ✅ Keep as a pointer to allow NULL
     Weapon    *Weapon `gorm:"foreignKey:WeaponID;constraint:OnDelete:SET NULL;"` // Remove weapon reference if deleted
+	Opened    bool
 	PosX      int
 	PosY      int
 }
+
+// GameSnapshot is an immutable copy of a game's full state, taken each time
+// the game is saved so a run can be rolled back.
+type GameSnapshot struct {
+	gorm.Model
+	GameID	uint `gorm:"index"`
+	Version	uint
+	Reason	string
+	State	JSONDocument `gorm:"type:jsonb"`
+}
+
+// RefreshToken is a single-use refresh token. Only a hash of the token is
+// stored. Tokens issued from the same login share a FamilyID; each refresh
+// marks the presented token used and issues the next one in the family.
+type RefreshToken struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	FamilyID	string `gorm:"index"`
+	TokenHash	string `gorm:"uniqueIndex"`
+	ExpiresAt	time.Time
+	UsedAt	*time.Time
+	RevokedAt	*time.Time
+}
+
+// Session is one login of a user on a device. Its FamilyID is shared with the
+// refresh tokens issued for it and is carried in access tokens as the "sid"
+// claim, so revoking a session also rejects its outstanding access tokens.
+type Session struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	FamilyID	string `gorm:"uniqueIndex"`
+	UserAgent	string
+	IP	string
+	LastUsedAt	time.Time
+	RevokedAt	*time.Time
+}
+
+// SigningKey is an Ed25519 key used to sign access tokens. The newest key
+// signs; older keys stay published for verification until ExpiresAt.
+type SigningKey struct {
+	gorm.Model
+	KID	string `gorm:"uniqueIndex"`
+	PrivateKey	[]byte // Ed25519 seed
+	ExpiresAt	time.Time `gorm:"index"`
+}
+
+// PasswordResetToken is a single-use, time-limited token emailed to a user
+// who forgot their password. Only its hash is stored.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	TokenHash	string `gorm:"uniqueIndex"`
+	ExpiresAt	time.Time
+	UsedAt	*time.Time
+}
+
+// EmailVerificationToken confirms that a user controls Email. Only its hash
+// is stored.
+type EmailVerificationToken struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	Email	string
+	TokenHash	string `gorm:"uniqueIndex"`
+	ExpiresAt	time.Time
+	UsedAt	*time.Time
+}
+
+// LoginThrottle counts recent failed logins for one key, either an account
+// ("user:<username>") or a client address ("ip:<address>").
+type LoginThrottle struct {
+	gorm.Model
+	Key	string `gorm:"uniqueIndex"`
+	Failures	int
+	LastFailureAt	time.Time
+	LockedUntil	*time.Time
+}
+
+// LinkedIdentity ties an account at an external OpenID Connect provider to a
+// user, so they can sign in with it.
+type LinkedIdentity struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	Provider	string `gorm:"uniqueIndex:idx_linked_identity"`
+	Subject	string `gorm:"uniqueIndex:idx_linked_identity"`
+	Email	string
+}
+
+// OIDCLoginState holds the secrets of an OpenID Connect login in progress
+// until the provider redirects back. Only the hash of State is stored.
+type OIDCLoginState struct {
+	gorm.Model
+	StateHash	string `gorm:"uniqueIndex"`
+	Provider	string
+	// GuestUserID is set when the login upgrades that guest account
+	GuestUserID	uint
+	Nonce	string
+	CodeVerifier	string
+	ExpiresAt	time.Time
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// user has lost their authenticator. Only its hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	CodeHash	string `gorm:"index"`
+	UsedAt	*time.Time
+}
+
+// PersonalAccessToken is a long-lived API token a user creates for scripts
+// and bots. Only a hash of the token is stored. Scopes is a space separated
+// list of the scopes it grants.
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	Name	string
+	TokenHash	string `gorm:"uniqueIndex"`
+	Scopes	string
+	ExpiresAt	time.Time
+	LastUsedAt	*time.Time
+}
+
+// Subscription is a plan a user bought from the payment provider. Monthly
+// plans are renewed each period; a lifetime purchase has no period end.
+type Subscription struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	Provider	string `gorm:"uniqueIndex:idx_subscription"`
+	// ProviderID is the provider's subscription ID, or the checkout session
+	// ID of a one-time purchase.
+	ProviderID	string `gorm:"uniqueIndex:idx_subscription"`
+	Plan	string
+	Status	string
+	CurrentPeriodStart	*time.Time
+	CurrentPeriodEnd	*time.Time
+	CancelAtPeriodEnd	bool
+	// LastEventAt is when the provider created the last event applied, so
+	// events delivered out of order do not undo newer ones.
+	LastEventAt	time.Time
+}
+
+// BillingEvent records a processed webhook event so that redeliveries are
+// ignored.
+type BillingEvent struct {
+	gorm.Model
+	Provider	string `gorm:"uniqueIndex:idx_billing_event"`
+	EventID	string `gorm:"uniqueIndex:idx_billing_event"`
+	Type	string
+}
+
+// GenerationUsage counts a user's AI generations on one UTC day, to enforce
+// the daily limit of their tier.
+type GenerationUsage struct {
+	gorm.Model
+	UserID	uint `gorm:"uniqueIndex:idx_generation_usage"`
+	Day	string `gorm:"uniqueIndex:idx_generation_usage"` // YYYY-MM-DD
+	Count	int
+}
+
+// Statuses of a GenerationJob.
+const (
+	GenerationPending   = "pending"
+	GenerationSucceeded = "succeeded"
+	GenerationFailed    = "failed"
+)
+
+// GenerationJob is an AI generation run in the background for a client that
+// asked not to wait for it. Once it finishes, Result holds the body the
+// request would have been answered with, or Error the error.
+type GenerationJob struct {
+	gorm.Model
+	UserID	uint `gorm:"index"`
+	Kind	string // "game" or "floor"
+	Status	string
+	Result	JSONDocument `gorm:"type:jsonb"`
+	Error	JSONDocument `gorm:"type:jsonb"`
+}
+
+// RateLimitBucket is a token bucket shared by all server instances when rate
+// limits are kept in the database.
+type RateLimitBucket struct {
+	gorm.Model
+	Key	string `gorm:"uniqueIndex"`
+	Tokens	float64
+	RefilledAt	time.Time
+	// FullAt is when the bucket will have refilled and can be deleted
+	FullAt	time.Time `gorm:"index"`
+}
+
+// IdempotencyKey remembers a request sent with an Idempotency-Key header and
+// its response, so that a retry gets the same response instead of running
+// again. CompletedAt is nil while the first request is in progress.
+type IdempotencyKey struct {
+	gorm.Model
+	UserID	uint `gorm:"uniqueIndex:idx_idempotency_key"`
+	Key	string `gorm:"uniqueIndex:idx_idempotency_key"`
+	// Fingerprint is a hash of the method, route and body of the request
+	Fingerprint	string
+	Method	string
+	Path	string
+	StatusCode	int
+	ResponseBody	[]byte
+	ContentType	string
+	ETag	string
+	CompletedAt	*time.Time
+	ExpiresAt	time.Time `gorm:"index"`
+}
+
+// Subscription levels stored in User.SubscriptionLevel.
+const (
+	SubscriptionFree     = 0
+	SubscriptionMonthly  = 1
+	SubscriptionLifetime = 2
+)
+
+// Roles a user can have, from least to most privileged.
+const (
+	RolePlayer    = "player"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{RolePlayer: 1, RoleModerator: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants everything required does.
+func RoleAtLeast(role, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
+
+// EmailVerified reports whether the user has confirmed their email address.
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
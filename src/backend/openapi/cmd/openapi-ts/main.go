@@ -0,0 +1,23 @@
+// Command openapi-ts writes the TypeScript types of the OpenAPI
+// specification's schemas to the file named by its argument.
+package main
+
+import (
+	"log"
+	"os"
+
+	"backend/openapi"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: openapi-ts <output.ts>")
+	}
+	doc, err := openapi.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(os.Args[1], []byte(doc.TypeScript()), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,159 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"backend/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeSpec serves the specification as JSON.
+func ServeSpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", specJSON)
+}
+
+// ResponseReporter is told about responses that do not match the
+// specification.
+type ResponseReporter func(c *gin.Context, status int, errs []apierror.FieldError)
+
+// LogResponseErrors logs responses that do not match the specification.
+func LogResponseErrors(c *gin.Context, status int, errs []apierror.FieldError) {
+	log.Printf("OpenAPI: %s %s answered %d against the specification (request %s): %v",
+		c.Request.Method, c.FullPath(), status, c.GetString(apierror.RequestIDKey), errs)
+}
+
+// MaxBodySize is the largest request body Validator, or other middleware,
+// reads, in bytes. Handlers may accept less.
+const MaxBodySize = 8 << 20
+
+// Options configure Validator.
+type Options struct {
+	// Responses, when set, is told about every response that does not match
+	// the specification. Checking responses buffers them, so it is meant for
+	// development and tests.
+	Responses ResponseReporter
+}
+
+// bodyRecorder keeps a copy of the response body.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Validator rejects requests whose parameters or JSON body do not match the
+// operation in the specification with 400, listing the invalid fields.
+// Routes the specification does not describe pass through unchecked.
+func Validator(doc *Document, opts Options) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := doc.Operation(c.Request.Method, c.FullPath())
+		if op == nil {
+			c.Next()
+			return
+		}
+
+		errs, err := validateRequest(doc, op, c)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+				fmt.Sprintf("Request bodies may be at most %d bytes", MaxBodySize))
+			return
+		}
+		if err != nil {
+			errs = []apierror.FieldError{{Field: "body", Message: "could not be read"}}
+		}
+		if len(errs) > 0 {
+			apierror.AbortWithFields(c, errs)
+			return
+		}
+
+		if opts.Responses == nil {
+			c.Next()
+			return
+		}
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if errs := validateResponse(doc, op, status, recorder.Header().Get("Content-Type"), recorder.body.Bytes()); len(errs) > 0 {
+			opts.Responses(c, status, errs)
+		}
+	}
+}
+
+// validateRequest returns the invalid fields of a request, or the error that
+// kept its body from being read.
+func validateRequest(doc *Document, op *Operation, c *gin.Context) ([]apierror.FieldError, error) {
+	var errs []apierror.FieldError
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw = c.Param(p.Name)
+			present = raw != ""
+		case "query":
+			raw, present = c.GetQuery(p.Name)
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				errs = append(errs, apierror.FieldError{Field: p.Name, Message: "is required"})
+			}
+			continue
+		}
+		v := validator{doc: doc}
+		v.check(p.Schema, ParseParameter(doc.Deref(p.Schema), raw), p.Name)
+		errs = append(errs, v.errs...)
+	}
+
+	body := op.RequestBody
+	if body == nil {
+		return errs, nil
+	}
+	media, ok := body.Content["application/json"]
+	if !ok {
+		return errs, nil
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			errs = append(errs, apierror.FieldError{Field: "body", Message: "is required"})
+		}
+		return errs, nil
+	}
+	return append(errs, doc.ValidateJSON(media.Schema, data, false)...), nil
+}
+
+func validateResponse(doc *Document, op *Operation, status int, contentType string, body []byte) []apierror.FieldError {
+	schema, documented := op.ResponseSchema(status)
+	if !documented {
+		return []apierror.FieldError{{Field: "status", Message: "is not documented"}}
+	}
+	if schema == nil || len(body) == 0 || !strings.HasPrefix(contentType, "application/json") {
+		return nil
+	}
+	return doc.ValidateJSON(schema, body, true)
+}
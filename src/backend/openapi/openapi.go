@@ -0,0 +1,243 @@
+// Package openapi serves the OpenAPI 3 description of the API and checks
+// requests and responses against it.
+//
+// Only the parts of OpenAPI the specification uses are understood: paths
+// with path and query parameters, JSON request bodies and responses, and
+// schemas built from type, properties, required, items, enum, nullable,
+// minimum, maximum, minLength, maxLength, minItems, additionalProperties and
+// $ref to components/schemas.
+package openapi
+
+//go:generate go run ./cmd/openapi-ts ../../frontend/src/phaser/backend/schema.ts
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed openapi.json
+var specJSON []byte
+
+// Document is a parsed OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Servers    []Server             `json:"servers"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem holds the operations of a path, keyed by lowercase HTTP method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []Parameter          `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema. AdditionalProperties is nil when unset, a schema
+// for the extra properties of a map, or false (Closed) when none are allowed.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *Schema            `json:"items"`
+	AdditionalProperties *Additional        `json:"additionalProperties"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	Description          string             `json:"description"`
+}
+
+// Additional is the value of additionalProperties: false or a schema.
+type Additional struct {
+	Closed bool
+	Schema *Schema
+}
+
+func (a *Additional) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		a.Closed = !allowed
+		return nil
+	}
+	a.Schema = new(Schema)
+	return json.Unmarshal(data, a.Schema)
+}
+
+// Spec returns the raw specification.
+func Spec() []byte {
+	return specJSON
+}
+
+// Load parses the embedded specification and resolves its references.
+func Load() (*Document, error) {
+	return Parse(specJSON)
+}
+
+// Parse parses a specification and resolves its references.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := doc.resolve(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// resolve checks that every $ref names a known schema.
+func (d *Document) resolve() error {
+	var check func(s *Schema, where string) error
+	check = func(s *Schema, where string) error {
+		if s == nil {
+			return nil
+		}
+		if s.Ref != "" {
+			if _, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]; !ok || !strings.HasPrefix(s.Ref, schemaRefPrefix) {
+				return fmt.Errorf("%s: unknown reference %q", where, s.Ref)
+			}
+		}
+		for name, p := range s.Properties {
+			if err := check(p, where+"."+name); err != nil {
+				return err
+			}
+		}
+		if s.AdditionalProperties != nil {
+			if err := check(s.AdditionalProperties.Schema, where+".*"); err != nil {
+				return err
+			}
+		}
+		return check(s.Items, where+"[]")
+	}
+	for name, s := range d.Components.Schemas {
+		if err := check(s, name); err != nil {
+			return err
+		}
+	}
+	for path, item := range d.Paths {
+		for method, op := range *item {
+			where := strings.ToUpper(method) + " " + path
+			for _, p := range op.Parameters {
+				if err := check(p.Schema, where+" "+p.Name); err != nil {
+					return err
+				}
+			}
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					if err := check(m.Schema, where+" body"); err != nil {
+						return err
+					}
+				}
+			}
+			for status, r := range op.Responses {
+				for _, m := range r.Content {
+					if err := check(m.Schema, where+" "+status); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Deref follows a schema's reference.
+func (d *Document) Deref(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+	}
+	return s
+}
+
+// BasePath is the path of the first server, which every path in the
+// specification is relative to.
+func (d *Document) BasePath() string {
+	if len(d.Servers) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(d.Servers[0].URL, "/")
+}
+
+// Operation returns the operation for a method and a gin route such as
+// "/api/v1/game/:id", or nil when the specification does not describe it.
+func (d *Document) Operation(method, route string) *Operation {
+	base := d.BasePath()
+	if !strings.HasPrefix(route, base+"/") {
+		return nil
+	}
+	item, ok := d.Paths[TemplatePath(strings.TrimPrefix(route, base))]
+	if !ok {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// TemplatePath turns gin path parameters (":id") into OpenAPI ones ("{id}").
+func TemplatePath(route string) string {
+	parts := strings.Split(route, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// ResponseSchema returns the JSON schema of the response with the given
+// status, falling back to the "default" response. ok is false when the
+// status is not documented at all.
+func (op *Operation) ResponseSchema(status int) (schema *Schema, ok bool) {
+	r, found := op.Responses[fmt.Sprint(status)]
+	if !found {
+		r, found = op.Responses["default"]
+	}
+	if !found {
+		return nil, false
+	}
+	if m, json := r.Content["application/json"]; json {
+		return m.Schema, true
+	}
+	return nil, true
+}
@@ -0,0 +1,144 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSpec = `{
+  "openapi": "3.0.3",
+  "servers": [{"url": "/api/v1"}],
+  "paths": {
+    "/item/{id}": {
+      "get": {
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}}],
+        "responses": {"200": {"description": "Success", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Item": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "kind": {"type": "string", "enum": ["sword", "bow"]},
+          "parent": {"$ref": "#/components/schemas/Item", "nullable": true},
+          "tags": {"type": "array", "items": {"type": "string"}}
+        },
+        "required": ["name"]
+      },
+      "Closed": {
+        "type": "object",
+        "properties": {"name": {"type": "string"}},
+        "additionalProperties": false
+      }
+    }
+  }
+}`
+
+func fields(t *testing.T, doc *Document, schema, data string, strict bool) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	for _, err := range doc.ValidateJSON(doc.Components.Schemas[schema], []byte(data), strict) {
+		got[err.Field] = err.Message
+	}
+	return got
+}
+
+func TestValidate(t *testing.T) {
+	doc, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, schema, data string
+		strict             bool
+		want               []string
+	}{
+		{"valid", "Item", `{"name": "a", "kind": "bow", "parent": null, "tags": ["x"]}`, false, nil},
+		{"missing required", "Item", `{}`, false, []string{"name"}},
+		{"wrong type", "Item", `{"name": 1}`, false, []string{"name"}},
+		{"too short", "Item", `{"name": ""}`, false, []string{"name"}},
+		{"not in enum", "Item", `{"name": "a", "kind": "axe"}`, false, []string{"kind"}},
+		{"nested", "Item", `{"name": "a", "parent": {"tags": [1]}}`, false, []string{"parent.name", "parent.tags[0]"}},
+		{"extra property", "Item", `{"name": "a", "extra": 1}`, false, nil},
+		{"extra property strict", "Item", `{"name": "a", "extra": 1}`, true, []string{"extra"}},
+		{"closed", "Closed", `{"extra": 1}`, false, []string{"extra"}},
+		{"not JSON", "Item", `{`, false, []string{"body"}},
+	}
+	for _, tt := range tests {
+		got := fields(t, doc, tt.schema, tt.data, tt.strict)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: errors = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for _, field := range tt.want {
+			if _, ok := got[field]; !ok {
+				t.Errorf("%s: errors = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestOperation(t *testing.T) {
+	doc, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Operation("GET", "/api/v1/item/:id") == nil {
+		t.Error("GET /api/v1/item/:id not found")
+	}
+	if doc.Operation("POST", "/api/v1/item/:id") != nil || doc.Operation("GET", "/api/item/:id") != nil {
+		t.Error("found an operation the specification does not describe")
+	}
+}
+
+func TestValidatorRejectsLargeBodies(t *testing.T) {
+	doc, err := Parse([]byte(`{
+	  "servers": [{"url": "/api/v1"}],
+	  "paths": {"/item": {"post": {"requestBody": {"content": {"application/json": {"schema": {"type": "object"}}}}}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/v1/item", Validator(doc, Options{}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	body := `{"name": "` + strings.Repeat("x", MaxBodySize) + `"}`
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/item", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestParseRejectsUnknownReferences(t *testing.T) {
+	_, err := Parse([]byte(`{"components": {"schemas": {"A": {"$ref": "#/components/schemas/B"}}}}`))
+	if err == nil {
+		t.Error("Parse accepted a reference to a missing schema")
+	}
+}
+
+// TestTypeScriptUpToDate checks that the frontend's schema.ts was generated
+// from the current specification; run go generate ./openapi to update it.
+func TestTypeScriptUpToDate(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := os.ReadFile("../../frontend/src/phaser/backend/schema.ts")
+	if err != nil {
+		t.Skip("frontend not checked out:", err)
+	}
+	if string(current) != doc.TypeScript() {
+		t.Error("schema.ts is out of date; run go generate ./openapi")
+	}
+}
@@ -0,0 +1,113 @@
+package openapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TypeScript renders the component schemas as TypeScript interfaces, so the
+// frontend's types follow the specification instead of being kept in sync
+// by hand.
+func (d *Document) TypeScript() string {
+	var b strings.Builder
+	b.WriteString("// Code generated by openapi-ts from src/backend/openapi/openapi.json. DO NOT EDIT.\n")
+
+	names := make([]string, 0, len(d.Components.Schemas))
+	for name := range d.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := d.Components.Schemas[name]
+		b.WriteString("\n")
+		writeComment(&b, s.Description, "")
+		if s.Type == "object" && s.Properties != nil {
+			fmt.Fprintf(&b, "export interface %s %s\n", name, tsObject(s, ""))
+		} else {
+			fmt.Fprintf(&b, "export type %s = %s;\n", name, tsType(s, ""))
+		}
+	}
+	return b.String()
+}
+
+func writeComment(b *strings.Builder, text, indent string) {
+	if text != "" {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, text)
+	}
+}
+
+func tsObject(s *Schema, indent string) string {
+	required := map[string]bool{}
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range names {
+		prop := s.Properties[name]
+		writeComment(&b, prop.Description, indent+"    ")
+		optional := ""
+		if !required[name] {
+			optional = "?"
+		}
+		fmt.Fprintf(&b, "%s    %s%s: %s;\n", indent, name, optional, tsType(prop, indent+"    "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func tsType(s *Schema, indent string) string {
+	t := tsBaseType(s, indent)
+	if s.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+func tsBaseType(s *Schema, indent string) string {
+	if s.Ref != "" {
+		return strings.TrimPrefix(s.Ref, schemaRefPrefix)
+	}
+	if len(s.Enum) > 0 {
+		values := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			if str, ok := e.(string); ok {
+				values[i] = fmt.Sprintf("%q", str)
+			} else {
+				values[i] = fmt.Sprint(e)
+			}
+		}
+		return strings.Join(values, " | ")
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		item := tsType(s.Items, indent)
+		if strings.Contains(item, " ") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		if s.Properties != nil {
+			return tsObject(s, indent)
+		}
+		if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+			return "Record<string, " + tsType(s.AdditionalProperties.Schema, indent) + ">"
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
@@ -0,0 +1,224 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"backend/apierror"
+)
+
+// validator collects the errors found while checking a value.
+type validator struct {
+	doc *Document
+	// strict rejects properties a schema with properties does not list, even
+	// when it does not set additionalProperties
+	strict bool
+	errs   []apierror.FieldError
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "body"
+	}
+	v.errs = append(v.errs, apierror.FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks a value decoded with json.Decoder.UseNumber against a
+// schema. Objects may carry properties the schema does not list.
+func (d *Document) Validate(s *Schema, value interface{}) []apierror.FieldError {
+	v := validator{doc: d}
+	v.check(s, value, "")
+	return v.errs
+}
+
+// ValidateStrict is Validate, but also rejects undocumented properties of
+// objects that list their properties.
+func (d *Document) ValidateStrict(s *Schema, value interface{}) []apierror.FieldError {
+	v := validator{doc: d, strict: true}
+	v.check(s, value, "")
+	return v.errs
+}
+
+// ValidateJSON decodes data and validates it; see Validate.
+func (d *Document) ValidateJSON(s *Schema, data []byte, strict bool) []apierror.FieldError {
+	value, err := decode(data)
+	if err != nil {
+		return []apierror.FieldError{{Field: "body", Message: "is not valid JSON"}}
+	}
+	v := validator{doc: d, strict: strict}
+	v.check(s, value, "")
+	return v.errs
+}
+
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (v *validator) check(s *Schema, value interface{}, path string) {
+	if s == nil {
+		return
+	}
+	nullable := s.Nullable
+	s = v.doc.Deref(s)
+	if value == nil {
+		if !nullable && !s.Nullable && s.Type != "" {
+			v.fail(path, "must not be null")
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(path, "must be an object")
+			return
+		}
+		v.checkObject(s, obj, path)
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			v.fail(path, "must be an array")
+			return
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			v.fail(path, "must have at least %d items", *s.MinItems)
+		}
+		for i, item := range arr {
+			v.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			v.fail(path, "must be a string")
+			return
+		}
+		v.checkString(s, str, path)
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			v.fail(path, "must be a number")
+			return
+		}
+		v.checkNumber(s, n, path)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(path, "must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		v.fail(path, "must be one of %s", enumList(s.Enum))
+	}
+}
+
+func (v *validator) checkObject(s *Schema, obj map[string]interface{}, path string) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			v.fail(join(path, name), "is required")
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := s.Properties[name]; ok {
+			v.check(prop, obj[name], join(path, name))
+			continue
+		}
+		switch extra := s.AdditionalProperties; {
+		case extra != nil && extra.Schema != nil:
+			v.check(extra.Schema, obj[name], join(path, name))
+		case extra != nil && extra.Closed, extra == nil && v.strict && s.Properties != nil:
+			v.fail(join(path, name), "is not a documented property")
+		}
+	}
+}
+
+func (v *validator) checkString(s *Schema, str, path string) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		v.fail(path, "must be at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.fail(path, "must be at most %d characters", *s.MaxLength)
+	}
+	if s.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			v.fail(path, "must be an RFC 3339 date and time")
+		}
+	}
+}
+
+func (v *validator) checkNumber(s *Schema, n json.Number, path string) {
+	f, err := n.Float64()
+	if err != nil {
+		v.fail(path, "must be a number")
+		return
+	}
+	if s.Type == "integer" {
+		if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+			v.fail(path, "must be an integer")
+			return
+		}
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		v.fail(path, "must be at least %v", *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		v.fail(path, "must be at most %v", *s.Maximum)
+	}
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
+
+// ParseParameter converts a path or query parameter to the JSON value its
+// schema describes, so it can be validated like a body field.
+func ParseParameter(s *Schema, raw string) interface{} {
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
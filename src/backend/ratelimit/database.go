@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"backend/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DBStore keeps buckets in the database so that every server instance
+// shares them.
+type DBStore struct {
+	DB *gorm.DB
+}
+
+func (s *DBStore) Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error) {
+	var res Result
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var b model.RateLimitBucket
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&b).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			b = model.RateLimitBucket{Key: key, Tokens: float64(rate.Burst), RefilledAt: now}
+			// Another instance may create the bucket at the same time
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&b).Error; err != nil {
+				return err
+			}
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&b).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		tokens, r := take(b.Tokens, b.RefilledAt, rate, now)
+		res = r
+		return tx.Model(&b).Updates(map[string]interface{}{
+			"tokens":      tokens,
+			"refilled_at": now,
+			"full_at":     now.Add(r.Reset),
+		}).Error
+	})
+	return res, err
+}
+
+// deleteIdle removes buckets that have refilled, since a new bucket starts
+// full.
+func (s *DBStore) deleteIdle(now time.Time) error {
+	return s.DB.Unscoped().Where("full_at < ?", now).Delete(&model.RateLimitBucket{}).Error
+}
+
+// StartCleanup periodically deletes idle buckets.
+func (s *DBStore) StartCleanup() {
+	go func() {
+		tick := time.Tick(10 * time.Minute)
+		for {
+			if err := s.deleteIdle(time.Now()); err != nil {
+				log.Println("Rate limit cleanup failed:", err)
+			}
+			<-tick
+		}
+	}()
+}
@@ -0,0 +1,154 @@
+// Package ratelimit throttles requests with token buckets kept per user and
+// per client address.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/apierror"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Rate is a token bucket holding up to Burst requests and refilling one
+// token every Interval. The zero Rate does not limit anything.
+type Rate struct {
+	Burst    int
+	Interval time.Duration
+}
+
+func (r Rate) enabled() bool {
+	return r.Burst > 0 && r.Interval > 0
+}
+
+// Result is the state of a bucket after taking a token from it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a token is available, when not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets.
+type Store interface {
+	Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error)
+}
+
+// take applies a request to a bucket with tokens last updated at updated and
+// returns the new token count.
+func take(tokens float64, updated time.Time, rate Rate, now time.Time) (float64, Result) {
+	if elapsed := now.Sub(updated); elapsed > 0 {
+		tokens += float64(elapsed) / float64(rate.Interval)
+	}
+	tokens = math.Min(tokens, float64(rate.Burst))
+
+	res := Result{Limit: rate.Burst}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(rate.Interval))
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration((float64(rate.Burst) - tokens) * float64(rate.Interval))
+	return tokens, res
+}
+
+// seconds rounds a duration up to whole seconds for headers.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// SetHeaders writes the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers. When several limits apply to a request the one
+// with the fewest requests remaining is reported.
+func SetHeaders(c *gin.Context, res Result) {
+	h := c.Writer.Header()
+	if current := h.Get("RateLimit-Remaining"); current != "" {
+		if n, err := strconv.Atoi(current); err == nil && n < res.Remaining {
+			return
+		}
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", seconds(res.Reset))
+}
+
+// TooManyRequests rejects a request with 429 and a Retry-After header.
+func TooManyRequests(c *gin.Context, retryAfter time.Duration, code, message string, details interface{}) {
+	c.Header("Retry-After", seconds(retryAfter))
+	apierror.AbortWithDetails(c, http.StatusTooManyRequests, code, message, details)
+}
+
+// Limiter builds rate limiting middleware on top of a store.
+type Limiter struct {
+	Store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{Store: store, now: time.Now}
+}
+
+type limitBucket struct {
+	key  string
+	rate Rate
+}
+
+// Limit takes a token from the user's bucket and from the client address's
+// bucket for the named limit, rejecting the request when either is empty.
+// The user bucket applies only after authentication. If the store fails,
+// requests are let through.
+func (l *Limiter) Limit(name string, perUser, perIP Rate) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := l.now()
+		buckets := []limitBucket{{"ip:" + c.ClientIP(), perIP}}
+		if userID, ok := c.Get("userID"); ok {
+			buckets = append(buckets, limitBucket{fmt.Sprintf("user:%v", userID), perUser})
+		}
+
+		for _, b := range buckets {
+			if !b.rate.enabled() {
+				continue
+			}
+			res, err := l.Store.Take(c.Request.Context(), name+":"+b.key, b.rate, now)
+			if err != nil {
+				log.Printf("Rate limit store failed for %s: %v", name, err)
+				continue
+			}
+			SetHeaders(c, res)
+			if !res.Allowed {
+				TooManyRequests(c, res.RetryAfter, apierror.CodeRateLimited, "Too many requests, slow down", nil)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// StoreFromEnv builds the store selected by RATE_LIMIT_STORE: "memory" (the
+// default) keeps buckets in this process, "database" shares them between
+// server instances.
+func StoreFromEnv(db *gorm.DB) (Store, error) {
+	switch kind := os.Getenv("RATE_LIMIT_STORE"); kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "database":
+		store := &DBStore{DB: db}
+		store.StartCleanup()
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", kind)
+	}
+}
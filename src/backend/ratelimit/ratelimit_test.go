@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryStoreRefills(t *testing.T) {
+	store := NewMemoryStore()
+	rate := Rate{Burst: 2, Interval: 10 * time.Second}
+	now := time.Now()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, _ := store.Take(ctx, "k", rate, now)
+		if !res.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	res, _ := store.Take(ctx, "k", rate, now)
+	if res.Allowed || res.RetryAfter != 10*time.Second || res.Remaining != 0 {
+		t.Fatalf("third request: %+v", res)
+	}
+
+	res, _ = store.Take(ctx, "k", rate, now.Add(5*time.Second))
+	if res.Allowed || res.RetryAfter != 5*time.Second {
+		t.Fatalf("half refilled: %+v", res)
+	}
+	res, _ = store.Take(ctx, "k", rate, now.Add(10*time.Second))
+	if !res.Allowed {
+		t.Fatalf("refilled token should be allowed: %+v", res)
+	}
+
+	if res, _ := store.Take(ctx, "other", rate, now); !res.Allowed || res.Remaining != 1 {
+		t.Fatalf("buckets should be independent: %+v", res)
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	rate := Rate{Burst: 1, Interval: time.Second}
+	now := time.Now()
+	store.Take(context.Background(), "k", rate, now)
+
+	store.Take(context.Background(), "other", rate, now.Add(2*sweepInterval))
+	if _, ok := store.buckets["k"]; ok {
+		t.Error("refilled bucket should have been swept")
+	}
+}
+
+func TestLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.now = func() time.Time { return now }
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", uint(1)) })
+	r.GET("/", limiter.Limit("test",
+		Rate{Burst: 1, Interval: time.Minute},
+		Rate{Burst: 5, Interval: time.Second}),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("headers should report the tighter user bucket: %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After = %q, want 60", w.Header().Get("Retry-After"))
+	}
+}
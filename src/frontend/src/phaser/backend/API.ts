@@ -1,6 +1,7 @@
 import type { FloorObject, FloorResponse, GameObject, GamePreview, GameResponse, GamesResponse } from './types';
+import type { Entitlements, EntitlementsResponse } from './schema';
 import { authStore } from '../../lib/stores/authStore';
-const API_URL = 'http://127.0.0.1:8080/api/protected';
+const API_URL = 'http://127.0.0.1:8080/api/v1';
 
 export async function createGame(difficultyLevel: string, Theme: string): Promise<GameObject | null> {
     try {
@@ -24,6 +25,7 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
     const game: GameResponse = {
         game: {
             Level: 1,
+            Version: 1,
             ID: 6,
             Floor: {
                 Theme: 'castle',
@@ -69,7 +71,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: 41,
                         RightID: 42,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww.ww.www.ww.ww...........ww.w.........ww...........ww.w...w.....ww...........wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w.ww.www.ww.w',
+                            'w...........w',
+                            'w.w.........w',
+                            'w...........w',
+                            'w.w...w.....w',
+                            'w...........w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 0,
                         StairX: null,
                         StairY: null,
@@ -83,7 +95,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: 42,
                         RightID: 41,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww.wwwwwwww..ww.w.........ww.wwwwwwww..ww...........ww.wwwwwwww..ww...........wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w.wwwwwwww..w',
+                            'w.w.........w',
+                            'w.wwwwwwww..w',
+                            'w...........w',
+                            'w.wwwwwwww..w',
+                            'w...........w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 0,
                         StairX: null,
                         StairY: null,
@@ -131,7 +153,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: 39,
                         RightID: null,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww....wwww...ww...........ww.wwwwwwww..ww.w......w..ww.w.wwww.w..ww...........wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w....wwww...w',
+                            'w...........w',
+                            'w.wwwwwwww..w',
+                            'w.w......w..w',
+                            'w.w.wwww.w..w',
+                            'w...........w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 0,
                         StairX: null,
                         StairY: null,
@@ -158,7 +190,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: null,
                         RightID: 38,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww.wwwwwwwww.ww.........w.ww.w.wwwww.w.ww.w.....w.w.ww.w.wwwww.w.ww.w.......w.wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w.wwwwwwwww.w',
+                            'w.........w.w',
+                            'w.w.wwwww.w.w',
+                            'w.w.....w.w.w',
+                            'w.w.wwwww.w.w',
+                            'w.w.......w.w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 0,
                         StairX: null,
                         StairY: null,
@@ -184,7 +226,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: null,
                         RightID: null,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww.ww........ww...........ww.ww........ww...........ww.ww........ww...........wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w.ww........w',
+                            'w...........w',
+                            'w.ww........w',
+                            'w...........w',
+                            'w.ww........w',
+                            'w...........w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 0,
                         StairX: null,
                         StairY: null,
@@ -210,7 +262,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: 37,
                         RightID: 36,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww.www.wwww..ww.w.........ww.w.w.www...ww.w.........ww.w.w.www...ww.w.........wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w.www.wwww..w',
+                            'w.w.........w',
+                            'w.w.w.www...w',
+                            'w.w.........w',
+                            'w.w.w.www...w',
+                            'w.w.........w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 2,
                         StairX: 1,
                         StairY: 5,
@@ -236,7 +298,17 @@ export async function createGame(difficultyLevel: string, Theme: string): Promis
                         LeftID: 36,
                         RightID: 37,
                         Cleared: false,
-                        Tiles: 'wwwwwwwwwwwwww...........ww.wwwwwwww..ww.w.........ww.w.wwwwwww.ww...........ww.wwwwwwww..ww...........wwwwwwwwwwwwww',
+                        Tiles: [
+                            'wwwwwwwwwwwww',
+                            'w...........w',
+                            'w.wwwwwwww..w',
+                            'w.w.........w',
+                            'w.w.wwwwwww.w',
+                            'w...........w',
+                            'w.wwwwwwww..w',
+                            'w...........w',
+                            'wwwwwwwwwwwww',
+                        ],
                         Type: 0,
                         StairX: null,
                         StairY: null,
@@ -271,7 +343,7 @@ interface SaveRoom {
     RightID: number | null;
     StairX: number | null;
     StairY: number | null;
-    Tiles: string;
+    Tiles: string[];
     Type: number;
     X: number;
     Y: number;
@@ -345,11 +417,15 @@ export async function saveGame(Game: GameObject): Promise<void> {
         const response = await fetch(`${API_URL}/save_game`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json', 'Authorization':`Bearer ${token}` },
-            body: JSON.stringify({ game: toSaveable(Game) }),
+            body: JSON.stringify({ game: toSaveable(Game), version: Game.Version }),
         });
 
+        if (response.status === 409) throw new Error('Game was saved from another session');
         if (!response.ok) throw new Error('Failed to save game');
 
+        const saved: GameResponse = await response.json();
+        Game.Version = saved.game.Version;
+
         console.log('Game saved successfully');
     } catch (error) {
         await new Promise(resolve => setTimeout(resolve, 3000)); // Simulate delay
@@ -413,7 +489,17 @@ export async function getFloor(difficulty: string, theme: string, level: number,
                 LeftID: null,
                 RightID: null,
                 Cleared: false,
-                Tiles: 'wwwwwwwwwwwwww...........ww.wwwwwwww..ww...........ww.w.........ww...........ww.w.........ww...........wwwwwwwwwwwwww',
+                Tiles: [
+                    'wwwwwwwwwwwww',
+                    'w...........w',
+                    'w.wwwwwwww..w',
+                    'w...........w',
+                    'w.w.........w',
+                    'w...........w',
+                    'w.w.........w',
+                    'w...........w',
+                    'wwwwwwwwwwwww',
+                ],
                 Type: 0,
                 StairX: null,
                 StairY: null,
@@ -438,10 +524,10 @@ export async function getGames(): Promise<GamePreview[] | null> {
         console.log(response)
         const gamesResponse: GamesResponse = await response.json();
         const gamePreviews: GamePreview[] = [];
-        for (let i = 0; i < gamesResponse.GameIDs.length; i++) {
+        for (let i = 0; i < gamesResponse.game_ids.length; i++) {
             gamePreviews.push({
-                ID: gamesResponse.GameIDs[i],
-                Level: gamesResponse.Levels[i]
+                ID: gamesResponse.game_ids[i],
+                Level: gamesResponse.levels[i]
             });
         }
         console.log(gamePreviews)
@@ -473,3 +559,49 @@ export async function getGame(gameID: number): Promise<GameObject | null> {
     }
     return null;
 }
+
+export async function createCheckoutSession(plan: 'monthly' | 'lifetime'): Promise<string | null> {
+    try {
+        let token;
+        authStore.subscribe((value) => {
+            token = value.token;
+        })();
+        const response = await fetch(`${API_URL}/billing/checkout`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', 'Authorization':`Bearer ${token}` },
+            body: JSON.stringify({ plan }),
+        });
+
+        if (!response.ok) throw new Error(`HTTP error! Status: ${response.status}`);
+
+        const session: { id: string, url: string } = await response.json();
+        return session.url;
+    } catch (error) {
+        console.error('Error starting checkout:', error);
+    }
+    return null;
+}
+
+export async function getEntitlements(): Promise<Entitlements | null> {
+    try {
+        let token;
+        authStore.subscribe((value) => {
+            token = value.token;
+        })();
+        const response = await fetch(`${API_URL}/entitlements`, {
+            method: 'GET',
+            headers: { 'Authorization':`Bearer ${token}` },
+        });
+
+        if (!response.ok) throw new Error(`HTTP error! Status: ${response.status}`);
+
+        const body: EntitlementsResponse = await response.json();
+        if (typeof body?.entitlements?.premium_themes !== 'boolean') {
+            throw new Error('Unexpected entitlements response');
+        }
+        return body.entitlements;
+    } catch (error) {
+        console.error('Error loading entitlements:', error);
+    }
+    return null;
+}
@@ -0,0 +1,485 @@
+// Code generated by openapi-ts from src/backend/openapi/openapi.json. DO NOT EDIT.
+
+export interface AccessToken {
+    created_at: string;
+    expires_at: string;
+    id: number;
+    last_used_at: string | null;
+    name: string;
+    scopes: string[] | null;
+}
+
+export interface CheckoutSession {
+    id: string;
+    url: string;
+}
+
+/** A chest in a room, possibly holding a weapon. */
+export interface Chest {
+    CreatedAt: string;
+    DeletedAt: string | null;
+    ID: number;
+    Opened: boolean;
+    PosX: number;
+    PosY: number;
+    RoomInID: number | null;
+    UpdatedAt: string;
+    Weapon: Weapon | null;
+    WeaponID: number | null;
+}
+
+/** A chest in a room, possibly holding a weapon. Fields left out are saved as their zero value. */
+export interface ChestInput {
+    CreatedAt?: string;
+    DeletedAt?: string | null;
+    ID?: number;
+    Opened?: boolean;
+    PosX?: number;
+    PosY?: number;
+    RoomInID?: number | null;
+    UpdatedAt?: string;
+    Weapon?: WeaponInput | null;
+    WeaponID?: number | null;
+}
+
+/** An enemy in a room. */
+export interface Enemy {
+    CreatedAt: string;
+    CurrentHealth: number;
+    Damage: number;
+    DeletedAt: string | null;
+    ID: number;
+    Level: number;
+    MaxHealth: number;
+    PosX: number;
+    PosY: number;
+    Room: Room;
+    RoomID: number;
+    Sprite: string;
+    UpdatedAt: string;
+}
+
+/** An enemy in a room. Fields left out are saved as their zero value. */
+export interface EnemyInput {
+    CreatedAt?: string;
+    CurrentHealth?: number;
+    Damage?: number;
+    DeletedAt?: string | null;
+    ID?: number;
+    Level?: number;
+    MaxHealth?: number;
+    PosX?: number;
+    PosY?: number;
+    Room?: RoomInput;
+    RoomID?: number;
+    Sprite?: string;
+    UpdatedAt?: string;
+}
+
+export interface Entitlements {
+    /** Whether create_game and create_floor may be answered with a generation job */
+    async_generation: boolean;
+    daily_generations: number;
+    hard_difficulty: boolean;
+    premium_themes: boolean;
+    priority_generation: boolean;
+    save_slots: number;
+    tier: string;
+}
+
+/** The current user's limits and how much of them they used today. */
+export interface EntitlementsResponse {
+    entitlements: Entitlements;
+    usage: {
+        daily_generations: number;
+        save_slots: number;
+    };
+}
+
+/** The error envelope every failed request is answered with. */
+export interface Error {
+    error: {
+        /** Stable, machine readable error code, such as game_not_found */
+        code: string;
+        /** Extra data about the failure, such as the invalid fields or the current state of a resource */
+        details?: unknown;
+        /** Human readable description, which may change */
+        message: string;
+        /** ID of the request, also sent in the X-Request-ID header */
+        request_id?: string;
+    };
+}
+
+/** Why one field of a request is invalid. */
+export interface FieldError {
+    field: string;
+    message: string;
+}
+
+/** A floor of rooms generated for a theme. */
+export interface Floor {
+    Adjacency: string[][] | null;
+    CreatedAt: string;
+    DeletedAt: string | null;
+    FloorMap: number[][] | null;
+    ID: number;
+    PlayerInID: number;
+    Rooms: Room[] | null;
+    Seed: number;
+    StoryText: string;
+    Theme: string;
+    UpdatedAt: string;
+    Version: number;
+}
+
+export interface FloorConfig {
+    /** hard requires a subscription */
+    difficulty: "easy" | "medium" | "hard";
+    /** Story of the previous floor, left out for the first one */
+    lastStory?: string;
+    /** Theme of the previous floor, left out for the first one */
+    lastTheme?: "castle" | "jungle" | "desert" | "None";
+    level: number;
+    /** desert requires a subscription */
+    theme: "castle" | "jungle" | "desert";
+}
+
+/** A floor of rooms generated for a theme. Fields left out are saved as their zero value. */
+export interface FloorInput {
+    Adjacency?: string[][] | null;
+    CreatedAt?: string;
+    DeletedAt?: string | null;
+    FloorMap?: number[][] | null;
+    ID?: number;
+    PlayerInID?: number;
+    Rooms?: RoomInput[] | null;
+    Seed?: number;
+    StoryText?: string;
+    Theme?: string;
+    UpdatedAt?: string;
+    Version?: number;
+}
+
+/** A game with its player and current floor. Game objects keep the field names of the server models. */
+export interface Game {
+    CreatedAt: string;
+    DeletedAt: string | null;
+    Difficulty: string;
+    Floor: Floor;
+    FloorID: number;
+    ID: number;
+    Level: number;
+    Player: Player;
+    PlayerID: number;
+    PlayerSpecifications: string;
+    UpdatedAt: string;
+    UserID: number;
+    Version: number;
+}
+
+export interface GameConfig {
+    /** hard requires a subscription */
+    difficulty: "easy" | "medium" | "hard";
+    /** desert requires a subscription */
+    theme: "castle" | "jungle" | "desert";
+}
+
+/** A game with its player and current floor. Game objects keep the field names of the server models. Fields left out are saved as their zero value. */
+export interface GameInput {
+    CreatedAt?: string;
+    DeletedAt?: string | null;
+    Difficulty?: string;
+    Floor?: FloorInput;
+    FloorID?: number;
+    ID?: number;
+    Level?: number;
+    Player?: PlayerInput;
+    PlayerID?: number;
+    PlayerSpecifications?: string;
+    UpdatedAt?: string;
+    UserID?: number;
+    Version?: number;
+}
+
+export interface GameOperation {
+    chest_id?: number;
+    current_health?: number;
+    enemy_id?: number;
+    op: "player_moved" | "enemy_damaged" | "enemy_killed" | "room_cleared" | "chest_opened" | "weapon_equipped";
+    room_id?: number;
+    slot?: "primary" | "secondary";
+    weapon_id?: number;
+    x?: number;
+    y?: number;
+}
+
+/** An AI generation run in the background. */
+export interface GenerationJob {
+    created_at: string;
+    /** Once the job has failed, the error create_game or create_floor would have answered with */
+    error: {
+        code: string;
+        /** Extra data about the failure */
+        details?: unknown;
+        message: string;
+    } | null;
+    id: number;
+    kind: "game" | "floor";
+    /** Once the job has succeeded, the body create_game or create_floor would have answered with */
+    result: unknown | null;
+    status: "pending" | "succeeded" | "failed";
+    updated_at: string;
+}
+
+/** Either the tokens of a new session or, when two_factor_required is set, a challenge_token for /login/2fa. */
+export interface LoginResponse {
+    access_token?: string;
+    challenge_token?: string;
+    message: string;
+    new_account?: boolean;
+    refresh_token?: string;
+    two_factor_required?: boolean;
+    user_id?: number;
+}
+
+export interface Message {
+    message: string;
+}
+
+/** The player of a game. */
+export interface Player {
+    CreatedAt: string;
+    CurrentHealth: number;
+    DeletedAt: string | null;
+    ID: number;
+    MaxHealth: number;
+    PosX: number;
+    PosY: number;
+    PrimaryWeapon: Weapon | null;
+    PrimaryWeaponID: number | null;
+    SecondaryWeapon: Weapon | null;
+    SecondaryWeaponID: number | null;
+    SpriteName: string;
+    UpdatedAt: string;
+    Version: number;
+}
+
+/** The player of a game. Fields left out are saved as their zero value. */
+export interface PlayerInput {
+    CreatedAt?: string;
+    CurrentHealth?: number;
+    DeletedAt?: string | null;
+    ID?: number;
+    MaxHealth?: number;
+    PosX?: number;
+    PosY?: number;
+    PrimaryWeapon?: WeaponInput | null;
+    PrimaryWeaponID?: number | null;
+    SecondaryWeapon?: WeaponInput | null;
+    SecondaryWeaponID?: number | null;
+    SpriteName?: string;
+    UpdatedAt?: string;
+    Version?: number;
+}
+
+export interface Preferences {
+    color_scheme: "" | "light" | "dark" | "system";
+    language: string;
+    reduce_motion: boolean;
+    show_damage_numbers: boolean;
+}
+
+export interface Profile {
+    created_at: string;
+    deletion_scheduled_at: string | null;
+    email: string;
+    email_verified: boolean;
+    has_password: boolean;
+    id: number;
+    is_guest: boolean;
+    preferences: Preferences;
+    role: string;
+    subscription_level: number;
+    two_factor_enabled: boolean;
+    username: string;
+}
+
+/** A room of a floor. */
+export interface Room {
+    BottomID: number | null;
+    Chest: Chest | null;
+    ChestID: number | null;
+    Cleared: boolean;
+    CreatedAt: string;
+    DeletedAt: string | null;
+    Enemies: Enemy[] | null;
+    Floor: Floor | null;
+    FloorID: number | null;
+    ID: number;
+    LeftID: number | null;
+    RightID: number | null;
+    StairX: number | null;
+    StairY: number | null;
+    /** One string per row of tiles */
+    Tiles: string[] | null;
+    TopID: number | null;
+    Type: number | null;
+    UpdatedAt: string;
+    X: number;
+    Y: number;
+}
+
+/** A room of a floor. Fields left out are saved as their zero value. */
+export interface RoomInput {
+    BottomID?: number | null;
+    Chest?: ChestInput | null;
+    ChestID?: number | null;
+    Cleared?: boolean;
+    CreatedAt?: string;
+    DeletedAt?: string | null;
+    Enemies?: EnemyInput[] | null;
+    Floor?: FloorInput | null;
+    FloorID?: number | null;
+    ID?: number;
+    LeftID?: number | null;
+    RightID?: number | null;
+    StairX?: number | null;
+    StairY?: number | null;
+    /** One string per row of tiles */
+    Tiles?: string[] | null;
+    TopID?: number | null;
+    Type?: number | null;
+    UpdatedAt?: string;
+    X?: number;
+    Y?: number;
+}
+
+export interface SaveChest {
+    id: number;
+    opened: boolean;
+    pos_x: number;
+    pos_y: number;
+    weapon: SaveWeapon | null;
+}
+
+export interface SaveEnemy {
+    current_health: number;
+    damage: number;
+    id: number;
+    level: number;
+    max_health: number;
+    pos_x: number;
+    pos_y: number;
+    sprite: string;
+}
+
+/** A portable save file, as exported by /game/{id}/export. */
+export interface SaveFile {
+    config: {
+        difficulty: string;
+        level: number;
+        theme: string;
+    };
+    exported_at: string;
+    floor: SaveFloor;
+    format: string;
+    game: {
+        id: number;
+        player_specifications: string;
+        version: number;
+    };
+    player: SavePlayer;
+    schema_version: number;
+    seed: number;
+}
+
+export interface SaveFloor {
+    adjacency: string[][] | null;
+    floor_map: number[][] | null;
+    id: number;
+    rooms: SaveRoom[] | null;
+    story_text: string;
+    theme: string;
+}
+
+export interface SavePlayer {
+    current_health: number;
+    id: number;
+    max_health: number;
+    pos_x: number;
+    pos_y: number;
+    primary_weapon: SaveWeapon | null;
+    secondary_weapon: SaveWeapon | null;
+    sprite_name: string;
+}
+
+export interface SaveRoom {
+    bottom_id: number | null;
+    chest: SaveChest | null;
+    cleared: boolean;
+    enemies: SaveEnemy[] | null;
+    id: number;
+    left_id: number | null;
+    right_id: number | null;
+    stair_x: number | null;
+    stair_y: number | null;
+    tiles: string[] | null;
+    top_id: number | null;
+    type: number | null;
+    x: number;
+    y: number;
+}
+
+export interface SaveWeapon {
+    damage: number;
+    id: number;
+    sprite: string;
+    type: number;
+}
+
+export interface Session {
+    created_at: string;
+    current: boolean;
+    id: string;
+    ip: string;
+    last_used_at: string;
+    user_agent: string;
+}
+
+export interface Snapshot {
+    created_at: string;
+    game_id: number;
+    id: number;
+    reason: "save" | "restore" | "import";
+    version: number;
+}
+
+export interface Subscription {
+    cancel_at_period_end: boolean;
+    current_period_end: string | null;
+    current_period_start: string | null;
+    plan: string;
+    status: string;
+}
+
+/** A weapon. Type is 0 for melee, 1 ranged, 2 sweep and 3 area of effect. */
+export interface Weapon {
+    CreatedAt: string;
+    Damage: number;
+    DeletedAt: string | null;
+    ID: number;
+    Sprite: string;
+    Type: number;
+    UpdatedAt: string;
+}
+
+/** A weapon. Type is 0 for melee, 1 ranged, 2 sweep and 3 area of effect. Fields left out are saved as their zero value. */
+export interface WeaponInput {
+    CreatedAt?: string;
+    Damage?: number;
+    DeletedAt?: string | null;
+    ID?: number;
+    Sprite?: string;
+    Type?: number;
+    UpdatedAt?: string;
+}
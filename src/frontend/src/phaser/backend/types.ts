@@ -1,74 +1,45 @@
 import { Game, GameObjects } from "phaser";
 import type { EnemyHealthBar } from "../ui/EnemyHealthBar";
+import type * as schema from "./schema";
 
-export interface PlayerObject {
-    ID: number;
-    MaxHealth: number;
-    CurrentHealth: number;
-    PosX: number;
-    PosY: number;
+// The objects the game works with pick their fields from the server's types in
+// schema.ts, generated from the backend's OpenAPI specification, and add the
+// state that only exists in Phaser.
+
+export interface PlayerObject extends Pick<schema.Player, "ID" | "MaxHealth" | "CurrentHealth" | "PosX" | "PosY" | "SpriteName"> {
     PrimaryWeapon: WeaponObject;
-    SpriteName: string;
     SpriteObject?: Phaser.GameObjects.Sprite; // Initialized in Phaser
 }
 
-export interface EnemyObject {
-    ID: number;
-    MaxHealth: number;
-    CurrentHealth: number;
-    PosX: number;
-    PosY: number;
-    Damage: number;
-    Sprite: string;
+export interface EnemyObject extends Pick<schema.Enemy, "ID" | "MaxHealth" | "CurrentHealth" | "PosX" | "PosY" | "Damage" | "Sprite"> {
     SpriteObject?: Phaser.GameObjects.Sprite; // Initialized in Phaser
     Level: 1 | 2 | 3; // 1: low-health, low-damage, 2: low-health, high-damage, 3: high-health, high-damage
     healthBar?: EnemyHealthBar; // Initialized in Phaser
 }
 
-export interface WeaponObject {
-    ID: number;
-    Sprite: string;
-    Damage: number;
+export interface WeaponObject extends Pick<schema.Weapon, "ID" | "Sprite" | "Damage"> {
     Type: 0 | 1 | 2 | 3; // 0: Melee, 1: Ranged, 2: Sweep, 3: AoE
 }
 
-export interface RoomObject {
-    ID: number;
+export interface RoomObject extends Pick<schema.Room, "ID" | "TopID" | "BottomID" | "LeftID" | "RightID" | "Cleared" | "StairX" | "StairY"> {
     Type: 0 | 1 | 2; // 0: Normal, 1: Chest, 2: Stair
-    Tiles: string;
+    Tiles: string[]; // One string per row of tiles
     Enemies: EnemyObject[];
-    TopID: number | null;
-    BottomID: number | null;
-    LeftID: number | null;
-    RightID: number | null;
-    Cleared: boolean;
     Chest: ChestObject | null;
-    StairX: number | null;
-    StairY: number | null;
 }
 
-export interface FloorObject {
-    ID: number;
+export interface FloorObject extends Pick<schema.Floor, "ID" | "StoryText" | "Theme"> {
     Rooms: RoomObject[];
-    StoryText: string;
-    Theme: string;
 }
 
-export interface ChestObject {
-    ID: number;
-    RoomInID: number;
+export interface ChestObject extends Pick<schema.Chest, "ID" | "RoomInID" | "Opened" | "PosX" | "PosY"> {
     Weapon: WeaponObject;
-    Opened: boolean;
-    PosX: number;
-    PosY: number;
     SpriteObject?: Phaser.GameObjects.Sprite; // Initialized in Phaser
 }
 
-export interface GameObject {
-    ID: number;
+export interface GameObject extends Pick<schema.Game, "ID" | "Level" | "Version"> {
     Player: PlayerObject;
     Floor: FloorObject;
-    Level: number;
 }
 
 export interface GameResponse {
@@ -85,6 +56,9 @@ export interface GamePreview {
 }
 
 export interface GamesResponse {
-    GameIDs: number[];
-    Levels: number[];
-}
\ No newline at end of file
+    user_id: number;
+    game_ids: number[];
+    levels: number[];
+}
+
+export type APIError = schema.Error;
\ No newline at end of file
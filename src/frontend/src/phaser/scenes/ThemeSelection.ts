@@ -1,5 +1,6 @@
 import Phaser from 'phaser';
 import type { GameObject } from '../backend/types';
+import { getEntitlements } from '../backend/API';
 
 export class ThemeSelection extends Phaser.Scene {
     constructor() {
@@ -10,7 +11,7 @@ export class ThemeSelection extends Phaser.Scene {
         this.gameData = data.gameData;
     }
 
-    create() {
+    async create() {
         const { width, height } = this.scale;
 
         // Create buttons
@@ -28,6 +29,15 @@ export class ThemeSelection extends Phaser.Scene {
             .on('pointerout', () => fantasyButton.setColor('#fff'))
             .on('pointerdown', () => this.startGame('jungle'));
 
+        // Desert is a premium theme; the server refuses it without a subscription
+        const entitlements = await getEntitlements();
+        if (entitlements && !entitlements.premium_themes) {
+            this.add.text(width / 2 + 300, height / 2, 'Desert', { fontFamily: 'cc-pixel-arcade-display', fontSize: '48px', color: '#777' })
+                .setOrigin(0.5);
+            this.add.text(width / 2 + 300, height / 2 + 50, 'Premium', { fontFamily: 'cc-pixel-arcade-display', fontSize: '24px', color: '#777' })
+                .setOrigin(0.5);
+            return;
+        }
         const dungeonButton = this.add.text(width / 2 + 300, height / 2, 'Desert', { fontFamily: 'cc-pixel-arcade-display', fontSize: '48px', color: '#fff' })
             .setOrigin(0.5)
             .setInteractive({ useHandCursor: true })
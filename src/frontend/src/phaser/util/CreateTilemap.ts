@@ -83,7 +83,7 @@ const generateTileIDs = (charMatrix: string[][]): number[][] => {
     return convertedTilemap;
 }
 
-export const createTilemap = (scene: Phaser.Scene, tiles: string, tilesetKey: string, room: RoomObject): Phaser.Tilemaps.Tilemap => {
+export const createTilemap = (scene: Phaser.Scene, tiles: string[], tilesetKey: string, room: RoomObject): Phaser.Tilemaps.Tilemap => {
     const tileMatrix = convertToMatrix(tiles);
     // Create pathways for other rooms
     if (room.TopID) {
@@ -141,15 +141,6 @@ const assignCollisions = (map: Phaser.Tilemaps.Tilemap) => {
     });
 }
 
-const convertToMatrix = (charString: string): string[][] => {
-    const rows = Math.ceil(charString.length / 13);
-    const matrix: string[][] = [];
-
-    for (let i = 0; i < rows; i++) {
-        const start = i * 13;
-        const end = start + 13;
-        matrix.push(charString.slice(start, end).split(''));
-    }
-
-    return matrix;
+const convertToMatrix = (tileRows: string[]): string[][] => {
+    return tileRows.map(row => row.split(''));
 }
\ No newline at end of file